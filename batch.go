@@ -0,0 +1,131 @@
+package p4
+
+import "strings"
+
+// BatchMode is how commands with too many file arguments for one command
+// line are run
+type BatchMode int
+
+const (
+	// BatchStdin passes the file arguments on stdin with p4 -x -
+	BatchStdin BatchMode = iota
+	// BatchSplit runs the command several times with some of the file
+	// arguments each time, returning all the results in order
+	BatchSplit
+)
+
+// DefaultMaxArgSize is the total size in bytes of file arguments above
+// which they are batched. It allows for the 32K limit on Windows.
+const DefaultMaxArgSize = 16 * 1024
+
+// Options which take a value for commands that take file arguments.
+// Anything after the last option, and its value, is treated as a file argument.
+var fileCommandValueFlags = map[string][]string{
+	"add":       {"-c", "-t"},
+	"changes":   {"-c", "-e", "-m", "-s", "-u"},
+	"copy":      {"-b", "-c", "-m", "-P", "-S"},
+	"delete":    {"-c"},
+	"diff":      {"-m"},
+	"dirs":      {"-S"},
+	"edit":      {"-c", "-t"},
+	"filelog":   {"-m"},
+	"files":     {"-m"},
+	"fixes":     {"-c", "-j", "-m"},
+	"fstat":     {"-A", "-c", "-e", "-F", "-m", "-T"},
+	"integ":     {"-b", "-c", "-m", "-P", "-S", "-s"},
+	"integrate": {"-b", "-c", "-m", "-P", "-S", "-s"},
+	"labelsync": {"-l"},
+	"lock":      {"-c"},
+	"merge":     {"-b", "-c", "-m", "-P", "-S", "-s"},
+	"opened":    {"-c", "-C", "-m", "-u"},
+	"print":     {"-m", "-o"},
+	"reopen":    {"-c", "-t"},
+	"resolve":   {"-c"},
+	"revert":    {"-c", "-C"},
+	"shelve":    {"-c"},
+	"sizes":     {"-b", "-m"},
+	"sync":      {"-m"},
+	"tag":       {"-l"},
+	"unlock":    {"-c"},
+	"unshelve":  {"-b", "-c", "-s", "-S"},
+}
+
+// splitFileArgs splits a command's args into the command and its options,
+// and the file arguments which follow them. Only the commands known to take
+// file arguments, in fileCommandValueFlags, have any.
+func splitFileArgs(args []string) ([]string, []string) {
+	if len(args) < 2 {
+		return args, nil
+	}
+	if _, ok := fileCommandValueFlags[args[0]]; !ok {
+		return args, nil
+	}
+	start := 1
+	for i := len(args) - 1; i > 0; i-- {
+		if strings.HasPrefix(args[i], "-") {
+			start = i + 1
+			if takesValue(args[0], args[i]) {
+				start++
+			}
+			break
+		}
+	}
+	if start >= len(args) {
+		return args, nil
+	}
+	return args[:start], args[start:]
+}
+
+// takesValue reports whether a command's option is followed by a value
+func takesValue(command string, flag string) bool {
+	for _, f := range fileCommandValueFlags[command] {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// argsSize returns the total length of args on a command line
+func argsSize(args []string) int {
+	size := 0
+	for _, a := range args {
+		size += len(a) + 1
+	}
+	return size
+}
+
+// maxArgSize returns the size of file arguments above which they are batched
+func (p4 *P4) maxArgSize() int {
+	if p4.argSizeLimit == 0 {
+		return DefaultMaxArgSize
+	}
+	if p4.argSizeLimit < 0 {
+		return int(^uint(0) >> 1)
+	}
+	return p4.argSizeLimit
+}
+
+// runSplit runs cmdArgs several times with as many files as fit each time.
+// It stops at the first command to fail, returning the results so far.
+func (p4 *P4) runSplit(cmdArgs []string, files []string) ([]map[interface{}]interface{}, []byte, error) {
+	results := make([]map[interface{}]interface{}, 0)
+	var data []byte
+	max := p4.maxArgSize()
+	for len(files) > 0 {
+		n, size := 0, 0
+		for n < len(files) && (n == 0 || size+len(files[n])+1 <= max) {
+			size += len(files[n]) + 1
+			n++
+		}
+		args := append(append([]string{}, cmdArgs...), files[:n]...)
+		res, out, err := p4.runMarshal(nil, args, nil)
+		results = append(results, res...)
+		data = append(data, out...)
+		if err != nil {
+			return results, data, err
+		}
+		files = files[n:]
+	}
+	return results, data, nil
+}
@@ -0,0 +1,105 @@
+package p4
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type splitFileArgsTest struct {
+	args  []string
+	cmd   []string
+	files []string
+}
+
+var splitFileArgsTests = []splitFileArgsTest{
+	{args: []string{"info"}, cmd: []string{"info"}},
+	{args: []string{"edit", "a", "b"}, cmd: []string{"edit"}, files: []string{"a", "b"}},
+	{args: []string{"edit", "-c", "12", "a", "b"}, cmd: []string{"edit", "-c", "12"}, files: []string{"a", "b"}},
+	{args: []string{"sync", "-f", "-s", "//a/...", "//b/..."}, cmd: []string{"sync", "-f", "-s"}, files: []string{"//a/...", "//b/..."}},
+	{args: []string{"fstat", "-T", "depotFile,headRev", "-Olp", "//a", "//b"}, cmd: []string{"fstat", "-T", "depotFile,headRev", "-Olp"}, files: []string{"//a", "//b"}},
+	{args: []string{"fstat", "-Ol", "-T", "depotFile", "//a"}, cmd: []string{"fstat", "-Ol", "-T", "depotFile"}, files: []string{"//a"}},
+	{args: []string{"changes", "-m", "10"}, cmd: []string{"changes", "-m", "10"}},
+	{args: []string{"diff", "-t", "a", "b"}, cmd: []string{"diff", "-t"}, files: []string{"a", "b"}},
+	{args: []string{"revert", "-C", "ws", "a", "b"}, cmd: []string{"revert", "-C", "ws"}, files: []string{"a", "b"}},
+	{args: []string{"unknown", "-m", "10", "//a"}, cmd: []string{"unknown", "-m", "10", "//a"}},
+	{args: []string{"describe", "-s", "12", "13"}, cmd: []string{"describe", "-s", "12", "13"}},
+	{args: []string{"counter", "-f", "change", "100"}, cmd: []string{"counter", "-f", "change", "100"}},
+}
+
+func TestSplitFileArgs(t *testing.T) {
+	for _, tst := range splitFileArgsTests {
+		cmd, files := splitFileArgs(tst.args)
+		assert.Equal(t, tst.cmd, cmd, tst.args)
+		assert.Equal(t, tst.files, files, tst.args)
+	}
+}
+
+func depotFiles(n int) []string {
+	files := make([]string, n)
+	for i := range files {
+		files[i] = fmt.Sprintf("//depot/dir/file%03d.c", i)
+	}
+	return files
+}
+
+func TestBatchStdin(t *testing.T) {
+	dir := t.TempDir()
+	argsFile := filepath.Join(dir, "args")
+	stdinFile := filepath.Join(dir, "stdin")
+	exe := fakeP4Executable(t, fmt.Sprintf("echo \"$*\" >> %s\ncat > %s\n", argsFile, stdinFile))
+
+	files := depotFiles(10)
+	p4 := NewP4(WithExecutable(exe), WithMaxArgSize(100))
+	_, err := p4.Run(append([]string{"fstat", "-T", "depotFile"}, files...))
+	assert.Nil(t, err)
+	args, err := os.ReadFile(argsFile)
+	assert.Nil(t, err)
+	assert.Equal(t, "-G -x - fstat -T depotFile\n", string(args))
+	stdin, err := os.ReadFile(stdinFile)
+	assert.Nil(t, err)
+	assert.Equal(t, strings.Join(files, "\n")+"\n", string(stdin))
+
+	// Below the limit the files are passed as normal
+	os.Remove(argsFile)
+	_, err = p4.Run([]string{"fstat", "-T", "depotFile", files[0]})
+	assert.Nil(t, err)
+	args, err = os.ReadFile(argsFile)
+	assert.Nil(t, err)
+	assert.Equal(t, "-G fstat -T depotFile //depot/dir/file000.c\n", string(args))
+}
+
+func TestBatchSplit(t *testing.T) {
+	dir := t.TempDir()
+	argsFile := filepath.Join(dir, "args")
+	changes, err := filepath.Abs(filepath.Join(testRoot, "..", "testdata", "changes.bin"))
+	assert.Nil(t, err)
+	exe := fakeP4Executable(t, fmt.Sprintf("echo \"$*\" >> %s\ncat %s\n", argsFile, changes))
+
+	files := depotFiles(5)
+	// Room for two files per command
+	p4 := NewP4(WithExecutable(exe), WithMaxArgSize(2*(len(files[0])+1)), WithBatchMode(BatchSplit))
+	res, err := p4.Run(append([]string{"changes", "-m", "1"}, files...))
+	assert.Nil(t, err)
+	assert.Equal(t, 9, len(res))
+	assert.Equal(t, "3", res[0]["change"])
+	assert.Equal(t, "1", res[8]["change"])
+	args, err := os.ReadFile(argsFile)
+	assert.Nil(t, err)
+	assert.Equal(t, strings.Join([]string{
+		"-G changes -m 1 " + strings.Join(files[0:2], " "),
+		"-G changes -m 1 " + strings.Join(files[2:4], " "),
+		"-G changes -m 1 " + files[4],
+		""}, "\n"), string(args))
+
+	// Batching can be turned off
+	os.Remove(argsFile)
+	p4 = NewP4(WithExecutable(exe), WithMaxArgSize(-1), WithBatchMode(BatchSplit))
+	res, err = p4.Run(append([]string{"changes"}, files...))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(res))
+}
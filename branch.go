@@ -0,0 +1,246 @@
+package p4
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Branch is a branch spec, from p4 branch -o or a single result from p4 branches
+type Branch struct {
+	Branch      string
+	Owner       string
+	Update      string
+	Access      string
+	Description string
+	Options     string // locked or unlocked
+	View        []string
+}
+
+// Locked reports whether the branch's options include locked
+func (b Branch) Locked() bool {
+	return hasOption(b.Options, "locked")
+}
+
+func branchFromResult(r map[interface{}]interface{}) Branch {
+	b := Branch{
+		Branch:      getString(r, "Branch"),
+		Owner:       getString(r, "Owner"),
+		Update:      getString(r, "Update"),
+		Access:      getString(r, "Access"),
+		Description: getString(r, "Description"),
+		Options:     getString(r, "Options"),
+		View:        getIndexed(r, "View"),
+	}
+	// p4 branches uses lower case for the name
+	if b.Branch == "" {
+		b.Branch = getString(r, "branch")
+	}
+	return b
+}
+
+// spec returns the fields of the branch suitable for p4 branch -i.
+// Update and Access are set by the server so are not included.
+func (b Branch) spec() map[string]string {
+	spec := map[string]string{}
+	for k, v := range map[string]string{
+		"Branch":      b.Branch,
+		"Owner":       b.Owner,
+		"Description": b.Description,
+		"Options":     b.Options,
+		"View":        strings.Join(b.View, "\n"),
+	} {
+		if v != "" {
+			spec[k] = v
+		}
+	}
+	return spec
+}
+
+// ListBranches runs p4 branches args..., e.g. -u user, -e filter or -m max
+func ListBranches(p4r Runner, args []string) ([]Branch, error) {
+	args = append([]string{"branches"}, args...)
+	res, err := p4r.Run(args)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to run p4 %s\n%v", args, err)
+	}
+	branches := []Branch{}
+	for _, r := range res {
+		if getString(r, "code") == "error" {
+			return nil, parseError(r)
+		}
+		branches = append(branches, branchFromResult(r))
+	}
+	return branches, nil
+}
+
+// FetchBranch runs p4 branch -o name
+func FetchBranch(p4r Runner, name string) (Branch, error) {
+	args := []string{"branch", "-o", name}
+	res, err := p4r.Run(args)
+	if err != nil {
+		return Branch{}, fmt.Errorf("Failed to run p4 %s\n%v", args, err)
+	}
+	if len(res) == 0 {
+		return Branch{}, fmt.Errorf("No output from p4 %s", args)
+	}
+	if getString(res[0], "code") == "error" {
+		return Branch{}, parseError(res[0])
+	}
+	return branchFromResult(res[0]), nil
+}
+
+// SaveBranch runs p4 branch -i args... with the branch spec, e.g. args of
+// "-f" to update a locked branch owned by another user
+func SaveBranch(p4r SpecRunner, b Branch, args ...string) (string, error) {
+	return p4r.SaveTxt("branch", b.spec(), args...)
+}
+
+// DeleteBranch runs p4 branch -d args... name
+func DeleteBranch(p4r Runner, name string, args ...string) error {
+	return runDelete(p4r, "branch", name, args)
+}
+
+// IntegratedFile is a file opened by p4 integrate, copy or merge
+type IntegratedFile struct {
+	DepotFile    string
+	ClientFile   string
+	WorkRev      string
+	Action       string // e.g. integrate, branch, delete
+	FromFile     string
+	StartFromRev string
+	EndFromRev   string
+}
+
+// runIntegrate runs p4 integrate, copy or merge, with -b branch if b is
+// not nil. Warnings such as "all revision(s) already integrated" are ignored.
+func runIntegrate(p4r Runner, cmd string, b *Branch, args []string) ([]IntegratedFile, error) {
+	cmdArgs := []string{cmd}
+	if b != nil {
+		cmdArgs = append(cmdArgs, "-b", b.Branch)
+	}
+	args = append(cmdArgs, args...)
+	res, err := p4r.Run(args)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to run p4 %s\n%v", args, err)
+	}
+	files := []IntegratedFile{}
+	for _, r := range res {
+		rec := NewRecord(r)
+		if rec.Code() == "error" {
+			if severity, _ := rec.GetInt("severity"); severity > SeverityWarn {
+				return nil, parseError(r)
+			}
+			continue
+		}
+		if !rec.Has("depotFile") {
+			continue
+		}
+		files = append(files, IntegratedFile{DepotFile: rec.Get("depotFile"), ClientFile: rec.Get("clientFile"),
+			WorkRev: rec.Get("workRev"), Action: rec.Get("action"), FromFile: rec.Get("fromFile"),
+			StartFromRev: rec.Get("startFromRev"), EndFromRev: rec.Get("endFromRev")})
+	}
+	return files, nil
+}
+
+// Integrate runs p4 integrate -b branch args..., or p4 integrate args... if b is nil
+func Integrate(p4r Runner, b *Branch, args ...string) ([]IntegratedFile, error) {
+	return runIntegrate(p4r, "integrate", b, args)
+}
+
+// Copy runs p4 copy -b branch args..., or p4 copy args... if b is nil
+func Copy(p4r Runner, b *Branch, args ...string) ([]IntegratedFile, error) {
+	return runIntegrate(p4r, "copy", b, args)
+}
+
+// Merge runs p4 merge -b branch args..., or p4 merge args... if b is nil
+func Merge(p4r Runner, b *Branch, args ...string) ([]IntegratedFile, error) {
+	return runIntegrate(p4r, "merge", b, args)
+}
+
+// BranchFile is a source file and the target a branch view maps it to
+type BranchFile struct {
+	Source string
+	Target string
+	Line   int // index of the view line which maps the file
+}
+
+// BranchOverlap is a target which more than one source file maps to
+type BranchOverlap struct {
+	Target  string
+	Sources []string
+	Lines   []int
+}
+
+// BranchPreview is what a branch view would do with the files in the depot
+type BranchPreview struct {
+	Files    []BranchFile
+	Unmapped []int // indices of view lines which map no files
+	Overlaps []BranchOverlap
+}
+
+var rePositional = regexp.MustCompile(`%%[1-9]`)
+
+// PreviewBranch lists the files under the source of each line of a branch
+// view and maps them without opening anything. It reports lines which map
+// no files, either because there are none or because later lines override
+// them on the source or target side, and targets which more than one
+// source file maps to, which only overlay (+) lines allow.
+func PreviewBranch(p4r Runner, b *Branch) (*BranchPreview, error) {
+	view, err := ParseView(b.View)
+	if err != nil {
+		return nil, err
+	}
+	sources := []string{}
+	for _, ml := range view.Lines {
+		if !ml.Exclude {
+			// p4 files doesn't understand positional wildcards
+			sources = append(sources, rePositional.ReplaceAllString(ml.Source, "*"))
+		}
+	}
+	bp := &BranchPreview{Files: []BranchFile{}, Unmapped: []int{}, Overlaps: []BranchOverlap{}}
+	if len(sources) == 0 {
+		return bp, nil
+	}
+	files, err := RunFiles(p4r, append([]string{"-e"}, sources...))
+	if err != nil {
+		return nil, err
+	}
+	used := map[int]bool{}
+	seen := map[string]bool{}
+	byTarget := map[string][]BranchFile{}
+	for _, f := range files {
+		if seen[f.DepotFile] {
+			continue
+		}
+		seen[f.DepotFile] = true
+		target, line, ok := view.Match(f.DepotFile)
+		if !ok {
+			continue
+		}
+		bf := BranchFile{Source: f.DepotFile, Target: target, Line: line}
+		bp.Files = append(bp.Files, bf)
+		byTarget[target] = append(byTarget[target], bf)
+		used[line] = true
+	}
+	sort.Slice(bp.Files, func(i, j int) bool { return bp.Files[i].Source < bp.Files[j].Source })
+	for i, ml := range view.Lines {
+		if !ml.Exclude && !used[i] {
+			bp.Unmapped = append(bp.Unmapped, i)
+		}
+	}
+	for target, bfs := range byTarget {
+		if len(bfs) < 2 {
+			continue
+		}
+		o := BranchOverlap{Target: target}
+		for _, bf := range bfs {
+			o.Sources = append(o.Sources, bf.Source)
+			o.Lines = append(o.Lines, bf.Line)
+		}
+		bp.Overlaps = append(bp.Overlaps, o)
+	}
+	sort.Slice(bp.Overlaps, func(i, j int) bool { return bp.Overlaps[i].Target < bp.Overlaps[j].Target })
+	return bp, nil
+}
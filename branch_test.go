@@ -0,0 +1,109 @@
+package p4
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var relBranch = Branch{Branch: "rel-1.0", Owner: "bob", Description: "Release 1.0\n", Options: "unlocked",
+	View: []string{"//depot/main/... //depot/rel-1.0/...", "//depot/main/docs/... //depot/rel-1.0/...",
+		"//depot/main/tools/... //depot/rel-1.0/tools/...", "-//depot/main/tests/... //depot/rel-1.0/tests/..."}}
+
+func TestBranchSpec(t *testing.T) {
+	mp4 := &FakeP4Runner{}
+	mp4.On("Run", []string{"branch", "-o", "rel-1.0"}).Return([]map[interface{}]interface{}{
+		{"code": "stat", "Branch": "rel-1.0", "Owner": "bob", "Description": "Release 1.0\n", "Options": "locked",
+			"View0": "//depot/main/... //depot/rel-1.0/..."}}, nil)
+	mp4.On("Run", []string{"branches", "-e", "rel-*"}).Return([]map[interface{}]interface{}{
+		{"code": "stat", "branch": "rel-1.0", "Owner": "bob", "Options": "locked"}}, nil)
+	mp4.On("SaveTxt", "branch", map[string]string{"Branch": "rel-1.0", "Owner": "bob", "Description": "Release 1.0\n",
+		"Options": "unlocked", "View": "//depot/main/... //depot/rel-1.0/..."}, []string(nil)).Return("Branch rel-1.0 saved.\n", nil)
+	mp4.On("Run", []string{"branch", "-d", "rel-1.0"}).Return([]map[interface{}]interface{}{
+		{"code": "info", "data": "Branch rel-1.0 deleted."}}, nil)
+
+	b, err := FetchBranch(mp4, "rel-1.0")
+	assert.NoError(t, err)
+	assert.True(t, b.Locked())
+	assert.Equal(t, []string{"//depot/main/... //depot/rel-1.0/..."}, b.View)
+	branches, err := ListBranches(mp4, []string{"-e", "rel-*"})
+	assert.NoError(t, err)
+	assert.Equal(t, "rel-1.0", branches[0].Branch)
+
+	b.Options = "unlocked"
+	msg, err := SaveBranch(mp4, b)
+	assert.NoError(t, err)
+	assert.Equal(t, "Branch rel-1.0 saved.\n", msg)
+	assert.NoError(t, DeleteBranch(mp4, "rel-1.0"))
+}
+
+func TestIntegrateWithBranch(t *testing.T) {
+	mp4 := &FakeP4Runner{}
+	mp4.On("Run", []string{"integrate", "-b", "rel-1.0", "-n"}).Return([]map[interface{}]interface{}{
+		{"code": "stat", "depotFile": "//depot/rel-1.0/a.c", "clientFile": "/ws/rel-1.0/a.c", "workRev": "1",
+			"action": "branch", "fromFile": "//depot/main/a.c", "startFromRev": "none", "endFromRev": "3"}}, nil)
+	mp4.On("Run", []string{"copy", "-b", "rel-1.0"}).Return([]map[interface{}]interface{}{
+		{"code": "error", "data": "All revision(s) already integrated.\n", "severity": int32(2), "generic": int32(17)}}, nil)
+	mp4.On("Run", []string{"merge", "//depot/main/...", "//depot/dev/..."}).Return([]map[interface{}]interface{}{
+		{"code": "error", "data": "//depot/dev/... - no target file(s) in both client and branch view.\n",
+			"severity": int32(3), "generic": int32(2)}}, nil)
+
+	files, err := Integrate(mp4, &relBranch, "-n")
+	assert.NoError(t, err)
+	assert.Equal(t, []IntegratedFile{{DepotFile: "//depot/rel-1.0/a.c", ClientFile: "/ws/rel-1.0/a.c", WorkRev: "1",
+		Action: "branch", FromFile: "//depot/main/a.c", StartFromRev: "none", EndFromRev: "3"}}, files)
+	files, err = Copy(mp4, &relBranch)
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+	_, err = Merge(mp4, nil, "//depot/main/...", "//depot/dev/...")
+	assert.Error(t, err)
+}
+
+func TestPreviewBranch(t *testing.T) {
+	mp4 := &FakeP4Runner{}
+	b := Branch{Branch: "rel-1.0", View: []string{
+		"//depot/main/... //depot/rel-1.0/...",
+		"//depot/main/docs/index.html //depot/rel-1.0/index.html",
+		"//depot/main/tools/... //depot/rel-1.0/tools/...",
+		"-//depot/main/tests/... //depot/rel-1.0/tests/...",
+		"-//depot/other/... //depot/rel-1.0/secret/...",
+		"//depot/main/%%1.txt //depot/rel-1.0/notes/%%1.txt",
+		"+//depot/main/docs/a.c //depot/rel-1.0/a.c",
+	}}
+	res := []map[interface{}]interface{}{}
+	for _, f := range []string{"//depot/main/a.c", "//depot/main/index.html", "//depot/main/docs/index.html",
+		"//depot/main/tests/t.c", "//depot/main/secret/key.pem", "//depot/main/docs/index.html",
+		"//depot/main/README.txt", "//depot/main/docs/a.c"} {
+		res = append(res, map[interface{}]interface{}{"code": "stat", "depotFile": f, "rev": "1", "change": "1",
+			"action": "add", "type": "text", "time": "1612369118"})
+	}
+	res = append(res, noSuchFiles)
+	mp4.On("Run", []string{"files", "-e", "//depot/main/...", "//depot/main/docs/index.html", "//depot/main/tools/...",
+		"//depot/main/*.txt", "//depot/main/docs/a.c"}).Return(res, nil)
+
+	bp, err := PreviewBranch(mp4, &b)
+	assert.NoError(t, err)
+	// index.html is remapped on the target side by line 1 and secret/key.pem
+	// is excluded on the target side by line 4
+	assert.Equal(t, []BranchFile{
+		{Source: "//depot/main/README.txt", Target: "//depot/rel-1.0/notes/README.txt", Line: 5},
+		{Source: "//depot/main/a.c", Target: "//depot/rel-1.0/a.c", Line: 0},
+		{Source: "//depot/main/docs/a.c", Target: "//depot/rel-1.0/a.c", Line: 6},
+		{Source: "//depot/main/docs/index.html", Target: "//depot/rel-1.0/index.html", Line: 1},
+	}, bp.Files)
+	assert.Equal(t, []int{2}, bp.Unmapped)
+	assert.Equal(t, []BranchOverlap{{Target: "//depot/rel-1.0/a.c",
+		Sources: []string{"//depot/main/a.c", "//depot/main/docs/a.c"}, Lines: []int{0, 6}}}, bp.Overlaps)
+
+	// A later line which maps the whole target leaves the first line nothing
+	mp4 = &FakeP4Runner{}
+	mp4.On("Run", []string{"files", "-e", "//depot/main/...", "//depot/main/docs/..."}).Return(res, nil)
+	bp, err = PreviewBranch(mp4, &Branch{View: []string{"//depot/main/... //depot/rel-1.0/...",
+		"//depot/main/docs/... //depot/rel-1.0/..."}})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0}, bp.Unmapped)
+	assert.Empty(t, bp.Overlaps)
+
+	_, err = PreviewBranch(mp4, &Branch{View: []string{"//depot/main/..."}})
+	assert.Error(t, err)
+}
@@ -1,6 +1,13 @@
 package p4
 
-import "github.com/stretchr/testify/mock"
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+)
 
 // FakeP4Runner is a mockable P4 Runner
 type FakeP4Runner struct {
@@ -12,3 +19,22 @@ func (mock *FakeP4Runner) Run(args []string) ([]map[interface{}]interface{}, err
 	ags := mock.Called(args)
 	return ags.Get(0).([]map[interface{}]interface{}), ags.Error(1)
 }
+
+// SaveTxt Mocks p4.SaveTxt, so we can save fake perforce specs
+func (mock *FakeP4Runner) SaveTxt(specName string, specContents map[string]string, args ...string) (string, error) {
+	ags := mock.Called(specName, specContents, args)
+	return ags.String(0), ags.Error(1)
+}
+
+// fakeP4Executable writes a shell script to use as the p4 binary, so that
+// commands can be tested without a server
+func fakeP4Executable(t *testing.T, script string) string {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake p4 executables are shell scripts")
+	}
+	fname := filepath.Join(t.TempDir(), "p4")
+	if err := os.WriteFile(fname, []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return fname
+}
@@ -0,0 +1,135 @@
+package p4
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// runValue runs p4 counter or p4 key args... and returns the value of the
+// named counter or key, which is 0 if it isn't set
+func runValue(p4r Runner, cmd string, args []string) (int64, error) {
+	args = append([]string{cmd}, args...)
+	res, err := p4r.Run(args)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to run p4 %s\n%v", args, err)
+	}
+	var value int64
+	for _, r := range res {
+		rec := NewRecord(r)
+		if rec.Code() == "error" {
+			return 0, parseError(r)
+		}
+		if rec.Has("value") {
+			if value, err = rec.GetInt("value"); err != nil {
+				return 0, fmt.Errorf("%s %s is not a number: %v", cmd, rec.Get(cmd), err)
+			}
+		}
+	}
+	return value, nil
+}
+
+// runValues runs p4 counters or p4 keys args... and returns the values by
+// name. Values which aren't numbers are skipped.
+func runValues(p4r Runner, cmd string, args []string) (map[string]int64, error) {
+	args = append([]string{cmd + "s"}, args...)
+	res, err := p4r.Run(args)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to run p4 %s\n%v", args, err)
+	}
+	values := map[string]int64{}
+	for _, r := range res {
+		rec := NewRecord(r)
+		if rec.Code() == "error" {
+			return nil, parseError(r)
+		}
+		// Servers keep text in some, e.g. lastCheckpointAction, or JSON from Swarm
+		if value, err := rec.GetInt("value"); err == nil {
+			values[rec.Get(cmd)] = value
+		}
+	}
+	return values, nil
+}
+
+// GetCounter runs p4 counter name, returning 0 if it isn't set
+func GetCounter(p4r Runner, name string) (int64, error) {
+	return runValue(p4r, "counter", []string{name})
+}
+
+// SetCounter runs p4 counter args... name value, e.g. args of "-f" to set
+// a protected counter such as change
+func SetCounter(p4r Runner, name string, value int64, args ...string) error {
+	_, err := runValue(p4r, "counter", append(append([]string{}, args...), name, strconv.FormatInt(value, 10)))
+	return err
+}
+
+// IncrementCounter runs p4 counter -i name, which adds one to the counter
+// on the server, and returns the new value
+func IncrementCounter(p4r Runner, name string) (int64, error) {
+	return runValue(p4r, "counter", []string{"-i", name})
+}
+
+// DeleteCounter runs p4 counter -d args... name
+func DeleteCounter(p4r Runner, name string, args ...string) error {
+	_, err := runValue(p4r, "counter", append(append([]string{"-d"}, args...), name))
+	return err
+}
+
+// ListCounters runs p4 counters, or p4 counters -e pattern if pattern is
+// not empty, and returns the numeric values by name
+func ListCounters(p4r Runner, pattern string) (map[string]int64, error) {
+	args := []string{}
+	if pattern != "" {
+		args = append(args, "-e", pattern)
+	}
+	return runValues(p4r, "counter", args)
+}
+
+// GetKey runs p4 key name, returning 0 if it isn't set
+func GetKey(p4r Runner, name string) (int64, error) {
+	return runValue(p4r, "key", []string{name})
+}
+
+// SetKey runs p4 key name value
+func SetKey(p4r Runner, name string, value int64) error {
+	_, err := runValue(p4r, "key", []string{name, strconv.FormatInt(value, 10)})
+	return err
+}
+
+// IncrementKey runs p4 key -i name, which adds one to the key on the
+// server, and returns the new value
+func IncrementKey(p4r Runner, name string) (int64, error) {
+	return runValue(p4r, "key", []string{"-i", name})
+}
+
+// DeleteKey runs p4 key -d name
+func DeleteKey(p4r Runner, name string) error {
+	_, err := runValue(p4r, "key", []string{"-d", name})
+	return err
+}
+
+// ListKeys runs p4 keys, or p4 keys -e pattern if pattern is not empty,
+// and returns the numeric values by name
+func ListKeys(p4r Runner, pattern string) (map[string]int64, error) {
+	args := []string{}
+	if pattern != "" {
+		args = append(args, "-e", pattern)
+	}
+	return runValues(p4r, "key", args)
+}
+
+// CompareAndSetKey runs p4 key --from old --to value name, which sets the
+// key to value only if its value is old. It returns false if the key had
+// another value, so the caller can read it again and retry.
+func CompareAndSetKey(p4r Runner, name string, old int64, value int64) (bool, error) {
+	_, err := runValue(p4r, "key", []string{"--from", strconv.FormatInt(old, 10),
+		"--to", strconv.FormatInt(value, 10), name})
+	if err == nil {
+		return true, nil
+	}
+	// Check the value rather than depend on the wording of the error
+	current, gerr := GetKey(p4r, name)
+	if gerr == nil && current != old {
+		return false, nil
+	}
+	return false, err
+}
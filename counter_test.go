@@ -0,0 +1,91 @@
+package p4
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCounters(t *testing.T) {
+	mp4 := &FakeP4Runner{}
+	mp4.On("Run", []string{"counter", "review"}).Return([]map[interface{}]interface{}{
+		{"code": "stat", "counter": "review", "value": "4294967296"}}, nil)
+	mp4.On("Run", []string{"counter", "-f", "change", "100"}).Return([]map[interface{}]interface{}{
+		{"code": "stat", "counter": "change", "value": "100"}}, nil)
+	mp4.On("Run", []string{"counter", "-i", "build"}).Return([]map[interface{}]interface{}{
+		{"code": "stat", "counter": "build", "value": "8"}}, nil)
+	mp4.On("Run", []string{"counter", "-d", "build"}).Return([]map[interface{}]interface{}{
+		{"code": "error", "data": "You don't have permission for this operation.\n", "severity": int32(3), "generic": int32(6)}}, nil)
+	mp4.On("Run", []string{"counter", "name"}).Return([]map[interface{}]interface{}{
+		{"code": "stat", "counter": "name", "value": "bob"}}, nil)
+	mp4.On("Run", []string{"counters", "-e", "rev*"}).Return([]map[interface{}]interface{}{
+		{"code": "stat", "counter": "review", "value": "42"}, {"code": "stat", "counter": "reviewer", "value": "0"}}, nil)
+
+	v, err := GetCounter(mp4, "review")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4294967296), v)
+	assert.NoError(t, SetCounter(mp4, "change", 100, "-f"))
+	v, err = IncrementCounter(mp4, "build")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(8), v)
+	assert.Error(t, DeleteCounter(mp4, "build"))
+	_, err = GetCounter(mp4, "name")
+	assert.Error(t, err)
+	counters, err := ListCounters(mp4, "rev*")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int64{"review": 42, "reviewer": 0}, counters)
+}
+
+func TestKeys(t *testing.T) {
+	mp4 := &FakeP4Runner{}
+	mp4.On("Run", []string{"key", "lock"}).Return([]map[interface{}]interface{}{
+		{"code": "stat", "key": "lock", "value": "3"}}, nil).Once()
+	mp4.On("Run", []string{"key", "lock", "5"}).Return([]map[interface{}]interface{}{}, nil)
+	mp4.On("Run", []string{"key", "-i", "lock"}).Return([]map[interface{}]interface{}{
+		{"code": "stat", "key": "lock", "value": "6"}}, nil)
+	mp4.On("Run", []string{"key", "-d", "lock"}).Return([]map[interface{}]interface{}{}, nil)
+	mp4.On("Run", []string{"keys"}).Return([]map[interface{}]interface{}{
+		{"code": "stat", "key": "lock", "value": "6"},
+		{"code": "stat", "key": "swarm-review-00001", "value": `{"type":"default","state":"needsReview"}`},
+		{"code": "stat", "key": "lastCheckpointAction", "value": "1612369118 (2021/02/03 16:18:38 +0000) checkpoint completed"}}, nil)
+
+	v, err := GetKey(mp4, "lock")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), v)
+	assert.NoError(t, SetKey(mp4, "lock", 5))
+	v, err = IncrementKey(mp4, "lock")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(6), v)
+	assert.NoError(t, DeleteKey(mp4, "lock"))
+	keys, err := ListKeys(mp4, "")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int64{"lock": 6}, keys)
+}
+
+func TestCompareAndSetKey(t *testing.T) {
+	mp4 := &FakeP4Runner{}
+	mismatch := []map[interface{}]interface{}{{"code": "error", "data": "Key 'hwm' value did not match.\n",
+		"severity": int32(3), "generic": int32(4)}}
+	mp4.On("Run", []string{"key", "--from", "10", "--to", "11", "hwm"}).Return([]map[interface{}]interface{}{
+		{"code": "stat", "key": "hwm", "value": "11"}}, nil)
+	mp4.On("Run", []string{"key", "--from", "11", "--to", "12", "hwm"}).Return(mismatch, nil)
+	mp4.On("Run", []string{"key", "hwm"}).Return([]map[interface{}]interface{}{
+		{"code": "stat", "key": "hwm", "value": "13"}}, nil)
+	mp4.On("Run", []string{"key", "--from", "13", "--to", "14", "hwm"}).Return(mismatch, nil)
+	mp4.On("Run", []string{"key", "--from", "1", "--to", "2", "down"}).Return(
+		[]map[interface{}]interface{}{}, errors.New("connect failed"))
+	mp4.On("Run", []string{"key", "down"}).Return([]map[interface{}]interface{}{}, errors.New("connect failed"))
+
+	ok, err := CompareAndSetKey(mp4, "hwm", 10, 11)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = CompareAndSetKey(mp4, "hwm", 11, 12)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	// The value matches, so the failure was for another reason
+	_, err = CompareAndSetKey(mp4, "hwm", 13, 14)
+	assert.Error(t, err)
+	_, err = CompareAndSetKey(mp4, "down", 1, 2)
+	assert.Error(t, err)
+}
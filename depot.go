@@ -0,0 +1,221 @@
+package p4
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Depot is a depot spec, from p4 depot -o or a single result from p4 depots
+type Depot struct {
+	Depot       string
+	Owner       string
+	Date        string
+	Description string
+	Type        string // local, stream, spec, remote, archive, unload, tangent, graph...
+	Address     string // remote depots
+	Suffix      string // spec depots
+	StreamDepth string // stream depots, e.g. //stream/1, or 1 from older servers
+	Map         string
+	SpecMap     []string // spec depots
+}
+
+func depotFromResult(r map[interface{}]interface{}) Depot {
+	d := Depot{
+		Depot:       getString(r, "Depot"),
+		Owner:       getString(r, "Owner"),
+		Date:        getString(r, "Date"),
+		Description: getString(r, "Description"),
+		Type:        getString(r, "Type"),
+		Address:     getString(r, "Address"),
+		Suffix:      getString(r, "Suffix"),
+		StreamDepth: getString(r, "StreamDepth"),
+		Map:         getString(r, "Map"),
+		SpecMap:     getIndexed(r, "SpecMap"),
+	}
+	// p4 depots uses different field names
+	if d.Depot == "" {
+		d.Depot = getString(r, "name")
+		d.Date = getString(r, "time")
+		d.Type = getString(r, "type")
+		d.Map = getString(r, "map")
+		d.Description = getString(r, "desc")
+		d.StreamDepth = getString(r, "streamDepth")
+		switch d.Type {
+		case "remote":
+			d.Address = getString(r, "extra")
+		case "spec":
+			d.Suffix = getString(r, "extra")
+		}
+	}
+	return d
+}
+
+// spec returns the fields of the depot suitable for p4 depot -i.
+// Date is set by the server so is not included.
+func (d Depot) spec() map[string]string {
+	spec := map[string]string{}
+	for k, v := range map[string]string{
+		"Depot":       d.Depot,
+		"Owner":       d.Owner,
+		"Description": d.Description,
+		"Type":        d.Type,
+		"Address":     d.Address,
+		"Suffix":      d.Suffix,
+		"StreamDepth": d.StreamDepth,
+		"Map":         d.Map,
+		"SpecMap":     strings.Join(d.SpecMap, "\n"),
+	} {
+		if v != "" {
+			spec[k] = v
+		}
+	}
+	return spec
+}
+
+// IsStream reports whether the depot holds streams
+func (d Depot) IsStream() bool {
+	return d.Type == "stream"
+}
+
+// Depth returns the number of directory levels under the depot which name
+// a stream, e.g. 2 for //stream/team/main, or 0 for depots without streams
+func (d Depot) Depth() int {
+	if !d.IsStream() {
+		return 0
+	}
+	s := d.StreamDepth
+	if i := strings.LastIndex(s, "/"); i >= 0 {
+		s = s[i+1:]
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		return 1
+	}
+	return n
+}
+
+// Stream returns the stream which a path in the depot belongs to, e.g.
+// //stream/main for //stream/main/src/a.c, or "" if the depot doesn't hold
+// streams or the path is not deep enough to be in one
+func (d Depot) Stream(path string) string {
+	depth := d.Depth()
+	if depth == 0 || DepotName(path) != d.Depot {
+		return ""
+	}
+	parts := strings.Split(strings.TrimPrefix(path, "//"), "/")
+	if len(parts) <= depth {
+		return ""
+	}
+	for _, p := range parts[1 : depth+1] {
+		if p == "" || strings.Contains(p, "...") || strings.ContainsAny(p, "*%@#") {
+			return ""
+		}
+	}
+	return "//" + strings.Join(parts[:depth+1], "/")
+}
+
+// DepotName returns the depot of a depot path, e.g. depot for
+// //depot/main/..., or "" if it isn't a depot path
+func DepotName(path string) string {
+	if !strings.HasPrefix(path, "//") {
+		return ""
+	}
+	name := strings.TrimPrefix(path, "//")
+	if i := strings.Index(name, "/"); i >= 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+// FindDepot returns the depot a depot path is in
+func FindDepot(depots []Depot, path string) (Depot, bool) {
+	name := DepotName(path)
+	for _, d := range depots {
+		if d.Depot == name {
+			return d, true
+		}
+	}
+	return Depot{}, false
+}
+
+// ListDepots runs p4 depots args...
+func ListDepots(p4r Runner, args []string) ([]Depot, error) {
+	args = append([]string{"depots"}, args...)
+	res, err := p4r.Run(args)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to run p4 %s\n%v", args, err)
+	}
+	depots := []Depot{}
+	for _, r := range res {
+		if getString(r, "code") == "error" {
+			return nil, parseError(r)
+		}
+		depots = append(depots, depotFromResult(r))
+	}
+	return depots, nil
+}
+
+// FetchDepot runs p4 depot -o name
+func FetchDepot(p4r Runner, name string) (Depot, error) {
+	args := []string{"depot", "-o", name}
+	res, err := p4r.Run(args)
+	if err != nil {
+		return Depot{}, fmt.Errorf("Failed to run p4 %s\n%v", args, err)
+	}
+	if len(res) == 0 {
+		return Depot{}, fmt.Errorf("No output from p4 %s", args)
+	}
+	if getString(res[0], "code") == "error" {
+		return Depot{}, parseError(res[0])
+	}
+	return depotFromResult(res[0]), nil
+}
+
+// SaveDepot runs p4 depot -i args... with the depot spec
+func SaveDepot(p4r SpecRunner, d Depot, args ...string) (string, error) {
+	return p4r.SaveTxt("depot", d.spec(), args...)
+}
+
+// DeleteDepot runs p4 depot -d args... name, e.g. args of "-f" to delete
+// a depot which still has files
+func DeleteDepot(p4r Runner, name string, args ...string) error {
+	return runDelete(p4r, "depot", name, args)
+}
+
+var reNoSuchArea = regexp.MustCompile(`P4Error -> No such area '(.*)', please check your path`)
+
+// ExplainPathError improves the error parseError gives for a path which
+// must refer to a client, by checking the depots on the server. Other
+// errors are returned unchanged.
+func ExplainPathError(p4r Runner, err error) error {
+	if err == nil {
+		return nil
+	}
+	m := reNoSuchArea.FindStringSubmatch(err.Error())
+	if m == nil {
+		return err
+	}
+	path := m[1]
+	name := DepotName(path)
+	if name == "" {
+		return fmt.Errorf("P4Error -> '%s' is not a depot path and is not in the client view", path)
+	}
+	depots, lerr := ListDepots(p4r, []string{})
+	if lerr != nil {
+		return err
+	}
+	d, ok := FindDepot(depots, path)
+	if !ok {
+		names := []string{}
+		for _, d := range depots {
+			names = append(names, d.Depot)
+		}
+		return fmt.Errorf("P4Error -> No such depot '%s' for '%s', depots are: %s", name, path, strings.Join(names, ", "))
+	}
+	if d.IsStream() && d.Stream(path) == "" {
+		return fmt.Errorf("P4Error -> '%s' is not in a stream: depot '%s' has stream depth %d", path, name, d.Depth())
+	}
+	return err
+}
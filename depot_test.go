@@ -0,0 +1,83 @@
+package p4
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var depotsResult = []map[interface{}]interface{}{
+	{"code": "stat", "name": "depot", "time": "1557689468", "type": "local", "map": "depot/...", "desc": "Default depot"},
+	{"code": "stat", "name": "spec", "time": "1557689468", "type": "spec", "map": "spec/...", "desc": "Specs", "extra": ".p4s"},
+	{"code": "stat", "name": "stream", "time": "1557689468", "type": "stream", "map": "stream/...", "desc": "Streams",
+		"streamDepth": "//stream/2"},
+}
+
+func TestListDepots(t *testing.T) {
+	mp4 := &FakeP4Runner{}
+	mp4.On("Run", []string{"depots"}).Return(depotsResult, nil)
+	depots, err := ListDepots(mp4, []string{})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(depots))
+	assert.Equal(t, Depot{Depot: "spec", Date: "1557689468", Type: "spec", Map: "spec/...", Description: "Specs",
+		Suffix: ".p4s", SpecMap: []string{}}, depots[1])
+
+	d, ok := FindDepot(depots, "//stream/team/main/src/a.c")
+	assert.True(t, ok)
+	assert.True(t, d.IsStream())
+	assert.Equal(t, 2, d.Depth())
+	assert.Equal(t, "//stream/team/main", d.Stream("//stream/team/main/src/a.c"))
+	assert.Equal(t, "//stream/team/main", d.Stream("//stream/team/main/..."))
+	assert.Equal(t, "", d.Stream("//stream/team/..."))
+	assert.Equal(t, "", d.Stream("//depot/team/main/a.c"))
+	assert.Equal(t, 0, depots[0].Depth())
+	assert.Equal(t, 1, Depot{Type: "stream"}.Depth())
+	_, ok = FindDepot(depots, "//nodepot/...")
+	assert.False(t, ok)
+	assert.Equal(t, "", DepotName("main/a.c"))
+}
+
+func TestDepotSpec(t *testing.T) {
+	mp4 := &FakeP4Runner{}
+	mp4.On("Run", []string{"depot", "-o", "spec"}).Return([]map[interface{}]interface{}{
+		{"code": "stat", "Depot": "spec", "Owner": "bob", "Date": "2019/05/12 20:31:08", "Description": "Specs\n",
+			"Type": "spec", "Suffix": ".p4s", "Map": "spec/...", "SpecMap0": "//spec/...", "SpecMap1": "-//spec/client/tmp_*"},
+	}, nil)
+	d, err := FetchDepot(mp4, "spec")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"//spec/...", "-//spec/client/tmp_*"}, d.SpecMap)
+
+	d.SpecMap = d.SpecMap[:1]
+	mp4.On("SaveTxt", "depot", map[string]string{"Depot": "spec", "Owner": "bob", "Description": "Specs\n",
+		"Type": "spec", "Suffix": ".p4s", "Map": "spec/...", "SpecMap": "//spec/..."}, []string(nil)).Return("Depot spec saved.\n", nil)
+	msg, err := SaveDepot(mp4, d)
+	assert.NoError(t, err)
+	assert.Equal(t, "Depot spec saved.\n", msg)
+
+	mp4.On("Run", []string{"depot", "-d", "-f", "old"}).Return([]map[interface{}]interface{}{
+		{"code": "info", "data": "Depot old deleted."}}, nil)
+	assert.NoError(t, DeleteDepot(mp4, "old", "-f"))
+}
+
+func TestExplainPathError(t *testing.T) {
+	mp4 := &FakeP4Runner{}
+	mp4.On("Run", []string{"depots"}).Return(depotsResult, nil)
+	explain := func(path string) error {
+		return ExplainPathError(mp4, parseError(map[interface{}]interface{}{"code": "error",
+			"data": path + " - must refer to client 'HOSTNAME'.", "generic": "2", "severity": "3"}))
+	}
+
+	assert.EqualError(t, explain("//fake/depot/..."),
+		"P4Error -> No such depot 'fake' for '//fake/depot/...', depots are: depot, spec, stream")
+	assert.EqualError(t, explain("//stream/..."),
+		"P4Error -> '//stream/...' is not in a stream: depot 'stream' has stream depth 2")
+	assert.EqualError(t, explain("main/..."),
+		"P4Error -> 'main/...' is not a depot path and is not in the client view")
+	assert.EqualError(t, explain("//depot/main/..."),
+		"P4Error -> No such area '//depot/main/...', please check your path")
+
+	other := errors.New("P4Error -> some unknown error")
+	assert.Equal(t, other, ExplainPathError(mp4, other))
+	assert.Nil(t, ExplainPathError(mp4, nil))
+}
@@ -0,0 +1,219 @@
+package p4
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SettingSource records where the effective value of a setting came from
+type SettingSource string
+
+// Sources of settings, in order of precedence
+const (
+	SourceParam       SettingSource = "param"       // NewP4Params or an option on P4
+	SourceConfig      SettingSource = "config"      // P4CONFIG file
+	SourceEnvironment SettingSource = "environment" // environment variable
+	SourceEnviro      SettingSource = "enviro"      // P4ENVIRO file, as written by p4 set
+	SourceDefault     SettingSource = "default"     // p4 built in default
+	SourceUnset       SettingSource = "unset"
+)
+
+// Setting is the effective value of a P4 setting such as P4PORT
+type Setting struct {
+	Name   string
+	Value  string
+	Source SettingSource
+	File   string // the P4CONFIG or P4ENVIRO file if the value came from one
+}
+
+// Effective is the set of settings p4 commands will use
+type Effective struct {
+	Port       Setting
+	User       Setting
+	Client     Setting
+	Charset    Setting
+	Passwd     Setting
+	Tickets    Setting
+	Trust      Setting
+	Host       Setting
+	ConfigFile string // P4CONFIG file in use, if any
+	EnviroFile string // P4ENVIRO file in use, if it exists
+}
+
+// Settings returns all the settings in a fixed order
+func (e Effective) Settings() []Setting {
+	return []Setting{e.Port, e.User, e.Client, e.Charset, e.Passwd, e.Tickets, e.Trust, e.Host}
+}
+
+// Effective works out which settings p4 commands run by this P4 will use.
+// Settings come from, in order: parameters given to NewP4Params or options,
+// the nearest P4CONFIG file in or above the working directory, environment
+// variables, including any set with WithEnv, and then the P4ENVIRO file.
+// Anything not set falls back to p4's default.
+func (p4 *P4) Effective() (Effective, error) {
+	dir := p4.dir
+	if dir == "" {
+		var err error
+		if dir, err = os.Getwd(); err != nil {
+			return Effective{}, err
+		}
+	}
+	params := map[string]string{
+		"P4PORT":    p4.port,
+		"P4USER":    p4.user,
+		"P4CLIENT":  p4.client,
+		"P4CHARSET": p4.charset,
+		"P4HOST":    p4.host,
+		"P4PASSWD":  p4.password,
+	}
+	return resolveSettings(dir, params, p4.getenv)
+}
+
+// getenv returns an environment variable as the p4 process will see it
+func (p4 *P4) getenv(name string) string {
+	for i := len(p4.env) - 1; i >= 0; i-- {
+		if strings.HasPrefix(p4.env[i], name+"=") {
+			return strings.TrimPrefix(p4.env[i], name+"=")
+		}
+	}
+	return os.Getenv(name)
+}
+
+func resolveSettings(dir string, params map[string]string, getenv func(string) string) (Effective, error) {
+	e := Effective{}
+	e.EnviroFile = getenv("P4ENVIRO")
+	if e.EnviroFile == "" {
+		e.EnviroFile = homeFile(".p4enviro", "p4enviro.txt")
+	}
+	enviro, err := readSettingsFile(e.EnviroFile)
+	if errors.Is(err, os.ErrNotExist) {
+		e.EnviroFile = ""
+	} else if err != nil {
+		return e, err
+	}
+
+	config := map[string]string{}
+	configName := getenv("P4CONFIG")
+	if configName == "" {
+		configName = enviro["P4CONFIG"]
+	}
+	if configName != "" {
+		e.ConfigFile, err = findConfigFile(dir, configName)
+		if err != nil {
+			return e, err
+		}
+		if e.ConfigFile != "" {
+			if config, err = readSettingsFile(e.ConfigFile); err != nil {
+				return e, err
+			}
+			// P4CONFIG files may refer to their own directory
+			configDir := filepath.Dir(e.ConfigFile)
+			for k, v := range config {
+				config[k] = strings.ReplaceAll(v, "$configdir", configDir)
+			}
+		}
+	}
+
+	resolve := func(name string) Setting {
+		if v := params[name]; v != "" {
+			return Setting{Name: name, Value: v, Source: SourceParam}
+		}
+		if v := config[name]; v != "" {
+			return Setting{Name: name, Value: v, Source: SourceConfig, File: e.ConfigFile}
+		}
+		if v := getenv(name); v != "" {
+			return Setting{Name: name, Value: v, Source: SourceEnvironment}
+		}
+		if v := enviro[name]; v != "" {
+			return Setting{Name: name, Value: v, Source: SourceEnviro, File: e.EnviroFile}
+		}
+		if v := defaultSetting(name, getenv); v != "" {
+			return Setting{Name: name, Value: v, Source: SourceDefault}
+		}
+		return Setting{Name: name, Source: SourceUnset}
+	}
+	e.Port = resolve("P4PORT")
+	e.User = resolve("P4USER")
+	e.Client = resolve("P4CLIENT")
+	e.Charset = resolve("P4CHARSET")
+	e.Passwd = resolve("P4PASSWD")
+	e.Tickets = resolve("P4TICKETS")
+	e.Trust = resolve("P4TRUST")
+	e.Host = resolve("P4HOST")
+	return e, nil
+}
+
+// defaultSetting returns the value p4 uses when a setting is not set anywhere
+func defaultSetting(name string, getenv func(string) string) string {
+	switch name {
+	case "P4PORT":
+		return "perforce:1666"
+	case "P4USER":
+		for _, v := range []string{"USER", "USERNAME"} {
+			if u := getenv(v); u != "" {
+				return u
+			}
+		}
+	case "P4CLIENT", "P4HOST":
+		host, _ := os.Hostname()
+		return host
+	case "P4TICKETS":
+		return homeFile(".p4tickets", "p4tickets.txt")
+	case "P4TRUST":
+		return homeFile(".p4trust", "p4trust.txt")
+	}
+	return ""
+}
+
+// findConfigFile looks for a P4CONFIG file called name in dir and each of
+// its parents, returning "" if there isn't one
+func findConfigFile(dir string, name string) (string, error) {
+	if filepath.IsAbs(name) {
+		if _, err := os.Stat(name); err != nil {
+			return "", nil
+		}
+		return name, nil
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for {
+		fname := filepath.Join(dir, name)
+		if fi, err := os.Stat(fname); err == nil && !fi.IsDir() {
+			return fname, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+// readSettingsFile reads NAME=value lines as found in P4CONFIG and P4ENVIRO
+// files. Comments and lines which aren't settings are ignored, as p4 does.
+func readSettingsFile(fname string) (map[string]string, error) {
+	settings := map[string]string{}
+	f, err := os.Open(fname)
+	if err != nil {
+		return settings, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		settings[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return settings, scanner.Err()
+}
@@ -0,0 +1,76 @@
+package p4
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveSettings(t *testing.T) {
+	root := t.TempDir()
+	workDir := filepath.Join(root, "ws", "src", "pkg")
+	assert.Nil(t, os.MkdirAll(workDir, 0777))
+	config := filepath.Join(root, "ws", ".p4config")
+	assert.Nil(t, writeToFile(config, "# workspace settings\nP4CLIENT=ws_client\nP4PORT = ssl:config:1666\nP4TRUST=$configdir/.p4trust\nnot a setting\n"))
+	enviro := filepath.Join(root, ".p4enviro")
+	assert.Nil(t, writeToFile(enviro, "P4CONFIG=.p4config\nP4PORT=enviro:1666\nP4CHARSET=utf8\nP4USER=enviro_user\n"))
+
+	t.Setenv("P4CONFIG", "")
+	t.Setenv("P4ENVIRO", enviro)
+	t.Setenv("P4USER", "env_user")
+	t.Setenv("P4HOST", "env_host")
+	t.Setenv("P4PASSWD", "")
+	t.Setenv("P4TICKETS", "")
+
+	e, err := resolveSettings(workDir, map[string]string{"P4PORT": "", "P4USER": "param_user", "P4CLIENT": ""}, os.Getenv)
+	assert.Nil(t, err)
+	assert.Equal(t, config, e.ConfigFile)
+	assert.Equal(t, enviro, e.EnviroFile)
+	assert.Equal(t, Setting{Name: "P4PORT", Value: "ssl:config:1666", Source: SourceConfig, File: config}, e.Port)
+	assert.Equal(t, Setting{Name: "P4USER", Value: "param_user", Source: SourceParam}, e.User)
+	assert.Equal(t, Setting{Name: "P4CLIENT", Value: "ws_client", Source: SourceConfig, File: config}, e.Client)
+	assert.Equal(t, Setting{Name: "P4CHARSET", Value: "utf8", Source: SourceEnviro, File: enviro}, e.Charset)
+	assert.Equal(t, Setting{Name: "P4PASSWD", Source: SourceUnset}, e.Passwd)
+	assert.Equal(t, SourceDefault, e.Tickets.Source)
+	assert.Equal(t, Setting{Name: "P4TRUST", Value: filepath.Join(root, "ws", ".p4trust"), Source: SourceConfig, File: config}, e.Trust)
+	assert.Equal(t, Setting{Name: "P4HOST", Value: "env_host", Source: SourceEnvironment}, e.Host)
+	assert.Equal(t, 8, len(e.Settings()))
+
+	// Outside the workspace there is no P4CONFIG file
+	e, err = resolveSettings(root, map[string]string{}, os.Getenv)
+	assert.Nil(t, err)
+	assert.Equal(t, "", e.ConfigFile)
+	assert.Equal(t, Setting{Name: "P4PORT", Value: "enviro:1666", Source: SourceEnviro, File: enviro}, e.Port)
+	// Environment variables take precedence over P4ENVIRO
+	assert.Equal(t, Setting{Name: "P4USER", Value: "env_user", Source: SourceEnvironment}, e.User)
+	t.Setenv("P4USER", "")
+	e, err = resolveSettings(root, map[string]string{}, os.Getenv)
+	assert.Nil(t, err)
+	assert.Equal(t, Setting{Name: "P4USER", Value: "enviro_user", Source: SourceEnviro, File: enviro}, e.User)
+}
+
+func TestResolveSettingsNoFiles(t *testing.T) {
+	root := t.TempDir()
+	t.Setenv("P4CONFIG", "")
+	t.Setenv("P4ENVIRO", filepath.Join(root, "missing"))
+	t.Setenv("P4PORT", "")
+	t.Setenv("P4CLIENT", "env_client")
+	e, err := resolveSettings(root, map[string]string{}, os.Getenv)
+	assert.Nil(t, err)
+	assert.Equal(t, "", e.EnviroFile)
+	assert.Equal(t, Setting{Name: "P4PORT", Value: "perforce:1666", Source: SourceDefault}, e.Port)
+	assert.Equal(t, Setting{Name: "P4CLIENT", Value: "env_client", Source: SourceEnvironment}, e.Client)
+}
+
+func TestEffective(t *testing.T) {
+	t.Setenv("P4CONFIG", "")
+	t.Setenv("P4ENVIRO", filepath.Join(t.TempDir(), "missing"))
+	p4 := NewP4Params("localhost:1666", "a.person", "a_ws")
+	e, err := p4.Effective()
+	assert.Nil(t, err)
+	assert.Equal(t, Setting{Name: "P4PORT", Value: "localhost:1666", Source: SourceParam}, e.Port)
+	assert.Equal(t, Setting{Name: "P4USER", Value: "a.person", Source: SourceParam}, e.User)
+	assert.Equal(t, Setting{Name: "P4CLIENT", Value: "a_ws", Source: SourceParam}, e.Client)
+}
@@ -0,0 +1,177 @@
+package p4
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// File is a single result from p4 files
+type File struct {
+	DepotFile string
+	Rev       int
+	Change    int
+	Action    string
+	Type      string
+	Time      time.Time
+}
+
+// FileSize is a single result from p4 sizes. With -s, Path and FileCount
+// are set rather than DepotFile and Rev.
+type FileSize struct {
+	DepotFile string
+	Rev       int
+	Change    int // shelving change, with -S
+	Path      string
+	FileCount int64
+	FileSize  int64 // bytes, or blocks with -b
+}
+
+// DirUsage is the size of the files under a depot directory, and of its
+// subdirectories, as found by DepotUsage
+type DirUsage struct {
+	Path      string
+	FileCount int64
+	FileSize  int64
+	Dirs      []*DirUsage
+}
+
+// isEmptyResult reports whether a result is the warning given when there
+// are no matching files, e.g. "//depot/x/... - no such file(s)."
+func isEmptyResult(r map[interface{}]interface{}) bool {
+	rec := NewRecord(r)
+	severity, _ := rec.GetInt("severity")
+	generic, _ := rec.GetInt("generic")
+	return rec.Code() == "error" && severity <= SeverityWarn && generic == GenericEmpty
+}
+
+// runList runs a command which lists files, returning its results without
+// any warnings for paths with no files
+func runList(p4r Runner, args []string) ([]Record, error) {
+	res, err := p4r.Run(args)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to run p4 %s\n%v", args, err)
+	}
+	records := []Record{}
+	for _, r := range res {
+		if isEmptyResult(r) {
+			continue
+		}
+		if getString(r, "code") == "error" {
+			return nil, parseError(r)
+		}
+		records = append(records, NewRecord(r))
+	}
+	return records, nil
+}
+
+// getInts returns the integer values of fields, stopping at the first error
+func getInts(r Record, keys ...string) ([]int64, error) {
+	vals := make([]int64, len(keys))
+	for i, k := range keys {
+		if !r.Has(k) {
+			continue
+		}
+		v, err := r.GetInt(k)
+		if err != nil {
+			return nil, err
+		}
+		vals[i] = v
+	}
+	return vals, nil
+}
+
+// RunFiles runs p4 files args..., e.g. -a, -A, -e or -m max.
+// Paths with no files are ignored rather than returned as errors.
+func RunFiles(p4r Runner, args []string) ([]File, error) {
+	args = append([]string{"files"}, args...)
+	records, err := runList(p4r, args)
+	if err != nil {
+		return nil, err
+	}
+	files := []File{}
+	for _, r := range records {
+		n, err := getInts(r, "rev", "change", "time")
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, File{DepotFile: r.Get("depotFile"), Rev: int(n[0]), Change: int(n[1]),
+			Action: r.Get("action"), Type: r.Get("type"), Time: time.Unix(n[2], 0)})
+	}
+	return files, nil
+}
+
+// RunDirs runs p4 dirs args..., e.g. -C, -D, -H or -S stream, returning
+// the directories found
+func RunDirs(p4r Runner, args []string) ([]string, error) {
+	args = append([]string{"dirs"}, args...)
+	records, err := runList(p4r, args)
+	if err != nil {
+		return nil, err
+	}
+	dirs := []string{}
+	for _, r := range records {
+		dirs = append(dirs, r.Get("dir"))
+	}
+	return dirs, nil
+}
+
+// RunSizes runs p4 sizes args..., e.g. -a, -s, -z, -S or -b blocksize
+func RunSizes(p4r Runner, args []string) ([]FileSize, error) {
+	args = append([]string{"sizes"}, args...)
+	records, err := runList(p4r, args)
+	if err != nil {
+		return nil, err
+	}
+	sizes := []FileSize{}
+	for _, r := range records {
+		n, err := getInts(r, "rev", "change", "fileCount", "fileSize")
+		if err != nil {
+			return nil, err
+		}
+		sizes = append(sizes, FileSize{DepotFile: r.Get("depotFile"), Rev: int(n[0]), Change: int(n[1]),
+			Path: r.Get("path"), FileCount: n[2], FileSize: n[3]})
+	}
+	return sizes, nil
+}
+
+// DepotUsage returns the total size of the files under path, such as
+// //depot/main/..., and of its subdirectories down to depth levels below
+// it. Any sizesArgs, e.g. -a for all revisions or -z to exclude lazy
+// copies, are passed to p4 sizes -s.
+func DepotUsage(p4r Runner, path string, depth int, sizesArgs ...string) (*DirUsage, error) {
+	dir := strings.TrimSuffix(path, "...")
+	// The root of all depots, //, keeps its slashes
+	if dir != "//" {
+		dir = strings.TrimSuffix(dir, "/")
+	}
+	prefix := dir
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	args := append(append([]string{"-s"}, sizesArgs...), prefix+"...")
+	sizes, err := RunSizes(p4r, args)
+	if err != nil {
+		return nil, err
+	}
+	usage := &DirUsage{Path: dir, Dirs: []*DirUsage{}}
+	for _, s := range sizes {
+		usage.FileCount += s.FileCount
+		usage.FileSize += s.FileSize
+	}
+	if depth <= 0 || usage.FileCount == 0 {
+		return usage, nil
+	}
+	subdirs, err := RunDirs(p4r, []string{prefix + "*"})
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range subdirs {
+		sub, err := DepotUsage(p4r, d, depth-1, sizesArgs...)
+		if err != nil {
+			return nil, err
+		}
+		usage.Dirs = append(usage.Dirs, sub)
+	}
+	return usage, nil
+}
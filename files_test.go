@@ -0,0 +1,98 @@
+package p4
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var noSuchFiles = map[interface{}]interface{}{"code": "error", "data": "//depot/empty/... - no such file(s).\n",
+	"severity": int32(SeverityWarn), "generic": int32(GenericEmpty)}
+
+func TestRunFiles(t *testing.T) {
+	mp4 := &FakeP4Runner{}
+	mp4.On("Run", []string{"files", "-e", "//depot/main/...", "//depot/empty/..."}).Return([]map[interface{}]interface{}{
+		{"code": "stat", "depotFile": "//depot/main/a.txt", "rev": "3", "change": "12", "action": "edit",
+			"type": "text", "time": "1612369118"},
+		noSuchFiles,
+	}, nil)
+	mp4.On("Run", []string{"files", "//nodepot/..."}).Return([]map[interface{}]interface{}{
+		{"code": "error", "data": "//nodepot/... - must refer to client 'ws'.\n", "severity": int32(3), "generic": int32(2)},
+	}, nil)
+
+	files, err := RunFiles(mp4, []string{"-e", "//depot/main/...", "//depot/empty/..."})
+	assert.NoError(t, err)
+	assert.Equal(t, []File{{DepotFile: "//depot/main/a.txt", Rev: 3, Change: 12, Action: "edit", Type: "text",
+		Time: time.Unix(1612369118, 0)}}, files)
+
+	_, err = RunFiles(mp4, []string{"//nodepot/..."})
+	assert.Error(t, err)
+}
+
+func TestRunDirsAndSizes(t *testing.T) {
+	mp4 := &FakeP4Runner{}
+	mp4.On("Run", []string{"dirs", "-D", "//depot/*"}).Return([]map[interface{}]interface{}{
+		{"code": "stat", "dir": "//depot/main"}, {"code": "stat", "dir": "//depot/rel"}}, nil)
+	mp4.On("Run", []string{"sizes", "-a", "//depot/main/a.txt"}).Return([]map[interface{}]interface{}{
+		{"code": "stat", "depotFile": "//depot/main/a.txt", "rev": "2", "fileSize": "5000000000"},
+		{"code": "stat", "depotFile": "//depot/main/a.txt", "rev": "1", "fileSize": "12"}}, nil)
+	mp4.On("Run", []string{"sizes", "-s", "//depot/..."}).Return([]map[interface{}]interface{}{
+		{"code": "stat", "path": "//depot/...", "fileCount": "3", "fileSize": "42"}}, nil)
+
+	dirs, err := RunDirs(mp4, []string{"-D", "//depot/*"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"//depot/main", "//depot/rel"}, dirs)
+
+	sizes, err := RunSizes(mp4, []string{"-a", "//depot/main/a.txt"})
+	assert.NoError(t, err)
+	assert.Equal(t, []FileSize{{DepotFile: "//depot/main/a.txt", Rev: 2, FileSize: 5000000000},
+		{DepotFile: "//depot/main/a.txt", Rev: 1, FileSize: 12}}, sizes)
+
+	sizes, err = RunSizes(mp4, []string{"-s", "//depot/..."})
+	assert.NoError(t, err)
+	assert.Equal(t, []FileSize{{Path: "//depot/...", FileCount: 3, FileSize: 42}}, sizes)
+}
+
+func TestDepotUsage(t *testing.T) {
+	mp4 := &FakeP4Runner{}
+	sizes := func(path string, count string, size string) {
+		mp4.On("Run", []string{"sizes", "-s", "-a", path + "/..."}).Return([]map[interface{}]interface{}{
+			{"code": "stat", "path": path + "/...", "fileCount": count, "fileSize": size}}, nil)
+	}
+	dirs := func(path string, dirs ...string) {
+		res := []map[interface{}]interface{}{}
+		for _, d := range dirs {
+			res = append(res, map[interface{}]interface{}{"code": "stat", "dir": d})
+		}
+		mp4.On("Run", []string{"dirs", path + "/*"}).Return(res, nil)
+	}
+	sizes("//depot", "5", "1000")
+	dirs("//depot", "//depot/main", "//depot/rel")
+	sizes("//depot/main", "4", "900")
+	dirs("//depot/main", "//depot/main/src")
+	sizes("//depot/main/src", "4", "900")
+	mp4.On("Run", []string{"sizes", "-s", "-a", "//depot/rel/..."}).Return([]map[interface{}]interface{}{noSuchFiles}, nil)
+
+	usage, err := DepotUsage(mp4, "//depot/...", 1, "-a")
+	assert.NoError(t, err)
+	assert.Equal(t, &DirUsage{Path: "//depot", FileCount: 5, FileSize: 1000, Dirs: []*DirUsage{
+		{Path: "//depot/main", FileCount: 4, FileSize: 900, Dirs: []*DirUsage{}},
+		{Path: "//depot/rel", Dirs: []*DirUsage{}},
+	}}, usage)
+
+	usage, err = DepotUsage(mp4, "//depot", 2, "-a")
+	assert.NoError(t, err)
+	assert.Equal(t, "//depot/main/src", usage.Dirs[0].Dirs[0].Path)
+	mp4.AssertNotCalled(t, "Run", []string{"dirs", "//depot/rel/*"})
+
+	// The root of all depots lists the depots
+	mp4.On("Run", []string{"sizes", "-s", "-a", "//..."}).Return([]map[interface{}]interface{}{
+		{"code": "stat", "path": "//...", "fileCount": "5", "fileSize": "1000"}}, nil)
+	mp4.On("Run", []string{"dirs", "//*"}).Return([]map[interface{}]interface{}{
+		{"code": "stat", "dir": "//depot"}}, nil)
+	usage, err = DepotUsage(mp4, "//...", 1, "-a")
+	assert.NoError(t, err)
+	assert.Equal(t, "//", usage.Path)
+	assert.Equal(t, &DirUsage{Path: "//depot", FileCount: 5, FileSize: 1000, Dirs: []*DirUsage{}}, usage.Dirs[0])
+}
@@ -0,0 +1,164 @@
+package p4
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ServerInfo is the result of p4 info
+type ServerInfo struct {
+	UserName            string
+	ClientName          string
+	ClientRoot          string
+	ClientHost          string
+	ClientAddress       string
+	ServerAddress       string
+	ServerRoot          string
+	ServerName          string
+	ServerID            string
+	ServerDate          time.Time
+	ServerUptime        time.Duration
+	ServerVersion       Version // zero if serverVersion couldn't be parsed
+	ServerVersionString string  // serverVersion as reported
+	ServerServices      string  // e.g. standard, replica, edge-server or commit-server
+	ServerLicense       string
+	CaseHandling        string // sensitive, insensitive or hybrid
+	Unicode             bool
+	Security            int    // security counter, 0 if not reported
+	ReplicaOf           string // address of the server this replicates, if any
+	BrokerAddress       string
+	BrokerVersion       string
+	ProxyAddress        string
+	ProxyVersion        string
+}
+
+// CaseInsensitive reports whether the server compares file names without case
+func (si ServerInfo) CaseInsensitive() bool {
+	return si.CaseHandling == "insensitive"
+}
+
+// Services of servers which replicate another rather than accept changes
+// themselves
+var replicaServices = map[string]bool{
+	"replica":            true,
+	"readonly":           true,
+	"forwarding-replica": true,
+	"build-server":       true,
+	"edge-server":        true,
+	"standby":            true,
+	"forwarding-standby": true,
+}
+
+// IsReplica reports whether the server is a replica, standby, build or
+// edge server rather than a standard or commit server
+func (si ServerInfo) IsReplica() bool {
+	return si.ReplicaOf != "" || replicaServices[si.ServerServices]
+}
+
+// IsEdge reports whether the server is an edge server
+func (si ServerInfo) IsEdge() bool {
+	return si.ServerServices == "edge-server"
+}
+
+// parseServerDate parses a date such as 2019/05/14 11:20:06 +0100 BST,
+// ignoring the zone name
+func parseServerDate(s string) (time.Time, error) {
+	fields := strings.Fields(s)
+	if len(fields) < 3 {
+		return time.Time{}, fmt.Errorf("Failed to parse server date '%s'", s)
+	}
+	return time.Parse("2006/01/02 15:04:05 -0700", strings.Join(fields[:3], " "))
+}
+
+// parseUptime parses an uptime such as 123:04:05, where hours may be over 24
+func parseUptime(s string) (time.Duration, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("Failed to parse uptime '%s'", s)
+	}
+	var d time.Duration
+	for i, unit := range []time.Duration{time.Hour, time.Minute, time.Second} {
+		n, err := strconv.Atoi(parts[i])
+		if err != nil {
+			return 0, fmt.Errorf("Failed to parse uptime '%s'", s)
+		}
+		d += time.Duration(n) * unit
+	}
+	return d, nil
+}
+
+// parseServerInfo converts a p4 info result
+func parseServerInfo(r Record) (ServerInfo, error) {
+	si := ServerInfo{
+		UserName:            r.Get("userName"),
+		ClientName:          r.Get("clientName"),
+		ClientRoot:          r.Get("clientRoot"),
+		ClientHost:          r.Get("clientHost"),
+		ClientAddress:       r.Get("clientAddress"),
+		ServerAddress:       r.Get("serverAddress"),
+		ServerRoot:          r.Get("serverRoot"),
+		ServerName:          r.Get("serverName"),
+		ServerID:            r.Get("ServerID"),
+		ServerVersionString: r.Get("serverVersion"),
+		ServerServices:      r.Get("serverServices"),
+		ServerLicense:       r.Get("serverLicense"),
+		CaseHandling:        r.Get("caseHandling"),
+		Unicode:             r.Get("unicode") == "enabled",
+		ReplicaOf:           r.Get("replica"),
+		BrokerAddress:       r.Get("brokerAddress"),
+		BrokerVersion:       r.Get("brokerVersion"),
+		ProxyAddress:        r.Get("proxyAddress"),
+		ProxyVersion:        r.Get("proxyVersion"),
+	}
+	// Leave versions from brokers, proxies or future servers unparsed
+	// rather than fail
+	if v, err := ParseVersion(si.ServerVersionString); err == nil {
+		si.ServerVersion = v
+	}
+	var err error
+	if v := r.Get("serverDate"); v != "" {
+		if si.ServerDate, err = parseServerDate(v); err != nil {
+			return si, err
+		}
+	}
+	if v := r.Get("serverUptime"); v != "" {
+		if si.ServerUptime, err = parseUptime(v); err != nil {
+			return si, err
+		}
+	}
+	if r.Has("security") {
+		n, err := r.GetInt("security")
+		if err != nil {
+			return si, err
+		}
+		si.Security = int(n)
+	}
+	return si, nil
+}
+
+// RunInfo runs p4 info args...
+func RunInfo(p4r Runner, args []string) (ServerInfo, error) {
+	args = append([]string{"info"}, args...)
+	res, err := p4r.Run(args)
+	if err != nil {
+		return ServerInfo{}, fmt.Errorf("Failed to run p4 %s\n%v", args, err)
+	}
+	if len(res) == 0 {
+		return ServerInfo{}, fmt.Errorf("No output from p4 %s", args)
+	}
+	if getString(res[0], "code") == "error" {
+		return ServerInfo{}, parseError(res[0])
+	}
+	return parseServerInfo(NewRecord(res[0]))
+}
+
+// Info returns the result of p4 info. The result is cached, so p4 info only
+// runs once for each P4 unless it fails, and fields such as ServerDate and
+// ServerUptime are from the first call.
+func (p4 *P4) Info() (ServerInfo, error) {
+	return p4.serverInfo.get(func() (ServerInfo, error) {
+		return RunInfo(p4, []string{})
+	})
+}
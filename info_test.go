@@ -0,0 +1,93 @@
+package p4
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunInfo(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join(testRoot, "..", "testdata", "info.bin"))
+	assert.NoError(t, err)
+	res, err := DecodeResults(data)
+	assert.NoError(t, err)
+
+	mp4 := &FakeP4Runner{}
+	mp4.On("Run", []string{"info"}).Return(res, nil)
+	si, err := RunInfo(mp4, []string{})
+	assert.NoError(t, err)
+	assert.Equal(t, "rcowham", si.UserName)
+	assert.Equal(t, "rcowham-dvcs-1557689468", si.ClientName)
+	assert.Equal(t, "unknown", si.ServerAddress)
+	assert.Equal(t, time.Date(2019, 5, 14, 10, 20, 6, 0, time.UTC), si.ServerDate.UTC())
+	assert.Equal(t, time.Duration(0), si.ServerUptime)
+	assert.Equal(t, Version{Product: "P4D", Platform: "MACOSX1010X86_64", Release: "2019.1", Change: "1796703", Date: "2019/04/30"}, si.ServerVersion)
+	assert.Equal(t, "local", si.ServerServices)
+	assert.Equal(t, "none", si.ServerLicense)
+	assert.False(t, si.CaseInsensitive())
+	assert.False(t, si.Unicode)
+	assert.False(t, si.IsReplica())
+}
+
+func TestRunInfoRoles(t *testing.T) {
+	mp4 := &FakeP4Runner{}
+	mp4.On("Run", []string{"info", "-s"}).Return([]map[interface{}]interface{}{{
+		"code":           "stat",
+		"serverVersion":  "P4D/LINUX26X86_64/2021.1/2156517 (2021/05/31)",
+		"serverDate":     "2021/06/01 12:00:00 -0500 CDT",
+		"serverUptime":   "100:02:03",
+		"serverServices": "edge-server",
+		"replica":        "commit:1666",
+		"caseHandling":   "insensitive",
+		"unicode":        "enabled",
+		"security":       "3",
+		"brokerAddress":  "broker:1666",
+		"proxyAddress":   "proxy:1999",
+	}}, nil)
+	si, err := RunInfo(mp4, []string{"-s"})
+	assert.NoError(t, err)
+	assert.Equal(t, 100*time.Hour+2*time.Minute+3*time.Second, si.ServerUptime)
+	assert.Equal(t, time.Date(2021, 6, 1, 17, 0, 0, 0, time.UTC), si.ServerDate.UTC())
+	assert.True(t, si.IsEdge())
+	assert.True(t, si.IsReplica())
+	assert.Equal(t, "commit:1666", si.ReplicaOf)
+	assert.True(t, si.CaseInsensitive())
+	assert.True(t, si.Unicode)
+	assert.Equal(t, 3, si.Security)
+	assert.Equal(t, "broker:1666", si.BrokerAddress)
+	assert.Equal(t, "proxy:1999", si.ProxyAddress)
+}
+
+func TestIsReplica(t *testing.T) {
+	for services, replica := range map[string]bool{"standard": false, "commit-server": false, "replica": true,
+		"readonly": true, "forwarding-replica": true, "build-server": true, "edge-server": true,
+		"standby": true, "forwarding-standby": true} {
+		assert.Equal(t, replica, ServerInfo{ServerServices: services}.IsReplica(), services)
+	}
+	assert.True(t, ServerInfo{ServerServices: "standard", ReplicaOf: "master:1666"}.IsReplica())
+}
+
+func TestRunInfoUnknownVersion(t *testing.T) {
+	mp4 := &FakeP4Runner{}
+	mp4.On("Run", []string{"info"}).Return([]map[interface{}]interface{}{
+		{"code": "stat", "serverVersion": "P4BROKER 2021.2", "serverServices": "standard"}}, nil)
+	si, err := RunInfo(mp4, []string{})
+	assert.NoError(t, err)
+	assert.Equal(t, Version{}, si.ServerVersion)
+	assert.Equal(t, "P4BROKER 2021.2", si.ServerVersionString)
+}
+
+func TestRunInfoErrors(t *testing.T) {
+	mp4 := &FakeP4Runner{}
+	mp4.On("Run", []string{"info"}).Return([]map[interface{}]interface{}{}, errors.New("connect failed"))
+	mp4.On("Run", []string{"info", "-s"}).Return([]map[interface{}]interface{}{
+		{"code": "stat", "serverVersion": "P4D/LINUX26X86_64/2021.1/2156517", "serverUptime": "soon"}}, nil)
+	_, err := RunInfo(mp4, []string{})
+	assert.Error(t, err)
+	_, err = RunInfo(mp4, []string{"-s"})
+	assert.EqualError(t, err, "Failed to parse uptime 'soon'")
+}
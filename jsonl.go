@@ -0,0 +1,207 @@
+package p4
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// The typed JSONL form of records has one JSON object per line, with keys
+// sorted. Numbers without a decimal point or exponent are integers, null is
+// None, and values JSON can't hold are objects with a single key:
+//
+//	{"$bytes": "base64"}    strings which aren't valid UTF-8
+//	{"$float": "NaN"}       NaN and infinite floats
+//	{"$complex": [re, im]}  complex numbers
+//
+// Decoding gives integers as int32 if they fit, as p4 -G gives them.
+
+// EncodeJSONL writes records in the typed JSONL form
+func EncodeJSONL(w io.Writer, records []Record) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	for _, r := range records {
+		v, err := jsonlValue(map[string]interface{}(r))
+		if err != nil {
+			return err
+		}
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func jsonlFloat(f float64) interface{} {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return map[string]interface{}{"$float": strconv.FormatFloat(f, 'g', -1, 64)}
+	}
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return json.Number(s)
+}
+
+func jsonlValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case nil, bool, int32, int64, int:
+		return v, nil
+	case *big.Int:
+		return json.Number(v.String()), nil
+	case float64:
+		return jsonlFloat(v), nil
+	case complex128:
+		return map[string]interface{}{"$complex": []interface{}{jsonlFloat(real(v)), jsonlFloat(imag(v))}}, nil
+	case string:
+		if !utf8.ValidString(v) {
+			return map[string]interface{}{"$bytes": base64.StdEncoding.EncodeToString([]byte(v))}, nil
+		}
+		return v, nil
+	case []byte:
+		if !utf8.Valid(v) {
+			return map[string]interface{}{"$bytes": base64.StdEncoding.EncodeToString(v)}, nil
+		}
+		return string(v), nil
+	case []interface{}:
+		list := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			j, err := jsonlValue(item)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, j)
+		}
+		return list, nil
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			j, err := jsonlValue(item)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = j
+		}
+		return m, nil
+	case map[interface{}]interface{}:
+		return jsonlValue(map[string]interface{}(NewRecord(v)))
+	}
+	return nil, fmt.Errorf("Failed to encode value of type %T as JSON", v)
+}
+
+// DecodeJSONL reads records in the typed JSONL form, as written by EncodeJSONL.
+// Blank lines are ignored.
+func DecodeJSONL(r io.Reader) ([]Record, error) {
+	records := []Record{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), DefaultMaxAlloc)
+	line := 0
+	for scanner.Scan() {
+		line++
+		b := bytes.TrimSpace(scanner.Bytes())
+		if len(b) == 0 {
+			continue
+		}
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber()
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			return records, fmt.Errorf("Failed to decode JSONL line %d\n%v", line, err)
+		}
+		tv, err := typedValue(v)
+		if err != nil {
+			return records, fmt.Errorf("Failed to decode JSONL line %d\n%v", line, err)
+		}
+		m, ok := tv.(map[string]interface{})
+		if !ok {
+			return records, fmt.Errorf("Failed to decode JSONL line %d, expected an object", line)
+		}
+		records = append(records, Record(m))
+	}
+	return records, scanner.Err()
+}
+
+func typedNumber(n json.Number) (interface{}, error) {
+	s := n.String()
+	if strings.ContainsAny(s, ".eE") {
+		return strconv.ParseFloat(s, 64)
+	}
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if i >= math.MinInt32 && i <= math.MaxInt32 {
+			return int32(i), nil
+		}
+		return i, nil
+	}
+	b, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		return nil, fmt.Errorf("Invalid number %s", s)
+	}
+	return b, nil
+}
+
+func typedFloat(v interface{}) (float64, error) {
+	switch v := v.(type) {
+	case json.Number:
+		return strconv.ParseFloat(v.String(), 64)
+	case map[string]interface{}:
+		if s, ok := v["$float"].(string); ok && len(v) == 1 {
+			return strconv.ParseFloat(s, 64)
+		}
+	}
+	return 0, fmt.Errorf("Invalid float %v", v)
+}
+
+func typedValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case json.Number:
+		return typedNumber(v)
+	case []interface{}:
+		list := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			t, err := typedValue(item)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, t)
+		}
+		return list, nil
+	case map[string]interface{}:
+		if len(v) == 1 {
+			if s, ok := v["$bytes"].(string); ok {
+				return base64.StdEncoding.DecodeString(s)
+			}
+			if _, ok := v["$float"]; ok {
+				return typedFloat(v)
+			}
+			if parts, ok := v["$complex"].([]interface{}); ok && len(parts) == 2 {
+				re, err := typedFloat(parts[0])
+				if err != nil {
+					return nil, err
+				}
+				im, err := typedFloat(parts[1])
+				if err != nil {
+					return nil, err
+				}
+				return complex(re, im), nil
+			}
+		}
+		m := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			t, err := typedValue(item)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = t
+		}
+		return m, nil
+	}
+	return v, nil
+}
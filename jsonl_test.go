@@ -0,0 +1,86 @@
+package p4
+
+import (
+	"bytes"
+	"math"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONLTypes(t *testing.T) {
+	big, _ := new(big.Int).SetString("-123456789012345678901234567890", 10)
+	records := []Record{{
+		"code":    "stat",
+		"num":     int32(-5),
+		"num64":   int64(1) << 40,
+		"big":     big,
+		"float":   2.0,
+		"nan":     math.Inf(-1),
+		"complex": complex(1.5, -2),
+		"none":    nil,
+		"true":    true,
+		"digits":  "0123",
+		"binary":  []byte{0xff, 0x00, 'a'},
+		"list":    []interface{}{int32(1), "two", nil},
+		"dict":    map[string]interface{}{"a": "b"},
+	}}
+
+	var buf bytes.Buffer
+	assert.NoError(t, EncodeJSONL(&buf, records))
+	assert.Equal(t, `{"big":-123456789012345678901234567890,"binary":{"$bytes":"/wBh"},"code":"stat",`+
+		`"complex":{"$complex":[1.5,-2.0]},"dict":{"a":"b"},"digits":"0123","float":2.0,"list":[1,"two",null],`+
+		`"nan":{"$float":"-Inf"},"none":null,"num":-5,"num64":1099511627776,"true":true}`+"\n", buf.String())
+
+	got, err := DecodeJSONL(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, records, got)
+
+	// Strings and bytes are both marshalled as byte strings, so compare as JSONL
+	data, err := Marshal(records[0])
+	assert.NoError(t, err)
+	decoded, err := DecodeRecords(data)
+	assert.NoError(t, err)
+	var want, again bytes.Buffer
+	EncodeJSONL(&want, records)
+	EncodeJSONL(&again, decoded)
+	assert.Equal(t, want.String(), again.String())
+}
+
+func TestJSONLErrors(t *testing.T) {
+	for _, in := range []string{`{"a":`, `[1, 2]`, `{"a": {"$bytes": "!"}}`} {
+		_, err := DecodeJSONL(bytes.NewBufferString(in))
+		assert.Error(t, err, in)
+	}
+	_, err := Marshal(map[interface{}]interface{}{1: "a"})
+	assert.Error(t, err)
+	_, err = Marshal(struct{}{})
+	assert.Error(t, err)
+}
+
+// TestJSONLRoundTrip converts every fixture to JSONL and back to marshal
+func TestJSONLRoundTrip(t *testing.T) {
+	files, _ := filepath.Glob(filepath.Join(testRoot, "..", "testdata", "*.bin"))
+	more, _ := filepath.Glob(filepath.Join(testRoot, "..", "p4unmarshal", "job_*.bin"))
+	files = append(files, more...)
+	assert.NotEmpty(t, files)
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		assert.NoError(t, err)
+		records, err := DecodeRecords(data)
+		assert.NoError(t, err, f)
+
+		var text bytes.Buffer
+		assert.NoError(t, EncodeJSONL(&text, records), f)
+		fromText, err := DecodeJSONL(&text)
+		assert.NoError(t, err, f)
+		var bin bytes.Buffer
+		assert.NoError(t, EncodeRecords(&bin, fromText), f)
+		again, err := DecodeRecords(bin.Bytes())
+		assert.NoError(t, err, f)
+		assert.Equal(t, records, again, f)
+	}
+}
@@ -0,0 +1,207 @@
+package p4
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Label is a label spec, from p4 label -o or a single result from p4 labels
+type Label struct {
+	Label       string
+	Owner       string
+	Update      string
+	Access      string
+	Description string
+	Options     string // e.g. "unlocked noautoreload"
+	Revision    string // e.g. @1234, for labels which don't list revisions
+	View        []string
+	ServerID    string
+}
+
+// Locked reports whether the label's options include locked
+func (l Label) Locked() bool {
+	return hasOption(l.Options, "locked")
+}
+
+// AutoReload reports whether the label's options include autoreload
+func (l Label) AutoReload() bool {
+	return hasOption(l.Options, "autoreload")
+}
+
+func hasOption(options string, opt string) bool {
+	for _, o := range strings.Fields(options) {
+		if o == opt {
+			return true
+		}
+	}
+	return false
+}
+
+func labelFromResult(r map[interface{}]interface{}) Label {
+	l := Label{
+		Label:       getString(r, "Label"),
+		Owner:       getString(r, "Owner"),
+		Update:      getString(r, "Update"),
+		Access:      getString(r, "Access"),
+		Description: getString(r, "Description"),
+		Options:     getString(r, "Options"),
+		Revision:    getString(r, "Revision"),
+		View:        getIndexed(r, "View"),
+		ServerID:    getString(r, "ServerID"),
+	}
+	// p4 labels uses lower case for the name
+	if l.Label == "" {
+		l.Label = getString(r, "label")
+	}
+	return l
+}
+
+// spec returns the fields of the label suitable for p4 label -i.
+// Update and Access are set by the server so are not included.
+func (l Label) spec() map[string]string {
+	spec := map[string]string{}
+	for k, v := range map[string]string{
+		"Label":       l.Label,
+		"Owner":       l.Owner,
+		"Description": l.Description,
+		"Options":     l.Options,
+		"Revision":    l.Revision,
+		"View":        strings.Join(l.View, "\n"),
+		"ServerID":    l.ServerID,
+	} {
+		if v != "" {
+			spec[k] = v
+		}
+	}
+	return spec
+}
+
+// ListLabels runs p4 labels args..., e.g. -u user, -e or -E filter, -m max
+// or file arguments
+func ListLabels(p4r Runner, args []string) ([]Label, error) {
+	args = append([]string{"labels"}, args...)
+	res, err := p4r.Run(args)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to run p4 %s\n%v", args, err)
+	}
+	labels := []Label{}
+	for _, r := range res {
+		if getString(r, "code") == "error" {
+			return nil, parseError(r)
+		}
+		labels = append(labels, labelFromResult(r))
+	}
+	return labels, nil
+}
+
+// FetchLabel runs p4 label -o name
+func FetchLabel(p4r Runner, name string) (Label, error) {
+	args := []string{"label", "-o", name}
+	res, err := p4r.Run(args)
+	if err != nil {
+		return Label{}, fmt.Errorf("Failed to run p4 %s\n%v", args, err)
+	}
+	if len(res) == 0 {
+		return Label{}, fmt.Errorf("No output from p4 %s", args)
+	}
+	if getString(res[0], "code") == "error" {
+		return Label{}, parseError(res[0])
+	}
+	return labelFromResult(res[0]), nil
+}
+
+// SaveLabel runs p4 label -i args... with the label spec, e.g. args of
+// "-f" to update a locked label or one owned by another user
+func SaveLabel(p4r SpecRunner, l Label, args ...string) (string, error) {
+	return p4r.SaveTxt("label", l.spec(), args...)
+}
+
+// DeleteLabel runs p4 label -d args... name
+func DeleteLabel(p4r Runner, name string, args ...string) error {
+	return runDelete(p4r, "label", name, args)
+}
+
+// LabelFile is a file added to, deleted from or updated in a label
+type LabelFile struct {
+	DepotFile string
+	Rev       int
+	Action    string // added, deleted or updated
+}
+
+// LabelResult is the files changed by p4 tag or p4 labelsync, with counts
+// of each action
+type LabelResult struct {
+	Files   []LabelFile
+	Added   int
+	Deleted int
+	Updated int
+}
+
+func (lr LabelResult) String() string {
+	return fmt.Sprintf("%d added, %d deleted, %d updated", lr.Added, lr.Deleted, lr.Updated)
+}
+
+// LabelsyncOptions are the flags for p4 labelsync
+type LabelsyncOptions struct {
+	Add     bool // -a: add files without deleting any
+	Delete  bool // -d: delete the files
+	Preview bool // -n: report what would be done
+	Global  bool // -g: update a global label on an edge server
+}
+
+// runLabelFiles runs p4 tag or p4 labelsync, ignoring warnings such as
+// "label in sync"
+func runLabelFiles(p4r Runner, args []string) (LabelResult, error) {
+	res, err := p4r.Run(args)
+	if err != nil {
+		return LabelResult{}, fmt.Errorf("Failed to run p4 %s\n%v", args, err)
+	}
+	lr := LabelResult{Files: []LabelFile{}}
+	for _, r := range res {
+		rec := NewRecord(r)
+		if rec.Code() == "error" {
+			if severity, _ := rec.GetInt("severity"); severity > SeverityWarn {
+				return lr, parseError(r)
+			}
+			continue
+		}
+		if !rec.Has("depotFile") {
+			continue
+		}
+		rev, _ := strconv.Atoi(rec.Get("rev"))
+		f := LabelFile{DepotFile: rec.Get("depotFile"), Rev: rev, Action: rec.Get("action")}
+		switch f.Action {
+		case "added":
+			lr.Added++
+		case "deleted":
+			lr.Deleted++
+		case "updated":
+			lr.Updated++
+		}
+		lr.Files = append(lr.Files, f)
+	}
+	return lr, nil
+}
+
+// Tag runs p4 tag -l label files..., adding the files to the label
+func Tag(p4r Runner, label string, files ...string) (LabelResult, error) {
+	args := append([]string{"tag", "-l", label}, files...)
+	return runLabelFiles(p4r, args)
+}
+
+// Labelsync runs p4 labelsync -l label files..., making the label contain
+// the files, or the have list of the client if no files are given
+func Labelsync(p4r Runner, label string, opts LabelsyncOptions, files ...string) (LabelResult, error) {
+	args := []string{"labelsync"}
+	for _, f := range []struct {
+		set  bool
+		flag string
+	}{{opts.Add, "-a"}, {opts.Delete, "-d"}, {opts.Preview, "-n"}, {opts.Global, "-g"}} {
+		if f.set {
+			args = append(args, f.flag)
+		}
+	}
+	args = append(append(args, "-l", label), files...)
+	return runLabelFiles(p4r, args)
+}
@@ -0,0 +1,74 @@
+package p4
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLabelSpec(t *testing.T) {
+	mp4 := &FakeP4Runner{}
+	mp4.On("Run", []string{"label", "-o", "rel-1.0"}).Return([]map[interface{}]interface{}{
+		{"code": "stat", "Label": "rel-1.0", "Owner": "bob", "Update": "2021/06/01 12:00:00", "Access": "2021/06/01 12:00:00",
+			"Description": "Release 1.0\n", "Options": "locked autoreload", "Revision": "@1234",
+			"View0": "//depot/main/...", "View1": "-//depot/main/tests/..."},
+	}, nil)
+	mp4.On("Run", []string{"labels", "-u", "bob", "-m", "1"}).Return([]map[interface{}]interface{}{
+		{"code": "stat", "label": "rel-1.0", "Owner": "bob", "Update": "1622548800", "Access": "1622548800",
+			"Options": "locked autoreload", "Description": "Release 1.0\n"},
+	}, nil)
+
+	l, err := FetchLabel(mp4, "rel-1.0")
+	assert.NoError(t, err)
+	assert.True(t, l.Locked())
+	assert.True(t, l.AutoReload())
+	assert.Equal(t, []string{"//depot/main/...", "-//depot/main/tests/..."}, l.View)
+	assert.False(t, Label{Options: "unlocked noautoreload"}.Locked())
+	assert.False(t, Label{Options: "unlocked noautoreload"}.AutoReload())
+
+	labels, err := ListLabels(mp4, []string{"-u", "bob", "-m", "1"})
+	assert.NoError(t, err)
+	assert.Equal(t, "rel-1.0", labels[0].Label)
+	assert.Equal(t, "1622548800", labels[0].Update)
+
+	l.Options = "unlocked noautoreload"
+	mp4.On("SaveTxt", "label", map[string]string{"Label": "rel-1.0", "Owner": "bob", "Description": "Release 1.0\n",
+		"Options": "unlocked noautoreload", "Revision": "@1234", "View": "//depot/main/...\n-//depot/main/tests/..."},
+		[]string{"-f"}).Return("Label rel-1.0 saved.\n", nil)
+	msg, err := SaveLabel(mp4, l, "-f")
+	assert.NoError(t, err)
+	assert.Equal(t, "Label rel-1.0 saved.\n", msg)
+
+	mp4.On("Run", []string{"label", "-d", "rel-0.9"}).Return([]map[interface{}]interface{}{
+		{"code": "error", "data": "Label 'rel-0.9' doesn't exist.\n", "severity": int32(3), "generic": int32(2)}}, nil)
+	assert.Error(t, DeleteLabel(mp4, "rel-0.9"))
+}
+
+func TestTagAndLabelsync(t *testing.T) {
+	mp4 := &FakeP4Runner{}
+	mp4.On("Run", []string{"tag", "-l", "build-42", "//depot/main/...@42"}).Return([]map[interface{}]interface{}{
+		{"code": "stat", "depotFile": "//depot/main/a.txt", "rev": "3", "action": "added"},
+		{"code": "stat", "depotFile": "//depot/main/b.txt", "rev": "1", "action": "updated"},
+	}, nil)
+	mp4.On("Run", []string{"labelsync", "-n", "-l", "build-42", "//depot/main/..."}).Return([]map[interface{}]interface{}{
+		{"code": "stat", "depotFile": "//depot/main/b.txt", "rev": "2", "action": "updated"},
+		{"code": "stat", "depotFile": "//depot/main/c.txt", "rev": "1", "action": "deleted"},
+		{"code": "error", "data": "//depot/main/a.txt#3 - label in sync.\n", "severity": int32(2), "generic": int32(17)},
+	}, nil)
+	mp4.On("Run", []string{"labelsync", "-a", "-l", "locked"}).Return([]map[interface{}]interface{}{
+		{"code": "error", "data": "Can't modify locked label 'locked'.\n", "severity": int32(3), "generic": int32(4)},
+	}, nil)
+
+	lr, err := Tag(mp4, "build-42", "//depot/main/...@42")
+	assert.NoError(t, err)
+	assert.Equal(t, LabelResult{Files: []LabelFile{{"//depot/main/a.txt", 3, "added"}, {"//depot/main/b.txt", 1, "updated"}},
+		Added: 1, Updated: 1}, lr)
+
+	lr, err = Labelsync(mp4, "build-42", LabelsyncOptions{Preview: true}, "//depot/main/...")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(lr.Files))
+	assert.Equal(t, "0 added, 1 deleted, 1 updated", lr.String())
+
+	_, err = Labelsync(mp4, "locked", LabelsyncOptions{Add: true})
+	assert.EqualError(t, err, "P4Error -> Can't modify locked label 'locked'.\n")
+}
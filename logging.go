@@ -0,0 +1,123 @@
+package p4
+
+import (
+	"os/exec"
+	"time"
+)
+
+// Logger is used by P4 to log the commands it runs. It is satisfied by
+// *logrus.Logger and similar loggers.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// nopLogger discards all logging, and is used unless WithLogger is given
+type nopLogger struct{}
+
+func (nopLogger) Debugf(format string, args ...interface{}) {}
+func (nopLogger) Errorf(format string, args ...interface{}) {}
+
+// redacted replaces passwords and tickets in logs and hook events
+const redacted = "********"
+
+// CommandEvent describes a p4 command run by a P4, for BeforeRun and AfterRun hooks
+type CommandEvent struct {
+	Args     []string // full command line including global options
+	Command  string   // command name, e.g. "fstat"
+	Start    time.Time
+	Duration time.Duration // set for AfterRun
+	ExitCode int           // exit code of p4, or -1 if it couldn't be run
+	Records  int           // number of results decoded from -G output
+	Errors   int           // number of those results which are errors
+	Bytes    int           // number of bytes read from stdout
+	Err      error
+
+	// Data may be set by a BeforeRun hook for its AfterRun hook, e.g. a tracing span
+	Data interface{}
+}
+
+// Hooks are called before and after every p4 command run by a P4
+type Hooks struct {
+	BeforeRun func(ev *CommandEvent)
+	AfterRun  func(ev *CommandEvent)
+}
+
+// logger returns the P4's Logger
+func (p4 *P4) logger() Logger {
+	if p4.log == nil {
+		return nopLogger{}
+	}
+	return p4.log
+}
+
+// redactArgs returns a copy of args with any password or ticket hidden,
+// unless redaction has been turned off
+func (p4 *P4) redactArgs(args []string) []string {
+	res := append([]string{}, args...)
+	if p4.noRedact {
+		return res
+	}
+	for i := 0; i < len(res)-1; i++ {
+		if res[i] == "-P" {
+			res[i+1] = redacted
+		}
+	}
+	return res
+}
+
+// redactSpec returns a copy of a spec with any password hidden, unless
+// redaction has been turned off
+func (p4 *P4) redactSpec(spec map[string]string) map[string]string {
+	res := make(map[string]string, len(spec))
+	for k, v := range spec {
+		if k == "Password" && !p4.noRedact {
+			v = redacted
+		}
+		res[k] = v
+	}
+	return res
+}
+
+// startEvent logs a command and calls BeforeRun hooks
+func (p4 *P4) startEvent(args []string) *CommandEvent {
+	ev := &CommandEvent{
+		Args:    p4.redactArgs(args),
+		Command: commandName(args),
+		Start:   time.Now(),
+	}
+	p4.logger().Debugf("%s %v", p4.Executable(), ev.Args)
+	for _, h := range p4.hooks {
+		if h.BeforeRun != nil {
+			h.BeforeRun(ev)
+		}
+	}
+	return ev
+}
+
+// finishEvent records the outcome of a command, logs it and calls AfterRun hooks
+func (p4 *P4) finishEvent(ev *CommandEvent, cmd *exec.Cmd, bytes int, results []map[interface{}]interface{}, err error) {
+	ev.Duration = time.Since(ev.Start)
+	ev.Bytes = bytes
+	ev.Records = len(results)
+	for _, r := range results {
+		if getString(r, "code") == "error" {
+			ev.Errors++
+		}
+	}
+	ev.Err = err
+	ev.ExitCode = -1
+	if cmd.ProcessState != nil {
+		ev.ExitCode = cmd.ProcessState.ExitCode()
+	}
+	if err != nil {
+		p4.logger().Errorf("%s %v failed after %v: %v", p4.Executable(), ev.Args, ev.Duration, err)
+	} else {
+		p4.logger().Debugf("%s %v took %v, %d bytes, %d records", p4.Executable(), ev.Args, ev.Duration, ev.Bytes, ev.Records)
+	}
+	for _, h := range p4.hooks {
+		if h.AfterRun != nil {
+			h.AfterRun(ev)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package p4
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingLogger keeps everything logged
+type recordingLogger struct {
+	lines []string
+}
+
+func (l *recordingLogger) Debugf(format string, args ...interface{}) {
+	l.lines = append(l.lines, "DEBUG "+fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Errorf(format string, args ...interface{}) {
+	l.lines = append(l.lines, "ERROR "+fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) String() string {
+	return strings.Join(l.lines, "\n")
+}
+
+func TestHooks(t *testing.T) {
+	changes, err := filepath.Abs(filepath.Join(testRoot, "..", "testdata", "changes.bin"))
+	assert.Nil(t, err)
+	exe := fakeP4Executable(t, fmt.Sprintf("case \"$*\" in\n*changes*) cat %s ;;\n*) echo failed >&2; exit 3 ;;\nesac\n", changes))
+	events := []CommandEvent{}
+	log := &recordingLogger{}
+	p4 := NewP4(WithExecutable(exe), WithPassword("SECRET"), WithLogger(log), WithHooks(Hooks{
+		BeforeRun: func(ev *CommandEvent) {
+			assert.Equal(t, 0, ev.Records)
+			ev.Data = "span"
+		},
+		AfterRun: func(ev *CommandEvent) {
+			assert.Equal(t, "span", ev.Data)
+			events = append(events, *ev)
+		},
+	}))
+
+	res, err := p4.Run([]string{"changes", "-m3"})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(res))
+	_, err = p4.Run([]string{"fstat", "//..."})
+	assert.NotNil(t, err)
+
+	assert.Equal(t, 2, len(events))
+	ev := events[0]
+	assert.Equal(t, []string{"-G", "-P", redacted, "changes", "-m3"}, ev.Args)
+	assert.Equal(t, "changes", ev.Command)
+	assert.Equal(t, 0, ev.ExitCode)
+	assert.Equal(t, 3, ev.Records)
+	assert.True(t, ev.Bytes > 0)
+	assert.Nil(t, ev.Err)
+	assert.True(t, ev.Duration > 0)
+
+	ev = events[1]
+	assert.Equal(t, "fstat", ev.Command)
+	assert.Equal(t, 3, ev.ExitCode)
+	assert.Equal(t, 0, ev.Records)
+	assert.Equal(t, "failed\n", ev.Err.Error())
+
+	assert.NotContains(t, log.String(), "SECRET")
+	assert.Contains(t, log.String(), "DEBUG "+exe+" [-G -P ******** changes -m3]")
+	assert.Contains(t, log.String(), "ERROR "+exe+" [-G -P ******** fstat //...] failed after")
+}
+
+func TestSaveTxtLogging(t *testing.T) {
+	exe := fakeP4Executable(t, "cat > /dev/null\necho User a.person saved.\n")
+	log := &recordingLogger{}
+	p4 := NewP4(WithExecutable(exe), WithLogger(log))
+	res, err := p4.SaveTxt("user", map[string]string{"User": "a.person", "Password": "SECRET"}, "-f")
+	assert.Nil(t, err)
+	assert.Equal(t, "User a.person saved.\n", res)
+	assert.NotContains(t, log.String(), "SECRET")
+	assert.Contains(t, log.String(), "Password: ********")
+
+	log = &recordingLogger{}
+	p4 = NewP4(WithExecutable(exe), WithLogger(log), WithRedaction(false), WithPassword("TICKET"))
+	_, err = p4.SaveTxt("user", map[string]string{"User": "a.person", "Password": "SECRET"}, "-f")
+	assert.Nil(t, err)
+	assert.Contains(t, log.String(), "Password: SECRET")
+	assert.Contains(t, log.String(), "-P TICKET")
+}
+
+func TestNoLogger(t *testing.T) {
+	exe := fakeP4Executable(t, "exit 0\n")
+	res, err := NewP4(WithExecutable(exe)).Run([]string{"info"})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(res))
+}
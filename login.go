@@ -0,0 +1,159 @@
+package p4
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Messages from the server meaning a ticket is missing or expired
+var reLoginRequired = regexp.MustCompile(`Perforce password \(P4PASSWD\) invalid or unset|Your session has expired, please login again|Your session was logged out, please login again`)
+
+// LoginStatus is the result of p4 login -s
+type LoginStatus struct {
+	User             string
+	LoggedIn         bool
+	TicketExpiration time.Duration // time left on the ticket
+	Message          string        // server message if not logged in
+}
+
+// SetPasswordFunc sets a function used to get the user's password when a
+// command fails because the ticket has expired. Run, Save and SaveTxt then
+// log in and retry the command once.
+func (p4 *P4) SetPasswordFunc(f func(user string) (string, error)) {
+	p4.passwordFunc = f
+}
+
+// needsLogin returns true if a command failed because the user must log in
+func needsLogin(results []map[interface{}]interface{}, err error) bool {
+	if err != nil && reLoginRequired.MatchString(err.Error()) {
+		return true
+	}
+	for _, r := range results {
+		if getString(r, "code") == "error" && reLoginRequired.MatchString(getString(r, "data")) {
+			return true
+		}
+	}
+	return false
+}
+
+// loginAgain logs in if a command failed because the user must log in and
+// a password function has been set, returning true if the command should
+// be run again. The user is the effective one, e.g. from P4CONFIG, if none
+// was given to this P4.
+func (p4 *P4) loginAgain(results []map[interface{}]interface{}, err error) (bool, error) {
+	if p4.passwordFunc == nil || !needsLogin(results, err) {
+		return false, nil
+	}
+	user := p4.user
+	if user == "" {
+		e, eerr := p4.Effective()
+		if eerr != nil {
+			return false, eerr
+		}
+		user = e.User.Value
+	}
+	password, perr := p4.passwordFunc(user)
+	if perr != nil {
+		return false, perr
+	}
+	if lerr := p4.Login(password); lerr != nil {
+		return false, lerr
+	}
+	return true, nil
+}
+
+// runText runs a p4 command without -G, writing input to stdin, and returns stdout
+func (p4 *P4) runText(args []string, input string) (string, error) {
+	args = append(p4.getOptionsNonMarshal(), args...)
+	cmd := p4.command(args)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = strings.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	ev := p4.startEvent(args)
+	err := cmd.Run()
+	if stderr.Len() > 0 {
+		err = errors.New(strings.TrimSpace(stderr.String()))
+	}
+	p4.finishEvent(ev, cmd, stdout.Len(), nil, err)
+	return stdout.String(), err
+}
+
+// Login runs p4 login args... with the password on stdin, which updates the
+// ticket in the P4TICKETS file. Use args of "-a" for a ticket valid on all hosts.
+func (p4 *P4) Login(password string, args ...string) error {
+	args = append([]string{"login"}, args...)
+	_, err := p4.runText(args, password+"\n")
+	if err != nil {
+		return fmt.Errorf("Failed to run p4 %s\n%v", args, err)
+	}
+	return nil
+}
+
+// LoginStatus runs p4 login -s
+func (p4 *P4) LoginStatus() (LoginStatus, error) {
+	args := []string{"login", "-s"}
+	res, _, err := p4.run(args)
+	if err != nil {
+		if reLoginRequired.MatchString(err.Error()) {
+			return LoginStatus{User: p4.user, Message: strings.TrimSpace(err.Error())}, nil
+		}
+		return LoginStatus{}, fmt.Errorf("Failed to run p4 %s\n%v", args, err)
+	}
+	return parseLoginStatus(p4.user, res), nil
+}
+
+func parseLoginStatus(user string, res []map[interface{}]interface{}) LoginStatus {
+	ls := LoginStatus{User: user}
+	for _, r := range res {
+		switch getString(r, "code") {
+		case "stat":
+			ls.LoggedIn = true
+			if u := getString(r, "User"); u != "" {
+				ls.User = u
+			}
+			if v, err := strconv.ParseInt(getString(r, "TicketExpiration"), 10, 64); err == nil {
+				ls.TicketExpiration = time.Duration(v) * time.Second
+			}
+		case "info":
+			// e.g. 'login' not necessary, no password set for this user.
+			ls.LoggedIn = true
+			ls.Message = strings.TrimSpace(getString(r, "data"))
+		default:
+			ls.Message = strings.TrimSpace(getString(r, "data"))
+		}
+	}
+	return ls
+}
+
+// Logout runs p4 logout args..., e.g. "-a" to invalidate the ticket on all hosts
+func (p4 *P4) Logout(args ...string) error {
+	args = append([]string{"logout"}, args...)
+	res, _, err := p4.run(args)
+	if err != nil {
+		return fmt.Errorf("Failed to run p4 %s\n%v", args, err)
+	}
+	for _, r := range res {
+		if getString(r, "code") == "error" {
+			return parseError(r)
+		}
+	}
+	return nil
+}
+
+// Trust runs p4 trust -y args... to accept the server's SSL fingerprint,
+// which is added to the P4TRUST file. Use args of "-f" to replace a changed
+// fingerprint, or "-i", fingerprint to install a known one.
+func (p4 *P4) Trust(args ...string) error {
+	args = append([]string{"trust", "-y"}, args...)
+	_, err := p4.runText(args, "")
+	if err != nil {
+		return fmt.Errorf("Failed to run p4 %s\n%v", args, err)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package p4
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNeedsLogin(t *testing.T) {
+	assert.False(t, needsLogin(nil, nil))
+	assert.False(t, needsLogin(nil, errors.New("Connect to server failed; check $P4PORT.")))
+	assert.True(t, needsLogin(nil, errors.New("Perforce password (P4PASSWD) invalid or unset.\n")))
+	assert.True(t, needsLogin([]map[interface{}]interface{}{{
+		"code":     "error",
+		"data":     "Your session has expired, please login again.\n",
+		"generic":  "36",
+		"severity": "3",
+	}}, nil))
+	assert.False(t, needsLogin([]map[interface{}]interface{}{{
+		"code": "stat",
+		"data": "Your session has expired, please login again.\n",
+	}}, nil))
+}
+
+func TestParseLoginStatus(t *testing.T) {
+	ls := parseLoginStatus("a.person", []map[interface{}]interface{}{{
+		"code":             "stat",
+		"User":             "a.person",
+		"TicketExpiration": "43158",
+	}})
+	assert.Equal(t, LoginStatus{User: "a.person", LoggedIn: true, TicketExpiration: 43158 * time.Second}, ls)
+
+	ls = parseLoginStatus("a.person", []map[interface{}]interface{}{{
+		"code":     "error",
+		"data":     "Perforce password (P4PASSWD) invalid or unset.\n",
+		"generic":  "36",
+		"severity": "3",
+	}})
+	assert.Equal(t, LoginStatus{User: "a.person", Message: "Perforce password (P4PASSWD) invalid or unset."}, ls)
+
+	ls = parseLoginStatus("a.person", []map[interface{}]interface{}{{
+		"code": "info",
+		"data": "'login' not necessary, no password set for this user.",
+	}})
+	assert.Equal(t, LoginStatus{User: "a.person", LoggedIn: true, Message: "'login' not necessary, no password set for this user."}, ls)
+}
+
+// expiredP4 returns a fake p4 which fails commands with an expired session
+// until p4 login has been run, logging each command to the returned file
+func expiredP4(t *testing.T, output string) (string, string) {
+	dir := t.TempDir()
+	log := filepath.Join(dir, "commands")
+	exe := fakeP4Executable(t, fmt.Sprintf(`echo "$*" >> %[1]s
+case "$*" in
+*login*) cat > /dev/null; touch %[2]s ;;
+*) if [ -f %[2]s ]; then %[3]s; else echo "Your session has expired, please login again." >&2; exit 1; fi ;;
+esac
+`, log, filepath.Join(dir, "loggedin"), output))
+	return exe, log
+}
+
+// commandCounts returns how many times each command was run
+func commandCounts(t *testing.T, log string) map[string]int {
+	data, err := os.ReadFile(log)
+	assert.NoError(t, err)
+	counts := map[string]int{}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		fields := strings.Fields(line)
+		for _, cmd := range []string{"login", "info", "job"} {
+			for _, f := range fields {
+				if f == cmd {
+					counts[cmd]++
+				}
+			}
+		}
+	}
+	return counts
+}
+
+func TestRunLogsInAgain(t *testing.T) {
+	info, err := filepath.Abs(filepath.Join(testRoot, "..", "testdata", "info.bin"))
+	assert.NoError(t, err)
+	exe, log := expiredP4(t, "cat "+info)
+	users := []string{}
+	p4 := NewP4(WithExecutable(exe), WithDir(t.TempDir()),
+		WithEnv("P4USER=envuser", "P4CONFIG=", "P4ENVIRO="+filepath.Join(t.TempDir(), "none")))
+	p4.SetPasswordFunc(func(user string) (string, error) {
+		users = append(users, user)
+		return "secret", nil
+	})
+
+	res, err := p4.Run([]string{"info"})
+	assert.NoError(t, err)
+	assert.Equal(t, "rcowham", res[0]["userName"])
+	assert.Equal(t, []string{"envuser"}, users)
+	assert.Equal(t, map[string]int{"login": 1, "info": 2}, commandCounts(t, log))
+}
+
+func TestSaveTxtLogsInAgain(t *testing.T) {
+	exe, log := expiredP4(t, "cat > /dev/null; echo Job job000001 saved.")
+	p4 := NewP4Params("", "bob", "", WithExecutable(exe))
+	users := []string{}
+	p4.SetPasswordFunc(func(user string) (string, error) {
+		users = append(users, user)
+		return "secret", nil
+	})
+
+	msg, err := p4.SaveTxt("job", map[string]string{"Job": "new", "Description": "Fix it"})
+	assert.NoError(t, err)
+	assert.Equal(t, "Job job000001 saved.\n", msg)
+	assert.Equal(t, []string{"bob"}, users)
+	assert.Equal(t, map[string]int{"login": 1, "job": 2}, commandCounts(t, log))
+}
@@ -0,0 +1,202 @@
+package p4
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// MappingLine is a single line of a view, such as a branch or client view,
+// mapping a source path to a target path, e.g.
+// //depot/main/... //depot/rel/...
+type MappingLine struct {
+	Source  string
+	Target  string
+	Exclude bool // the line started with -
+	Overlay bool // the line started with +
+
+	re        *regexp.Regexp
+	targetRe  *regexp.Regexp
+	wildcards []string // wildcards in the source, in order
+}
+
+// View maps paths through the lines of a view, where later lines override
+// earlier ones on both the source and target side as they do in p4
+type View struct {
+	Lines []MappingLine
+}
+
+// splitViewLine splits a view line into its two paths, which may be quoted
+func splitViewLine(line string) ([]string, error) {
+	paths := []string{}
+	line = strings.TrimSpace(line)
+	for line != "" {
+		var p string
+		if strings.HasPrefix(line, `"`) {
+			end := strings.Index(line[1:], `"`)
+			if end < 0 {
+				return nil, fmt.Errorf("Unterminated quote in view line '%s'", line)
+			}
+			p, line = line[1:end+1], line[end+2:]
+		} else if strings.HasPrefix(line, `-"`) || strings.HasPrefix(line, `+"`) {
+			end := strings.Index(line[2:], `"`)
+			if end < 0 {
+				return nil, fmt.Errorf("Unterminated quote in view line '%s'", line)
+			}
+			p, line = line[:1]+line[2:end+2], line[end+3:]
+		} else if i := strings.IndexAny(line, " \t"); i >= 0 {
+			p, line = line[:i], line[i:]
+		} else {
+			p, line = line, ""
+		}
+		paths = append(paths, p)
+		line = strings.TrimSpace(line)
+	}
+	return paths, nil
+}
+
+var reWildcard = regexp.MustCompile(`\.\.\.|\*|%%[1-9]`)
+
+// compileMapping returns a regexp matching a path pattern, with a group
+// for each wildcard, and the wildcards in order
+func compileMapping(pattern string) (*regexp.Regexp, []string) {
+	var b strings.Builder
+	b.WriteString("^")
+	wildcards := []string{}
+	last := 0
+	for _, loc := range reWildcard.FindAllStringIndex(pattern, -1) {
+		b.WriteString(regexp.QuoteMeta(pattern[last:loc[0]]))
+		w := pattern[loc[0]:loc[1]]
+		if w == "..." {
+			b.WriteString("(.*)")
+		} else {
+			b.WriteString("([^/]*)")
+		}
+		wildcards = append(wildcards, w)
+		last = loc[1]
+	}
+	b.WriteString(regexp.QuoteMeta(pattern[last:]))
+	b.WriteString("$")
+	return regexp.MustCompile(b.String()), wildcards
+}
+
+// sameWildcards reports whether both sides of a line have the same wildcards
+func sameWildcards(a []string, b []string) bool {
+	count := map[string]int{}
+	for _, w := range a {
+		count[w]++
+	}
+	for _, w := range b {
+		count[w]--
+	}
+	for _, n := range count {
+		if n != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseView parses the lines of a view such as Branch.View
+func ParseView(lines []string) (*View, error) {
+	v := &View{Lines: []MappingLine{}}
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		paths, err := splitViewLine(line)
+		if err != nil {
+			return nil, err
+		}
+		if len(paths) != 2 {
+			return nil, fmt.Errorf("View line '%s' should have two paths", line)
+		}
+		ml := MappingLine{Source: paths[0], Target: paths[1]}
+		switch ml.Source[0] {
+		case '-':
+			ml.Exclude = true
+			ml.Source = ml.Source[1:]
+		case '+':
+			ml.Overlay = true
+			ml.Source = ml.Source[1:]
+		}
+		ml.re, ml.wildcards = compileMapping(ml.Source)
+		var targetWildcards []string
+		ml.targetRe, targetWildcards = compileMapping(ml.Target)
+		if !sameWildcards(ml.wildcards, targetWildcards) {
+			return nil, fmt.Errorf("Wildcards don't match in view line '%s'", line)
+		}
+		v.Lines = append(v.Lines, ml)
+	}
+	return v, nil
+}
+
+// translate maps a path matched by the line's source to its target
+func (ml MappingLine) translate(path string) (string, bool) {
+	m := ml.re.FindStringSubmatch(path)
+	if m == nil {
+		return "", false
+	}
+	captures := m[1:]
+	positional := map[string]string{}
+	ordered := []string{}
+	for i, w := range ml.wildcards {
+		if strings.HasPrefix(w, "%%") {
+			positional[w] = captures[i]
+		} else {
+			ordered = append(ordered, captures[i])
+		}
+	}
+	next := 0
+	target := reWildcard.ReplaceAllStringFunc(ml.Target, func(w string) string {
+		if strings.HasPrefix(w, "%%") {
+			return positional[w]
+		}
+		if next >= len(ordered) {
+			return ""
+		}
+		next++
+		return ordered[next-1]
+	})
+	return target, true
+}
+
+// Match returns the target a path maps to and the index of the line which
+// maps it. Paths which no line maps, or which are excluded, are not ok, and
+// line is the line which excludes them. A later line whose target matches,
+// including an excluding line, overrides the mapping as the path's source
+// would, though overlay (+) lines don't.
+func (v *View) Match(path string) (target string, line int, ok bool) {
+	for i := len(v.Lines) - 1; i >= 0; i-- {
+		ml := v.Lines[i]
+		if !ml.re.MatchString(path) {
+			continue
+		}
+		if ml.Exclude {
+			return "", i, false
+		}
+		target, _ = ml.translate(path)
+		if j := v.targetOverride(target, i); j >= 0 {
+			return "", j, false
+		}
+		return target, i, true
+	}
+	return "", -1, false
+}
+
+// targetOverride returns the index of the last line after line whose target
+// matches target, or -1 if there is none
+func (v *View) targetOverride(target string, line int) int {
+	for j := len(v.Lines) - 1; j > line; j-- {
+		if !v.Lines[j].Overlay && v.Lines[j].targetRe.MatchString(target) {
+			return j
+		}
+	}
+	return -1
+}
+
+// Translate returns the target a path maps to
+func (v *View) Translate(path string) (string, bool) {
+	target, _, ok := v.Match(path)
+	return target, ok
+}
@@ -0,0 +1,53 @@
+package p4
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestViewTranslate(t *testing.T) {
+	view, err := ParseView([]string{
+		"//depot/main/... //depot/rel/...",
+		"-//depot/main/tests/... //depot/rel/tests/...",
+		"//depot/main/tests/smoke/... //depot/rel/tests/smoke/...",
+		"//depot/main/*.txt //depot/rel/docs/*.txt",
+		"//depot/main/lib/%%1/%%2.c //depot/rel/src/%%2/%%1.c",
+		`"//depot/main/my dir/..." "//depot/rel/your dir/..."`,
+		"//depot/other/readme.md //depot/rel/src/README.md",
+		"-//depot/other/private/... //depot/rel/src/private/...",
+	})
+	assert.NoError(t, err)
+	tests := []struct {
+		path   string
+		target string
+		line   int
+		ok     bool
+	}{
+		{"//depot/main/src/a.c", "//depot/rel/src/a.c", 0, true},
+		{"//depot/main/tests/unit/a_test.c", "", 1, false},
+		{"//depot/main/tests/smoke/run.sh", "//depot/rel/tests/smoke/run.sh", 2, true},
+		{"//depot/main/README.txt", "//depot/rel/docs/README.txt", 3, true},
+		{"//depot/main/src/notes.txt", "//depot/rel/src/notes.txt", 0, true},
+		{"//depot/main/lib/net/http.c", "//depot/rel/src/http/net.c", 4, true},
+		{"//depot/main/my dir/x", "//depot/rel/your dir/x", 5, true},
+		{"//depot/other/a.c", "", -1, false},
+		// Later lines override earlier ones on the target side too
+		{"//depot/main/src/README.md", "", 6, false},
+		{"//depot/other/readme.md", "//depot/rel/src/README.md", 6, true},
+		{"//depot/main/src/private/key.pem", "", 7, false},
+	}
+	for _, tt := range tests {
+		target, line, ok := view.Match(tt.path)
+		assert.Equal(t, tt.target, target, tt.path)
+		assert.Equal(t, tt.line, line, tt.path)
+		assert.Equal(t, tt.ok, ok, tt.path)
+	}
+	assert.True(t, view.Lines[1].Exclude)
+	assert.Equal(t, "//depot/main/my dir/...", view.Lines[5].Source)
+
+	for _, bad := range []string{"//depot/main/...", "//depot/main/... //depot/rel/*", `"//depot/main/... //depot/rel/...`} {
+		_, err := ParseView([]string{bad})
+		assert.Error(t, err, bad)
+	}
+}
@@ -0,0 +1,142 @@
+package p4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"sort"
+)
+
+// Marshal encodes a value in the python marshal format read by p4 -G -i
+// and the Decoder. Strings and []byte are written as byte strings, as p4
+// writes them, and dict keys are sorted so the output is reproducible.
+func Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := marshalValue(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// EncodeRecords writes records in the marshal format, as p4 -G outputs them
+func EncodeRecords(w io.Writer, records []Record) error {
+	for _, r := range records {
+		b, err := Marshal(r)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func marshalInt32(buf *bytes.Buffer, n int32) {
+	binary.Write(buf, binary.LittleEndian, n)
+}
+
+func marshalString(buf *bytes.Buffer, s []byte) {
+	buf.WriteByte(codeString)
+	marshalInt32(buf, int32(len(s)))
+	buf.Write(s)
+}
+
+func marshalValue(buf *bytes.Buffer, v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteByte(codeNone)
+	case bool:
+		if v {
+			buf.WriteByte(codeTrue)
+		} else {
+			buf.WriteByte(codeFalse)
+		}
+	case int32:
+		buf.WriteByte(codeInt)
+		marshalInt32(buf, v)
+	case int:
+		return marshalValue(buf, int64(v))
+	case int64:
+		if v >= math.MinInt32 && v <= math.MaxInt32 {
+			buf.WriteByte(codeInt)
+			marshalInt32(buf, int32(v))
+		} else {
+			buf.WriteByte(codeInt64)
+			binary.Write(buf, binary.LittleEndian, v)
+		}
+	case *big.Int:
+		marshalLong(buf, v)
+	case float64:
+		buf.WriteByte(codeFloat)
+		binary.Write(buf, binary.LittleEndian, math.Float64bits(v))
+	case complex128:
+		buf.WriteByte(codeComplex)
+		binary.Write(buf, binary.LittleEndian, math.Float64bits(real(v)))
+		binary.Write(buf, binary.LittleEndian, math.Float64bits(imag(v)))
+	case string:
+		marshalString(buf, []byte(v))
+	case []byte:
+		marshalString(buf, v)
+	case []interface{}:
+		buf.WriteByte(codeList)
+		marshalInt32(buf, int32(len(v)))
+		for _, item := range v {
+			if err := marshalValue(buf, item); err != nil {
+				return err
+			}
+		}
+	case Record:
+		return marshalValue(buf, map[string]interface{}(v))
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		buf.WriteByte(codeDict)
+		for _, k := range keys {
+			marshalString(buf, []byte(k))
+			if err := marshalValue(buf, v[k]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(codeStop)
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			s, ok := k.(string)
+			if !ok {
+				return fmt.Errorf("Failed to marshal dict key %v of type %T, only strings are supported", k, k)
+			}
+			m[s] = item
+		}
+		return marshalValue(buf, m)
+	default:
+		return fmt.Errorf("Failed to marshal value of type %T", v)
+	}
+	return nil
+}
+
+// marshalLong writes an integer as 15 bit digits, least significant first
+func marshalLong(buf *bytes.Buffer, v *big.Int) {
+	abs := new(big.Int).Abs(v)
+	digits := []uint16{}
+	mask := big.NewInt(1<<15 - 1)
+	for abs.Sign() > 0 {
+		digits = append(digits, uint16(new(big.Int).And(abs, mask).Int64()))
+		abs.Rsh(abs, 15)
+	}
+	n := int32(len(digits))
+	if v.Sign() < 0 {
+		n = -n
+	}
+	buf.WriteByte(codeLong)
+	marshalInt32(buf, n)
+	for _, d := range digits {
+		binary.Write(buf, binary.LittleEndian, d)
+	}
+}
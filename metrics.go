@@ -0,0 +1,211 @@
+package p4
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Outcomes of commands reported to Metrics
+const (
+	OutcomeSuccess = "success" // ran without errors
+	OutcomeError   = "error"   // ran but reported an error
+	OutcomeFailure = "failure" // p4 couldn't be run
+)
+
+// Metrics receives a measurement of each command run by a P4 with
+// WithMetrics. It is small so it can be adapted to Prometheus, expvar or
+// other metrics libraries; CommandMetrics is a ready made implementation.
+type Metrics interface {
+	ObserveCommand(command string, outcome string, duration time.Duration, bytes int, records int)
+}
+
+// Outcome returns the outcome of a command for Metrics
+func (ev *CommandEvent) Outcome() string {
+	if ev.ExitCode < 0 {
+		return OutcomeFailure
+	}
+	if ev.Err != nil || ev.Errors > 0 {
+		return OutcomeError
+	}
+	return OutcomeSuccess
+}
+
+// metricsHooks returns Hooks which report every command to m
+func metricsHooks(m Metrics) Hooks {
+	return Hooks{
+		AfterRun: func(ev *CommandEvent) {
+			m.ObserveCommand(ev.Command, ev.Outcome(), ev.Duration, ev.Bytes, ev.Records)
+		},
+	}
+}
+
+// DefaultDurationBuckets are the upper bounds in seconds of the command
+// duration histogram buckets used by CommandMetrics
+var DefaultDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300}
+
+// CommandStats are the metrics for one command
+type CommandStats struct {
+	Outcomes map[string]int64 // count of each outcome
+	Count    int64            // total commands run
+	Seconds  float64          // total duration in seconds
+	Buckets  []int64          // cumulative count of commands no longer than each bucket
+	Bytes    int64            // total bytes read from stdout
+	Records  int64            // total records decoded
+}
+
+// CommandMetrics collects counts, durations, bytes and records by command
+// name. It can be published with expvar.Publish, as it implements
+// expvar.Var, or served to Prometheus as an http.Handler for /metrics.
+type CommandMetrics struct {
+	buckets []float64
+	mutex   sync.Mutex
+	stats   map[string]*CommandStats
+}
+
+// NewCommandMetrics returns an empty CommandMetrics using DefaultDurationBuckets
+func NewCommandMetrics() *CommandMetrics {
+	return &CommandMetrics{buckets: DefaultDurationBuckets, stats: map[string]*CommandStats{}}
+}
+
+// Buckets returns the upper bounds in seconds of the duration histogram buckets
+func (cm *CommandMetrics) Buckets() []float64 {
+	return cm.buckets
+}
+
+// ObserveCommand records a command, implementing Metrics
+func (cm *CommandMetrics) ObserveCommand(command string, outcome string, duration time.Duration, bytes int, records int) {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+	cs, ok := cm.stats[command]
+	if !ok {
+		cs = &CommandStats{Outcomes: map[string]int64{}, Buckets: make([]int64, len(cm.buckets))}
+		cm.stats[command] = cs
+	}
+	cs.Outcomes[outcome]++
+	cs.Count++
+	cs.Seconds += duration.Seconds()
+	for i, upper := range cm.buckets {
+		if duration.Seconds() <= upper {
+			cs.Buckets[i]++
+		}
+	}
+	cs.Bytes += int64(bytes)
+	cs.Records += int64(records)
+}
+
+// Snapshot returns a copy of the metrics for each command
+func (cm *CommandMetrics) Snapshot() map[string]CommandStats {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+	snap := make(map[string]CommandStats, len(cm.stats))
+	for command, cs := range cm.stats {
+		c := *cs
+		c.Outcomes = make(map[string]int64, len(cs.Outcomes))
+		for k, v := range cs.Outcomes {
+			c.Outcomes[k] = v
+		}
+		c.Buckets = append([]int64{}, cs.Buckets...)
+		snap[command] = c
+	}
+	return snap
+}
+
+// Quantile estimates the duration within which a fraction q of a command's
+// runs finished, e.g. 0.99, by interpolating within the histogram buckets
+func (cm *CommandMetrics) Quantile(command string, q float64) time.Duration {
+	cs, ok := cm.Snapshot()[command]
+	if !ok {
+		return 0
+	}
+	return quantile(cm.buckets, cs, q)
+}
+
+func quantile(buckets []float64, cs CommandStats, q float64) time.Duration {
+	if cs.Count == 0 {
+		return 0
+	}
+	rank := q * float64(cs.Count)
+	lower, below := 0.0, int64(0)
+	for i, upper := range buckets {
+		if float64(cs.Buckets[i]) >= rank {
+			inBucket := cs.Buckets[i] - below
+			if inBucket == 0 {
+				return time.Duration(upper * float64(time.Second))
+			}
+			f := lower + (upper-lower)*(rank-float64(below))/float64(inBucket)
+			return time.Duration(f * float64(time.Second))
+		}
+		lower, below = upper, cs.Buckets[i]
+	}
+	// Beyond the largest bucket
+	return time.Duration(buckets[len(buckets)-1] * float64(time.Second))
+}
+
+// String returns the metrics as JSON, implementing expvar.Var
+func (cm *CommandMetrics) String() string {
+	b, err := json.Marshal(cm.Snapshot())
+	if err != nil {
+		return "{}"
+	}
+	return string(b)
+}
+
+// WritePrometheus writes the metrics in the Prometheus text format
+func (cm *CommandMetrics) WritePrometheus(w io.Writer) error {
+	snap := cm.Snapshot()
+	commands := make([]string, 0, len(snap))
+	for command := range snap {
+		commands = append(commands, command)
+	}
+	sort.Strings(commands)
+
+	var b strings.Builder
+	b.WriteString("# HELP p4_commands_total p4 commands run by command and outcome.\n")
+	b.WriteString("# TYPE p4_commands_total counter\n")
+	for _, command := range commands {
+		outcomes := []string{}
+		for outcome := range snap[command].Outcomes {
+			outcomes = append(outcomes, outcome)
+		}
+		sort.Strings(outcomes)
+		for _, outcome := range outcomes {
+			fmt.Fprintf(&b, "p4_commands_total{command=%q,outcome=%q} %d\n", command, outcome, snap[command].Outcomes[outcome])
+		}
+	}
+	b.WriteString("# HELP p4_command_duration_seconds Duration of p4 commands.\n")
+	b.WriteString("# TYPE p4_command_duration_seconds histogram\n")
+	for _, command := range commands {
+		cs := snap[command]
+		for i, upper := range cm.buckets {
+			fmt.Fprintf(&b, "p4_command_duration_seconds_bucket{command=%q,le=%q} %d\n", command, strconv.FormatFloat(upper, 'g', -1, 64), cs.Buckets[i])
+		}
+		fmt.Fprintf(&b, "p4_command_duration_seconds_bucket{command=%q,le=\"+Inf\"} %d\n", command, cs.Count)
+		fmt.Fprintf(&b, "p4_command_duration_seconds_sum{command=%q} %g\n", command, cs.Seconds)
+		fmt.Fprintf(&b, "p4_command_duration_seconds_count{command=%q} %d\n", command, cs.Count)
+	}
+	b.WriteString("# HELP p4_command_stdout_bytes_total Bytes read from p4 commands.\n")
+	b.WriteString("# TYPE p4_command_stdout_bytes_total counter\n")
+	for _, command := range commands {
+		fmt.Fprintf(&b, "p4_command_stdout_bytes_total{command=%q} %d\n", command, snap[command].Bytes)
+	}
+	b.WriteString("# HELP p4_command_records_total Records decoded from p4 commands.\n")
+	b.WriteString("# TYPE p4_command_records_total counter\n")
+	for _, command := range commands {
+		fmt.Fprintf(&b, "p4_command_records_total{command=%q} %d\n", command, snap[command].Records)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// ServeHTTP serves the metrics in the Prometheus text format
+func (cm *CommandMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	cm.WritePrometheus(w)
+}
@@ -0,0 +1,85 @@
+package p4
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var _ expvar.Var = NewCommandMetrics()
+
+func TestCommandMetrics(t *testing.T) {
+	cm := NewCommandMetrics()
+	for i := 1; i <= 100; i++ {
+		cm.ObserveCommand("fstat", OutcomeSuccess, time.Duration(i)*time.Millisecond, 100, 2)
+	}
+	cm.ObserveCommand("describe", OutcomeSuccess, 20*time.Millisecond, 50, 1)
+	cm.ObserveCommand("describe", OutcomeError, 3*time.Millisecond, 10, 1)
+
+	snap := cm.Snapshot()
+	fstat := snap["fstat"]
+	assert.Equal(t, int64(100), fstat.Count)
+	assert.Equal(t, map[string]int64{OutcomeSuccess: 100}, fstat.Outcomes)
+	assert.Equal(t, int64(10000), fstat.Bytes)
+	assert.Equal(t, int64(200), fstat.Records)
+	assert.InDelta(t, 5.05, fstat.Seconds, 0.0001)
+	assert.Equal(t, []int64{5, 10, 25, 50, 100, 100, 100, 100, 100, 100, 100, 100, 100, 100}, fstat.Buckets)
+	assert.Equal(t, 99*time.Millisecond, cm.Quantile("fstat", 0.99).Round(time.Millisecond))
+	assert.Equal(t, 50*time.Millisecond, cm.Quantile("fstat", 0.5).Round(time.Millisecond))
+	assert.Equal(t, time.Duration(0), cm.Quantile("sync", 0.5))
+	assert.Equal(t, map[string]int64{OutcomeSuccess: 1, OutcomeError: 1}, snap["describe"].Outcomes)
+
+	// Snapshots are copies
+	snap["describe"].Outcomes[OutcomeError] = 10
+	assert.Equal(t, int64(1), cm.Snapshot()["describe"].Outcomes[OutcomeError])
+
+	var decoded map[string]CommandStats
+	assert.Nil(t, json.Unmarshal([]byte(cm.String()), &decoded))
+	assert.Equal(t, cm.Snapshot(), decoded)
+
+	rec := httptest.NewRecorder()
+	cm.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	assert.Contains(t, body, "p4_commands_total{command=\"describe\",outcome=\"error\"} 1\n")
+	assert.Contains(t, body, "p4_commands_total{command=\"fstat\",outcome=\"success\"} 100\n")
+	assert.Contains(t, body, "p4_command_duration_seconds_bucket{command=\"fstat\",le=\"0.025\"} 25\n")
+	assert.Contains(t, body, "p4_command_duration_seconds_bucket{command=\"fstat\",le=\"+Inf\"} 100\n")
+	assert.Contains(t, body, "p4_command_duration_seconds_count{command=\"describe\"} 2\n")
+	assert.Contains(t, body, "p4_command_stdout_bytes_total{command=\"fstat\"} 10000\n")
+	assert.Contains(t, body, "p4_command_records_total{command=\"describe\"} 2\n")
+	assert.True(t, strings.Index(body, "command=\"describe\"") < strings.Index(body, "command=\"fstat\""))
+}
+
+func TestWithMetrics(t *testing.T) {
+	changes, err := filepath.Abs(filepath.Join(testRoot, "..", "testdata", "changes.bin"))
+	assert.Nil(t, err)
+	exe := fakeP4Executable(t, fmt.Sprintf("case \"$*\" in\n*changes*) cat %s ;;\n*) echo failed >&2; exit 1 ;;\nesac\n", changes))
+	cm := NewCommandMetrics()
+	p4 := NewP4(WithExecutable(exe), WithMetrics(cm))
+	_, err = p4.Run([]string{"changes"})
+	assert.Nil(t, err)
+	_, err = p4.Run([]string{"describe", "1"})
+	assert.NotNil(t, err)
+	_, err = NewP4(WithExecutable(filepath.Join(t.TempDir(), "missing")), WithMetrics(cm)).Run([]string{"describe", "1"})
+	assert.NotNil(t, err)
+
+	snap := cm.Snapshot()
+	assert.Equal(t, map[string]int64{OutcomeSuccess: 1}, snap["changes"].Outcomes)
+	assert.Equal(t, int64(3), snap["changes"].Records)
+	assert.True(t, snap["changes"].Bytes > 0)
+	assert.Equal(t, map[string]int64{OutcomeError: 1, OutcomeFailure: 1}, snap["describe"].Outcomes)
+}
+
+func TestOutcome(t *testing.T) {
+	assert.Equal(t, OutcomeSuccess, (&CommandEvent{Records: 2}).Outcome())
+	assert.Equal(t, OutcomeError, (&CommandEvent{Records: 1, Errors: 1}).Outcome())
+	assert.Equal(t, OutcomeError, (&CommandEvent{ExitCode: 1, Err: fmt.Errorf("failed")}).Outcome())
+	assert.Equal(t, OutcomeFailure, (&CommandEvent{ExitCode: -1, Err: fmt.Errorf("not found")}).Outcome())
+}
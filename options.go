@@ -0,0 +1,127 @@
+package p4
+
+// Option sets global options on a P4, which are applied to every command
+// it runs: Run, RunBytes, Save, SaveTxt and Fetch
+type Option func(*P4)
+
+// WithCharset sets the character set used to talk to a unicode server (p4 -C)
+func WithCharset(charset string) Option {
+	return func(p4 *P4) {
+		p4.charset = charset
+	}
+}
+
+// WithCommandCharset sets the character set used for command line arguments (p4 -Q)
+func WithCommandCharset(charset string) Option {
+	return func(p4 *P4) {
+		p4.commandCharset = charset
+	}
+}
+
+// WithHost overrides the client's host name (p4 -H)
+func WithHost(host string) Option {
+	return func(p4 *P4) {
+		p4.host = host
+	}
+}
+
+// WithPassword sets the password or ticket to use (p4 -P)
+func WithPassword(password string) Option {
+	return func(p4 *P4) {
+		p4.password = password
+	}
+}
+
+// WithDir sets the working directory commands are run in (p4 -d).
+// Relative file arguments and P4CONFIG files are found from here.
+func WithDir(dir string) Option {
+	return func(p4 *P4) {
+		p4.dir = dir
+	}
+}
+
+// WithProg sets the program name shown by p4 monitor and in the server log (p4 -z prog=)
+func WithProg(prog string) Option {
+	return func(p4 *P4) {
+		p4.prog = prog
+	}
+}
+
+// WithVersion sets the program version shown in the server log (p4 -z version=)
+func WithVersion(version string) Option {
+	return func(p4 *P4) {
+		p4.version = version
+	}
+}
+
+// WithRetries sets how many times p4 retries a command if the connection fails (p4 -r)
+func WithRetries(retries int) Option {
+	return func(p4 *P4) {
+		p4.retries = retries
+	}
+}
+
+// WithDebug adds debug flags such as "net=3" (p4 -v)
+func WithDebug(flags ...string) Option {
+	return func(p4 *P4) {
+		p4.debug = append(p4.debug, flags...)
+	}
+}
+
+// WithEnv adds NAME=value environment variables for the p4 process, which
+// override those of the current process
+func WithEnv(vars ...string) Option {
+	return func(p4 *P4) {
+		p4.env = append(p4.env, vars...)
+	}
+}
+
+// WithExecutable sets the p4 binary to run, instead of p4 from the PATH
+func WithExecutable(path string) Option {
+	return func(p4 *P4) {
+		p4.executable = path
+	}
+}
+
+// WithMaxArgSize sets the total size in bytes of file arguments above which
+// they are batched, rather than all passed on the command line. A negative
+// size turns batching off.
+func WithMaxArgSize(size int) Option {
+	return func(p4 *P4) {
+		p4.argSizeLimit = size
+	}
+}
+
+// WithBatchMode sets how commands with too many file arguments are run
+func WithBatchMode(mode BatchMode) Option {
+	return func(p4 *P4) {
+		p4.batchMode = mode
+	}
+}
+
+// WithLogger sets the Logger used to log commands, which are otherwise not logged
+func WithLogger(log Logger) Option {
+	return func(p4 *P4) {
+		p4.log = log
+	}
+}
+
+// WithRedaction sets whether passwords and tickets are hidden in logs and
+// hook events, which they are by default
+func WithRedaction(redact bool) Option {
+	return func(p4 *P4) {
+		p4.noRedact = !redact
+	}
+}
+
+// WithHooks adds hooks which are called before and after every command
+func WithHooks(hooks Hooks) Option {
+	return func(p4 *P4) {
+		p4.hooks = append(p4.hooks, hooks)
+	}
+}
+
+// WithMetrics reports every command to m, e.g. a CommandMetrics
+func WithMetrics(m Metrics) Option {
+	return WithHooks(metricsHooks(m))
+}
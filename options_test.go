@@ -0,0 +1,63 @@
+package p4
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOptions(t *testing.T) {
+	p4 := NewP4Params("ssl:perforce:1666", "a.person", "a_ws",
+		WithCharset("utf8"),
+		WithCommandCharset("utf8"),
+		WithHost("buildhost"),
+		WithPassword("ABCDEF"),
+		WithDir("/work/a_ws"),
+		WithProg("p4bot"),
+		WithVersion("1.2.3"),
+		WithRetries(3),
+		WithDebug("net=3", "rpc=1"),
+		WithEnv("P4CONFIG=.p4config"))
+	want := []string{"-p", "ssl:perforce:1666", "-u", "a.person", "-c", "a_ws",
+		"-C", "utf8", "-Q", "utf8", "-H", "buildhost", "-P", "ABCDEF", "-d", "/work/a_ws",
+		"-z", "prog=p4bot", "-z", "version=1.2.3", "-r", "3", "-v", "net=3", "-v", "rpc=1"}
+	assert.Equal(t, want, p4.getOptionsNonMarshal())
+	assert.Equal(t, append([]string{"-G"}, want...), p4.getOptions())
+
+	cmd := p4.command([]string{"info"})
+	assert.Equal(t, "/work/a_ws", cmd.Dir)
+	assert.Equal(t, "P4CONFIG=.p4config", cmd.Env[len(cmd.Env)-1])
+
+	// Relative directories are passed to -d as absolute paths
+	wd, err := os.Getwd()
+	assert.Nil(t, err)
+	p4 = NewP4(WithDir(filepath.Join("work", "a_ws")))
+	assert.Equal(t, []string{"-d", filepath.Join(wd, "work", "a_ws")}, p4.getOptionsNonMarshal())
+	assert.Equal(t, filepath.Join("work", "a_ws"), p4.command([]string{"info"}).Dir)
+
+	assert.Equal(t, []string{"-G"}, NewP4().getOptions())
+	cmd = NewP4().command([]string{"info"})
+	assert.Equal(t, "", cmd.Dir)
+	assert.Nil(t, cmd.Env)
+}
+
+func TestEffectiveOptions(t *testing.T) {
+	root := t.TempDir()
+	assert.Nil(t, writeToFile(filepath.Join(root, "p4config.txt"), "P4PORT=config:1666\nP4CLIENT=config_ws\n"))
+	t.Setenv("P4CONFIG", "")
+	t.Setenv("P4HOST", "")
+	p4 := NewP4Params("", "a.person", "",
+		WithDir(root),
+		WithCharset("utf8"),
+		WithEnv("P4CONFIG=p4config.txt", "P4ENVIRO="+filepath.Join(root, "missing")))
+	e, err := p4.Effective()
+	assert.Nil(t, err)
+	assert.Equal(t, filepath.Join(root, "p4config.txt"), e.ConfigFile)
+	assert.Equal(t, "config:1666", e.Port.Value)
+	assert.Equal(t, "config_ws", e.Client.Value)
+	assert.Equal(t, Setting{Name: "P4CHARSET", Value: "utf8", Source: SourceParam}, e.Charset)
+	assert.Equal(t, SourceDefault, e.Host.Source)
+	assert.Equal(t, "", os.Getenv("P4CONFIG"))
+}
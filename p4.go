@@ -1,7 +1,7 @@
 /*
 Package p4 wraps the Perforce Helix Core command line.
 
-It assumes p4 or p4.exe is in the PATH.
+It assumes p4 or p4.exe is in the PATH, unless another binary is given with WithExecutable.
 It uses the p4 -G global option which returns Python marshalled dictionary objects.
 
 p4 Python parsing module is based on: https://github.com/hambster/gopymarshal
@@ -12,226 +12,102 @@ import (
 	"bytes"
 	"fmt"
 	"io"
-	"log"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 
-	"encoding/binary"
 	"errors"
-	"math"
 )
 
-// Parsing constants
-const (
-	codeNone     = 'N' //None
-	codeInt      = 'i' //integer
-	codeInt2     = 'c' //integer2
-	codeFloat    = 'g' //float
-	codeString   = 's' //string
-	codeUnicode  = 'u' //unicode string
-	codeTString  = 't' //tstring?
-	codeTuple    = '(' //tuple
-	codeList     = '[' //list
-	codeDict     = '{' //dict
-	codeStop     = '0'
-	codeEnd      = 0 //end of the object
-	dictInitSize = 64
-)
-
-// Parse error
-var (
-	ErrParse       = errors.New("invalid data")
-	ErrUnknownCode = errors.New("unknown code")
-)
-
-// Unmarshal data serialized by python
-func Unmarshal(buffer *bytes.Buffer) (ret interface{}, retErr error) {
-	ret, _, retErr = Unmarshal2(buffer)
-	return
-}
-
-// Unmarshal2 data serialized by python, returning the unused portion.
-func Unmarshal2(buffer *bytes.Buffer) (ret interface{}, remainder []byte, retErr error) {
-	code, err := buffer.ReadByte()
-	if nil != err {
-		retErr = err
-	}
-	ret, retErr = unmarshal(code, buffer)
-	remainder = buffer.Bytes()
-	return
-}
-
-func unmarshal(code byte, buffer *bytes.Buffer) (ret interface{}, retErr error) {
-	switch code {
-	case codeNone:
-		ret = nil
-	case codeInt:
-		fallthrough
-	case codeInt2:
-		ret, retErr = readInt32(buffer)
-	case codeFloat:
-		ret, retErr = readFloat64(buffer)
-	case codeString:
-		fallthrough
-	case codeUnicode:
-		fallthrough
-	case codeTString:
-		ret, retErr = readString(buffer)
-	case codeTuple:
-		fallthrough
-	case codeList:
-		ret, retErr = readList(buffer)
-	case codeDict:
-		ret, retErr = readDict(buffer)
-	case codeEnd:
-		ret, retErr = nil, nil
-	default:
-		retErr = ErrUnknownCode
-	}
-
-	return
-}
-
-func readInt32(buffer *bytes.Buffer) (ret int32, retErr error) {
-	var tmp int32
-	retErr = ErrParse
-	if retErr = binary.Read(buffer, binary.LittleEndian, &tmp); nil == retErr {
-		ret = tmp
-	}
-
-	return
-}
-
-func readFloat64(buffer *bytes.Buffer) (ret float64, retErr error) {
-	retErr = ErrParse
-	tmp := make([]byte, 8)
-	if num, err := buffer.Read(tmp); nil == err && 8 == num {
-		bits := binary.LittleEndian.Uint64(tmp)
-		ret = math.Float64frombits(bits)
-		retErr = nil
-	}
-
-	return
-}
-
-func readString(buffer *bytes.Buffer) (ret string, retErr error) {
-	var strLen int32
-	strLen = 0
-	retErr = ErrParse
-	if err := binary.Read(buffer, binary.LittleEndian, &strLen); nil != err {
-		retErr = err
-		return
-	}
-
-	retErr = nil
-	buf := make([]byte, strLen)
-	buffer.Read(buf)
-	ret = string(buf)
-	return
-}
-
-func readList(buffer *bytes.Buffer) (ret []interface{}, retErr error) {
-	var listSize int32
-	if retErr = binary.Read(buffer, binary.LittleEndian, &listSize); nil != retErr {
-		return
-	}
-
-	var code byte
-	var err error
-	var val interface{}
-	ret = make([]interface{}, int(listSize))
-	for idx := 0; idx < int(listSize); idx++ {
-		code, err = buffer.ReadByte()
-		if nil != err {
-			break
-		}
-
-		val, err = unmarshal(code, buffer)
-		if nil != err {
-			retErr = err
-			break
-		}
-		ret = append(ret, val)
-	} //end of read loop
-
-	return
-}
-
-func readDict(buffer *bytes.Buffer) (ret map[interface{}]interface{}, retErr error) {
-	var code byte
-	var err error
-	var key interface{}
-	var val interface{}
-	ret = make(map[interface{}]interface{})
-	for {
-		code, err = buffer.ReadByte()
-		if nil != err {
-			break
-		}
-
-		if code == codeStop {
-			break
-		}
-
-		key, err = unmarshal(code, buffer)
-		if nil != err {
-			retErr = err
-			break
-		}
-
-		code, err = buffer.ReadByte()
-		if nil != err {
-			break
-		}
-
-		val, err = unmarshal(code, buffer)
-		if nil != err {
-			retErr = err
-			break
-		}
-		ret[key] = val
-	} //end of read loop
-
-	return
-}
-
 // P4 - environment for P4
 type P4 struct {
-	port   string
-	user   string
-	client string
+	port           string
+	user           string
+	client         string
+	charset        string
+	commandCharset string
+	host           string
+	password       string
+	dir            string
+	prog           string
+	version        string
+	retries        int
+	debug          []string
+	env            []string
+	executable     string
+	argSizeLimit   int
+	batchMode      BatchMode
+	passwordFunc   func(user string) (string, error)
+	log            Logger
+	noRedact       bool
+	hooks          []Hooks
+
+	clientVersion cachedLookup[Version]
+	serverInfo    cachedLookup[ServerInfo]
 }
 
 // NewP4 - create and initialise properly
-func NewP4() *P4 {
+func NewP4(opts ...Option) *P4 {
 	var p4 P4
+	for _, opt := range opts {
+		opt(&p4)
+	}
 	return &p4
 }
 
 // NewP4Params - create and initialise with params
-func NewP4Params(port string, user string, client string) *P4 {
-	var p4 P4
+func NewP4Params(port string, user string, client string, opts ...Option) *P4 {
+	p4 := NewP4(opts...)
 	p4.port = port
 	p4.user = user
 	p4.client = client
-	return &p4
+	return p4
 }
 
 // RunBytes - runs p4 command and returns []byte output
 func (p4 *P4) RunBytes(args []string) ([]byte, error) {
-	cmd := exec.Command("p4", args...)
+	args = append(p4.getOptionsNonMarshal(), args...)
+	cmd := p4.command(args)
 
+	ev := p4.startEvent(args)
 	data, err := cmd.CombinedOutput()
+	p4.finishEvent(ev, cmd, len(data), nil, err)
 	if err != nil {
 		return data, err
 	}
 	return data, nil
 }
 
+// Executable returns the p4 binary commands are run with
+func (p4 *P4) Executable() string {
+	if p4.executable == "" {
+		return "p4"
+	}
+	return p4.executable
+}
+
+// command returns a p4 command with args, in the working directory and
+// environment set for this P4
+func (p4 *P4) command(args []string) *exec.Cmd {
+	cmd := exec.Command(p4.Executable(), args...)
+	cmd.Dir = p4.dir
+	if len(p4.env) > 0 {
+		cmd.Env = append(os.Environ(), p4.env...)
+	}
+	return cmd
+}
+
 // Get options that go before the p4 command
 func (p4 *P4) getOptions() []string {
-	opts := []string{"-G"}
+	return append([]string{"-G"}, p4.getOptionsNonMarshal()...)
+}
+
+// Get options that go before the p4 command
+func (p4 *P4) getOptionsNonMarshal() []string {
+	opts := []string{}
 
 	if p4.port != "" {
 		opts = append(opts, "-p", p4.port)
@@ -242,23 +118,69 @@ func (p4 *P4) getOptions() []string {
 	if p4.client != "" {
 		opts = append(opts, "-c", p4.client)
 	}
+	if p4.charset != "" {
+		opts = append(opts, "-C", p4.charset)
+	}
+	if p4.commandCharset != "" {
+		opts = append(opts, "-Q", p4.commandCharset)
+	}
+	if p4.host != "" {
+		opts = append(opts, "-H", p4.host)
+	}
+	if p4.password != "" {
+		opts = append(opts, "-P", p4.password)
+	}
+	if p4.dir != "" {
+		// p4 is started in dir, so a relative -d would be resolved twice
+		dir := p4.dir
+		if abs, err := filepath.Abs(dir); err == nil {
+			dir = abs
+		}
+		opts = append(opts, "-d", dir)
+	}
+	if p4.prog != "" {
+		opts = append(opts, "-z", "prog="+p4.prog)
+	}
+	if p4.version != "" {
+		opts = append(opts, "-z", "version="+p4.version)
+	}
+	if p4.retries > 0 {
+		opts = append(opts, "-r", strconv.Itoa(p4.retries))
+	}
+	for _, d := range p4.debug {
+		opts = append(opts, "-v", d)
+	}
 	return opts
 }
 
-// Get options that go before the p4 command
-func (p4 *P4) getOptionsNonMarshal() []string {
-	opts := []string{}
+// Global options which are followed by a value
+var globalValueFlags = map[string]bool{
+	"-c": true, "-C": true, "-d": true, "-H": true, "-L": true, "-p": true, "-P": true,
+	"-Q": true, "-r": true, "-u": true, "-v": true, "-x": true, "-z": true,
+}
 
-	if p4.port != "" {
-		opts = append(opts, "-p", p4.port)
+// commandName returns the p4 command in args, skipping any global options.
+// Without a command it is version for p4 -V, or else the first argument.
+func commandName(args []string) string {
+	version := false
+	for i := 0; i < len(args); i++ {
+		if !strings.HasPrefix(args[i], "-") {
+			return args[i]
+		}
+		if args[i] == "-V" {
+			version = true
+		}
+		if globalValueFlags[args[i]] {
+			i++
+		}
 	}
-	if p4.user != "" {
-		opts = append(opts, "-u", p4.user)
+	if version {
+		return "version"
 	}
-	if p4.client != "" {
-		opts = append(opts, "-c", p4.client)
+	if len(args) > 0 {
+		return args[0]
 	}
-	return opts
+	return ""
 }
 
 // Runner is an interface to make testing p4 commands more easily
@@ -266,40 +188,96 @@ type Runner interface {
 	Run([]string) ([]map[interface{}]interface{}, error)
 }
 
-// Run - runs p4 command and returns map
+// SpecRunner is a Runner which can also write specs with p4 <spec> -i
+type SpecRunner interface {
+	Runner
+	SaveTxt(specName string, specContents map[string]string, args ...string) (string, error)
+}
+
+// Run - runs p4 command and returns map.
+// If the command fails because the user is not logged in and a password
+// function has been set, it logs in and runs the command once more.
 func (p4 *P4) Run(args []string) ([]map[interface{}]interface{}, error) {
-	opts := p4.getOptions()
+	results, _, err := p4.RunMarshalled(args)
+	return results, err
+}
+
+// RunMarshalled - runs p4 command as Run does, and also returns the raw -G
+// output the results were decoded from, e.g. to save as test data
+func (p4 *P4) RunMarshalled(args []string) ([]map[interface{}]interface{}, []byte, error) {
+	results, data, err := p4.run(args)
+	if retry, lerr := p4.loginAgain(results, err); lerr != nil {
+		return results, data, lerr
+	} else if retry {
+		results, data, err = p4.run(args)
+	}
+	return results, data, err
+}
+
+// run runs a p4 command, batching the file arguments if there are too many
+func (p4 *P4) run(args []string) ([]map[interface{}]interface{}, []byte, error) {
+	cmdArgs, files := splitFileArgs(args)
+	if argsSize(files) <= p4.maxArgSize() {
+		return p4.runMarshal(nil, args, nil)
+	}
+	if p4.batchMode == BatchSplit {
+		return p4.runSplit(cmdArgs, files)
+	}
+	input := strings.Join(files, "\n") + "\n"
+	return p4.runMarshal([]string{"-x", "-"}, cmdArgs, strings.NewReader(input))
+}
+
+// runMarshal runs p4 -G with any extra global options and input on stdin,
+// and returns the decoded results along with the raw output
+func (p4 *P4) runMarshal(globalOpts []string, args []string, stdin io.Reader) ([]map[interface{}]interface{}, []byte, error) {
+	opts := append(p4.getOptions(), globalOpts...)
 	args = append(opts, args...)
-	cmd := exec.Command("p4", args...)
+	cmd := p4.command(args)
 	var stdout, stderr bytes.Buffer
+	cmd.Stdin = stdin
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
+	ev := p4.startEvent(args)
 	mainerr := cmd.Run()
+	data := stdout.Bytes()
 	// May not be the correct place to do this
 	// But we are ignoring the actual error otherwise
 	if stderr.Len() > 0 {
-		return nil, errors.New(stderr.String())
+		err := errors.New(stderr.String())
+		p4.finishEvent(ev, cmd, len(data), nil, err)
+		return nil, data, err
 	}
+	results, err := DecodeResults(data)
+	if mainerr == nil {
+		mainerr = err
+	}
+	p4.finishEvent(ev, cmd, len(data), results, mainerr)
+	return results, data, mainerr
+}
+
+// DecodeResults decodes the results of a command from its raw -G output,
+// as returned by RunMarshalled. Results decoded before any error are returned.
+func DecodeResults(data []byte) ([]map[interface{}]interface{}, error) {
+	d := NewDecoder(data)
 	results := make([]map[interface{}]interface{}, 0)
 	for {
-		r, err := Unmarshal(&stdout)
+		r, err := d.Decode()
 		if err == io.EOF {
-			break
+			return results, nil
 		}
-		if err == nil {
-			if r == nil {
-				// End of object
-				break
-			}
-			results = append(results, r.(map[interface{}]interface{}))
-		} else {
-			if mainerr == nil {
-				mainerr = err
-			}
-			break
+		if err != nil {
+			return results, err
+		}
+		if r == nil {
+			// End of object
+			return results, nil
 		}
+		m, ok := r.(map[interface{}]interface{})
+		if !ok {
+			return results, fmt.Errorf("Failed to decode p4 output, expected dict but got %T", r)
+		}
+		results = append(results, m)
 	}
-	return results, mainerr
 }
 
 // parseError turns perforce error messages into go error's
@@ -313,7 +291,7 @@ func parseError(res map[interface{}]interface{}) error {
 		e = fmt.Sprintf("Failed to parse error %v", err)
 		return errors.New(e)
 	}
-	// Search for non-existent depot error
+	// Search for non-existent depot error, see ExplainPathError for more detail
 	nodepot, err := regexp.Match(`must refer to client`, []byte(e))
 	if err != nil {
 		return err // Do we need to return (error, error) for real error and parsed one?
@@ -346,65 +324,92 @@ func formatSpec(specContents map[string]string) string {
 	return output.String()
 }
 
+// getString returns the value of key in a p4 result if it is a string
+func getString(r map[interface{}]interface{}, key string) string {
+	if v, ok := r[key]; ok {
+		if s, ok := v.(string); ok {
+			return s
+		}
+	}
+	return ""
+}
+
+// getIndexed returns the values of numbered fields such as View0, View1...
+// as found in p4 -G spec output
+func getIndexed(r map[interface{}]interface{}, prefix string) []string {
+	vals := []string{}
+	for i := 0; ; i++ {
+		v, ok := r[prefix+strconv.Itoa(i)]
+		if !ok {
+			break
+		}
+		s, _ := v.(string)
+		vals = append(vals, s)
+	}
+	return vals
+}
+
 // Save - runs p4 -i for specified spec returns result
 func (p4 *P4) Save(specName string, specContents map[string]string, args ...string) ([]map[interface{}]interface{}, error) {
+	results, err := p4.save(specName, specContents, args)
+	if retry, lerr := p4.loginAgain(results, err); lerr != nil {
+		return results, lerr
+	} else if retry {
+		results, err = p4.save(specName, specContents, args)
+	}
+	return results, err
+}
+
+// save runs p4 <specName> -G -i args... once
+func (p4 *P4) save(specName string, specContents map[string]string, args []string) ([]map[interface{}]interface{}, error) {
 	opts := p4.getOptions()
 	nargs := []string{specName, "-i"}
 	nargs = append(nargs, args...)
 	args = append(opts, nargs...)
 
-	log.Println(args)
-	cmd := exec.Command("p4", args...)
+	cmd := p4.command(args)
 	var stdout, stderr bytes.Buffer
 	stdin, err := cmd.StdinPipe()
 	if err != nil {
-		fmt.Println("An error occured: ", err)
+		p4.logger().Errorf("An error occured: %v", err)
 	}
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
+	ev := p4.startEvent(args)
 	mainerr := cmd.Start()
 	if mainerr != nil {
-		fmt.Println("An error occured: ", mainerr)
+		p4.logger().Errorf("An error occured: %v", mainerr)
 	}
 	spec := formatSpec(specContents)
-	log.Println(spec)
+	p4.logger().Debugf("%s spec:\n%s", specName, formatSpec(p4.redactSpec(specContents)))
 	io.WriteString(stdin, spec)
 	stdin.Close()
 	cmd.Wait()
+	size := stdout.Len()
 
-	results := make([]map[interface{}]interface{}, 0)
-	for {
-		r, err := Unmarshal(&stdout)
-		if err == io.EOF || r == nil {
-			break
-		}
-		if err == nil {
-			results = append(results, r.(map[interface{}]interface{}))
-			fmt.Println(r)
-		} else {
-			if mainerr == nil {
-				mainerr = err
-			}
-			break
-		}
+	results, err := DecodeResults(stdout.Bytes())
+	for _, r := range results {
+		p4.logger().Debugf("%v", r)
 	}
+	if mainerr == nil {
+		mainerr = err
+	}
+	p4.finishEvent(ev, cmd, size, results, mainerr)
 	return results, mainerr
 }
 
 // Fetch - runs p4 <cmd> -o for specified spec and returns result
 func (p4 *P4) Fetch(specName string, args ...string) (map[string]string, error) {
-	opts := p4.getOptions()
 	nargs := []string{specName, "-o"}
 	nargs = append(nargs, args...)
-	args = append(opts, nargs...)
 
-	cresult, err := p4.Run(args)
+	cresult, err := p4.Run(nargs)
 	result := make(map[string]string, 0)
 	if len(cresult) == 0 {
 		return result, err
 	}
 	for i, v := range cresult[0] {
-		log.Printf("%v: %v", i, v)
+		p4.logger().Debugf("%v: %v", i, v)
 		// result[k.(string)] = v.(string)
 	}
 	return result, err
@@ -415,38 +420,52 @@ func (p4 *P4) Fetch(specName string, args ...string) (map[string]string, error)
 // This is a quick fix, the real fix is writing a marshal() function or try
 // using gopymarshal
 func (p4 *P4) SaveTxt(specName string, specContents map[string]string, args ...string) (string, error) {
+	out, err := p4.saveTxt(specName, specContents, args)
+	if retry, lerr := p4.loginAgain(nil, err); lerr != nil {
+		return out, lerr
+	} else if retry {
+		out, err = p4.saveTxt(specName, specContents, args)
+	}
+	return out, err
+}
+
+// saveTxt runs p4 <specName> -i args... once
+func (p4 *P4) saveTxt(specName string, specContents map[string]string, args []string) (string, error) {
 	opts := p4.getOptionsNonMarshal()
 	nargs := []string{specName, "-i"}
 	nargs = append(nargs, args...)
 	args = append(opts, nargs...)
 
-	log.Println(args)
-	cmd := exec.Command("p4", args...)
+	cmd := p4.command(args)
 	var stdout, stderr bytes.Buffer
 	stdin, err := cmd.StdinPipe()
 	if err != nil {
-		fmt.Println("An error occured: ", err)
+		p4.logger().Errorf("An error occured: %v", err)
 	}
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
+	ev := p4.startEvent(args)
 	mainerr := cmd.Start()
 	if mainerr != nil {
-		fmt.Println("An error occured: ", mainerr)
+		p4.logger().Errorf("An error occured: %v", mainerr)
 	}
 	spec := formatSpec(specContents)
-	log.Println(spec)
+	p4.logger().Debugf("%s spec:\n%s", specName, formatSpec(p4.redactSpec(specContents)))
 	io.WriteString(stdin, spec)
 	// Need to explicitly call this for the command to fire
 	stdin.Close()
 	cmd.Wait()
+	size := stdout.Len()
 
 	e, err := io.ReadAll(&stderr)
-	log.Println(e)
 	if len(e) > 0 {
-		return "", errors.New(string(e))
+		err = errors.New(string(e))
+		p4.finishEvent(ev, cmd, size, nil, err)
+		return "", err
 	}
 	x, err := io.ReadAll(&stdout)
 	s := string(x)
-	log.Println(s)
+	p4.logger().Debugf("%s", s)
+	p4.finishEvent(ev, cmd, size, nil, mainerr)
 	return s, mainerr
 }
@@ -6,9 +6,7 @@ import (
 	"flag"
 	"fmt"
 	"io"
-	"log"
 	"os"
-	"os/exec"
 	"path"
 	"path/filepath"
 	"regexp"
@@ -41,44 +39,6 @@ func createLogger() *logrus.Logger {
 	return logger
 }
 
-type P4Test struct {
-	startDir   string
-	p4d        string
-	port       string
-	testRoot   string
-	serverRoot string
-	clientRoot string
-}
-
-func MakeP4Test(startDir string) *P4Test {
-	var err error
-	p4t := &P4Test{}
-	p4t.startDir = startDir
-	if err != nil {
-		panic(err)
-	}
-	p4t.testRoot = filepath.Join(p4t.startDir, "testroot")
-	p4t.serverRoot = filepath.Join(p4t.testRoot, "server")
-	p4t.clientRoot = filepath.Join(p4t.testRoot, "client")
-	p4t.ensureDirectories()
-	p4t.p4d = "p4d"
-	p4t.port = fmt.Sprintf("rsh:%s -r \"%s\" -L log -vserver=3 -i", p4t.p4d, p4t.serverRoot)
-	os.Chdir(p4t.clientRoot)
-	p4config := filepath.Join(p4t.startDir, os.Getenv("P4CONFIG"))
-	writeToFile(p4config, fmt.Sprintf("P4PORT=%s", p4t.port))
-	os.Chdir(p4t.serverRoot)
-	cmd := exec.Command("p4d", "-xu")
-	var out, stderr bytes.Buffer
-	cmd.Stdout = &out
-	cmd.Stderr = &stderr
-	err = cmd.Run()
-	if err != nil {
-		log.Fatalf("Error %v %q %q", err, out.String(), stderr.String())
-	}
-	// log.Debugf("Upgraded", out.String(), stderr.String())
-	return p4t
-}
-
 // writeToFile - write contents to file
 func writeToFile(fname, contents string) error {
 	f, err := os.Create(fname)
@@ -94,107 +54,89 @@ func writeToFile(fname, contents string) error {
 	return err
 }
 
-func (p4t *P4Test) ensureDirectories() {
-	for _, d := range []string{p4t.serverRoot, p4t.clientRoot} {
-		err := os.MkdirAll(d, 0777)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to create %s: %v", d, err)
-		}
-	}
-}
-
-// TestMain - required as a wrapper for 'go test' to set directory appropriatly so that
-// p4 will pick up .p4config etc.
-func TestMain(m *testing.M) {
-	logger := createLogger()
-	logger.Debugf("TestMain: %s", os.Environ())
-	dir, err := os.Getwd()
-	if err != nil {
-		logger.Debugf("Failed to Getwd: %v\n", err)
-	}
-	logger.Debugf("TM Cwd: %s\n", dir)
-	os.Setenv("PWD", dir)
-	code := m.Run()
-	os.Exit(code)
-}
-
-// Call at start of all tests - we recreate a blank DVCS repo
+// testRoot is set so testdata files can be found wherever the tests are run from
 func init() {
 	_, filename, _, _ := runtime.Caller(0)
 	testRoot = path.Join(path.Dir(filename), "_testdata")
-	p4t := MakeP4Test(testRoot)
-	logger := createLogger()
-	logger.Debugf("Created server: %s\n", p4t.testRoot)
 }
 
+// TestInfo runs p4 info against a fake p4 which gives a recorded result,
+// so it doesn't need p4d. p4test has the same test against a real server.
 func TestInfo(t *testing.T) {
-	logger := createLogger()
-	logger.Debugf("======== Test: %s", t.Name())
-	dir, err := os.Getwd()
-	if err != nil {
-		logger.Debugf("Failed to Getwd: %v\n", err)
-	}
-	logger.Debugf("Test Cwd: %s\n", dir)
-
-	cmd := exec.Command("p4", "info")
-	var out, stderr bytes.Buffer
-	cmd.Stdout = &out
-	cmd.Stderr = &stderr
-	err = cmd.Run()
-	if err != nil {
-		log.Fatalf("Error %v %q %q", err, out.String(), stderr.String())
-	}
-	logger.Debugf("P4 info: %s\nerr: %s", out.String(), stderr.String())
-
-	p4 := NewP4()
+	info, err := filepath.Abs(filepath.Join(testRoot, "..", "testdata", "info.bin"))
+	assert.Nil(t, err)
+	p4 := NewP4(WithExecutable(fakeP4Executable(t, fmt.Sprintf("cat %s\n", info))))
 	result, err := p4.Run([]string{"info"})
 	assert.Equal(t, nil, err)
 	assert.Equal(t, 1, len(result))
-	logger.Debugf("P4 info result: %v\n", result[0])
 	assertMapContains(t, result[0], "serverAddress", "unknown")
-	assertMapContains(t, result[0], "clientName", "*unknown*")
+	assertMapContains(t, result[0], "clientName", "rcowham-dvcs-1557689468")
 	for _, k := range []string{"caseHandling", "clientName", "serverRoot", "serverUptime"} {
 		assertMapKey(t, result[0], k)
 	}
 }
 
-func check(t *testing.T, e error) {
-	if e != nil {
-		t.Error(e)
-	}
+// writeRecords writes records to fname as p4 -G would
+func writeRecords(t *testing.T, fname string, records ...Record) string {
+	var buf bytes.Buffer
+	assert.Nil(t, EncodeRecords(&buf, records))
+	assert.Nil(t, os.WriteFile(fname, buf.Bytes(), 0644))
+	return fname
 }
 
+// TestAdd saves a client and adds a file using a fake p4 which gives
+// recorded results for each command
 func TestAdd(t *testing.T) {
-	logger := createLogger()
-	logger.Debugf("======== Test: %s", t.Name())
-	file1 := "file1"
-
-	err := os.WriteFile(file1, []byte("Some text"), 0644)
-	check(t, err)
-
-	p4 := NewP4()
+	dir := t.TempDir()
+	client := writeRecords(t, filepath.Join(dir, "client.bin"), Record{"code": "stat",
+		"Client": "test_ws", "Root": dir, "View0": "//depot/... //test_ws/..."})
+	add := writeRecords(t, filepath.Join(dir, "add.bin"), Record{"code": "stat",
+		"depotFile": "//stream/main/file1", "clientFile": dir + "/file1", "workRev": "1", "action": "add"})
+	opened := writeRecords(t, filepath.Join(dir, "opened.bin"), Record{"code": "stat",
+		"depotFile": "//stream/main/file1", "clientFile": dir + "/file1", "change": "default", "action": "add"})
+	spec := filepath.Join(dir, "spec")
+	exe := fakeP4Executable(t, fmt.Sprintf(`case "$*" in
+*"client -o"*) cat %s ;;
+*"client -i"*) cat > %s; echo "Client test_ws saved." ;;
+*" add "*) cat %s ;;
+*" opened "*) cat %s ;;
+esac
+`, client, spec, add, opened))
+
+	p4 := NewP4(WithExecutable(exe))
 	p4.client = "test_ws"
-	client, err := p4.Fetch("client")
+	_, err := p4.Fetch("client")
 	assert.Equal(t, nil, err)
-	logger.Debugf("client: %+v", client)
-	client["View"] = "//depot/... //test_ws/..."
-	sresult, err := p4.SaveTxt("client", client)
-	logger.Debugf("save: %v, %v", sresult, err)
+	msg, err := p4.SaveTxt("client", map[string]string{"Client": "test_ws", "Root": dir, "View": "//depot/... //test_ws/..."})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "Client test_ws saved.\n", msg)
+	saved, err := os.ReadFile(spec)
+	assert.Nil(t, err)
+	assert.Contains(t, string(saved), "//depot/... //test_ws/...")
 
-	result, err := p4.Run([]string{"add", file1})
-	logger.Debugf("add err: %v", err)
+	result, err := p4.Run([]string{"add", "file1"})
+	assert.Equal(t, nil, err)
 	assert.Equal(t, 1, len(result))
-	logger.Debugf("P4 add result: %v\n", result[0])
 	assertMapContains(t, result[0], "depotFile", "//stream/main/file1")
 
-	result, err = p4.Run([]string{"opened", file1})
-	logger.Debugf("opened err: %v", err)
+	result, err = p4.Run([]string{"opened", "file1"})
+	assert.Equal(t, nil, err)
 	assert.Equal(t, 1, len(result))
-	logger.Debugf("P4 opened result: %v\n", result[0])
 	assertMapContains(t, result[0], "depotFile", "//stream/main/file1")
-	assertMapContains(t, result[0], "clientFile", fmt.Sprintf("%s/%s", testRoot, file1))
+	assertMapContains(t, result[0], "clientFile", fmt.Sprintf("%s/%s", dir, "file1"))
 	assertMapContains(t, result[0], "change", "default")
+}
 
+func TestCommandName(t *testing.T) {
+	for want, args := range map[string][]string{
+		"info":    {"info"},
+		"fstat":   {"-G", "-p", "perforce:1666", "-x", "-", "fstat", "-Ol"},
+		"version": {"-V"},
+		"-h":      {"-h"},
+		"":        {},
+	} {
+		assert.Equal(t, want, commandName(args), args)
+	}
 }
 
 func runUnmarshall(t *testing.T, testFile string) ([]map[interface{}]interface{}, []error) {
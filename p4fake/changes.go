@@ -0,0 +1,289 @@
+package p4fake
+
+import (
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// changeFiles returns the files in a change: revisions if it is submitted,
+// otherwise open files, as depot file, action, type and revision
+func (s *Server) changeFiles(c *change) []*revision {
+	files := []*revision{}
+	if c.status == "submitted" {
+		for _, revs := range s.files {
+			for _, r := range revs {
+				if r.change == c.number {
+					files = append(files, r)
+				}
+			}
+		}
+	} else {
+		for _, of := range s.opened {
+			if of.change == c.number && of.client == c.client {
+				files = append(files, &revision{depotFile: of.depotFile, rev: of.rev, action: of.action, fileType: of.fileType})
+			}
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].depotFile < files[j].depotFile })
+	return files
+}
+
+// changePath returns the deepest directory containing all files in a change, e.g. //depot/main/...
+func changePath(files []*revision) string {
+	if len(files) == 0 {
+		return ""
+	}
+	dir := path.Dir(strings.TrimPrefix(files[0].depotFile, "/"))
+	for _, f := range files[1:] {
+		d := path.Dir(strings.TrimPrefix(f.depotFile, "/"))
+		for dir != "/" && dir != "." && d != dir && !strings.HasPrefix(d, dir+"/") {
+			dir = path.Dir(dir)
+		}
+	}
+	return "/" + dir + "/..."
+}
+
+// changeFixes returns the fixes for a change, sorted by job
+func (s *Server) changeFixes(n int) []*fix {
+	fixes := []*fix{}
+	for _, f := range s.fixes {
+		if f.change == n {
+			fixes = append(fixes, f)
+		}
+	}
+	sort.Slice(fixes, func(i, j int) bool { return fixes[i].job < fixes[j].job })
+	return fixes
+}
+
+// changeNumbers returns the numbers of all changes, newest first
+func (s *Server) changeNumbers() []int {
+	nums := make([]int, 0, len(s.changes))
+	for n := range s.changes {
+		nums = append(nums, n)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(nums)))
+	return nums
+}
+
+func (s *Server) runChanges(args []string) ([]map[interface{}]interface{}, error) {
+	opts, args := flags(args, "msuc")
+	specs, errRes := parseFileSpecs(args)
+	if errRes != nil {
+		return []map[interface{}]interface{}{errRes}, nil
+	}
+	_, long := opts["l"]
+	_, longer := opts["L"]
+	max, _ := strconv.Atoi(opts["m"])
+	results := []map[interface{}]interface{}{}
+	for _, n := range s.changeNumbers() {
+		c := s.changes[n]
+		if (opts["s"] != "" && opts["s"] != c.status) || (opts["u"] != "" && opts["u"] != c.user) ||
+			(opts["c"] != "" && opts["c"] != c.client) {
+			continue
+		}
+		files := s.changeFiles(c)
+		match := len(specs) == 0
+		for _, fs := range specs {
+			if fs.path == nil {
+				match = match || ((fs.minChange == 0 || n >= fs.minChange) && (fs.maxChange == 0 || n <= fs.maxChange))
+				continue
+			}
+			for _, f := range files {
+				r := *f
+				r.change = n
+				match = match || (fs.matchesPath(f.depotFile) && fs.matchesRev(&r))
+			}
+		}
+		if !match {
+			continue
+		}
+		desc := c.desc
+		if !long && !longer && len(desc) > 31 {
+			desc = desc[:31]
+		} else if longer && len(desc) > 250 {
+			desc = desc[:250]
+		}
+		r := stat("change", strconv.Itoa(n), "time", epoch(c.time), "user", c.user, "client", c.client,
+			"status", c.status, "changeType", "public", "desc", desc)
+		if p := changePath(files); p != "" {
+			r["path"] = p
+		}
+		if c.oldChange > 0 {
+			r["oldChange"] = strconv.Itoa(c.oldChange)
+		}
+		results = append(results, r)
+		if max > 0 && len(results) == max {
+			break
+		}
+	}
+	return results, nil
+}
+
+func (s *Server) runDescribe(args []string) ([]map[interface{}]interface{}, error) {
+	_, args = flags(args, "d")
+	if len(args) == 0 {
+		return []map[interface{}]interface{}{errorResult(severityFailed, genericUsage, "Missing/wrong number of arguments.")}, nil
+	}
+	results := []map[interface{}]interface{}{}
+	for _, a := range args {
+		n, _ := strconv.Atoi(a)
+		c, ok := s.changes[n]
+		if !ok {
+			results = append(results, errorResult(severityFailed, genericUnknown, "%s - no such changelist.", a))
+			continue
+		}
+		files := s.changeFiles(c)
+		r := stat("change", strconv.Itoa(n), "user", c.user, "client", c.client, "time", epoch(c.time),
+			"desc", c.desc, "status", c.status, "changeType", "public")
+		if p := changePath(files); p != "" {
+			r["path"] = p
+		}
+		if c.oldChange > 0 {
+			r["oldChange"] = strconv.Itoa(c.oldChange)
+		}
+		for i, f := range s.changeFixes(n) {
+			r["job"+strconv.Itoa(i)] = f.job
+			if j, ok := s.jobs[f.job]; ok {
+				r["jobstat"+strconv.Itoa(i)] = j.status
+			}
+		}
+		for i, f := range files {
+			idx := strconv.Itoa(i)
+			r["depotFile"+idx] = f.depotFile
+			r["action"+idx] = f.action
+			r["type"+idx] = f.fileType
+			r["rev"+idx] = strconv.Itoa(f.rev)
+			if c.status == "submitted" && !deleted(f.action) {
+				r["digest"+idx] = digest(f.content)
+				r["fileSize"+idx] = strconv.Itoa(len(f.content))
+			}
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+func (s *Server) runFixes(args []string) ([]map[interface{}]interface{}, error) {
+	opts, args := flags(args, "cjm")
+	specs, errRes := parseFileSpecs(args)
+	if errRes != nil {
+		return []map[interface{}]interface{}{errRes}, nil
+	}
+	max, _ := strconv.Atoi(opts["m"])
+	fixes := append([]*fix{}, s.fixes...)
+	sort.SliceStable(fixes, func(i, j int) bool {
+		if fixes[i].change != fixes[j].change {
+			return fixes[i].change > fixes[j].change
+		}
+		return fixes[i].job < fixes[j].job
+	})
+	results := []map[interface{}]interface{}{}
+	for _, f := range fixes {
+		if (opts["c"] != "" && opts["c"] != strconv.Itoa(f.change)) || (opts["j"] != "" && opts["j"] != f.job) {
+			continue
+		}
+		match := len(specs) == 0
+		if c, ok := s.changes[f.change]; ok && !match {
+			for _, file := range s.changeFiles(c) {
+				for _, fs := range specs {
+					match = match || fs.matchesPath(file.depotFile)
+				}
+			}
+		}
+		if !match {
+			continue
+		}
+		results = append(results, stat("Change", strconv.Itoa(f.change), "Client", f.client, "Date", epoch(f.date),
+			"Job", f.job, "Status", f.status, "User", f.user, "Action", "fixed"))
+		if max > 0 && len(results) == max {
+			break
+		}
+	}
+	return results, nil
+}
+
+// wildcard returns a regexp for a name pattern such as bob* or //depot/...
+func wildcard(pattern string) *regexp.Regexp {
+	p := regexp.QuoteMeta(pattern)
+	p = strings.ReplaceAll(p, `\.\.\.`, ".*")
+	p = strings.ReplaceAll(p, `\*`, ".*")
+	return regexp.MustCompile("^" + p + "$")
+}
+
+func (s *Server) runUsers(args []string) ([]map[interface{}]interface{}, error) {
+	opts, args := flags(args, "m")
+	max, _ := strconv.Atoi(opts["m"])
+	results := []map[interface{}]interface{}{}
+	for _, name := range sortedKeys(s.users) {
+		match := len(args) == 0
+		for _, a := range args {
+			match = match || wildcard(a).MatchString(name)
+		}
+		if !match {
+			continue
+		}
+		u := s.users[name]
+		results = append(results, stat("User", u.name, "Email", u.email, "Update", epoch(u.update),
+			"Access", epoch(u.access), "FullName", u.fullName, "Type", u.userType))
+		if max > 0 && len(results) == max {
+			break
+		}
+	}
+	return results, nil
+}
+
+func (s *Server) runClients(args []string) ([]map[interface{}]interface{}, error) {
+	opts, _ := flags(args, "meuE")
+	max, _ := strconv.Atoi(opts["m"])
+	results := []map[interface{}]interface{}{}
+	for _, name := range sortedKeys(s.clients) {
+		c := s.clients[name]
+		if (opts["u"] != "" && opts["u"] != c.owner) || (opts["e"] != "" && !wildcard(opts["e"]).MatchString(name)) {
+			continue
+		}
+		results = append(results, stat("client", c.name, "Update", epoch(c.update), "Access", epoch(c.access),
+			"Owner", c.owner, "Options", c.options, "SubmitOptions", "submitunchanged", "LineEnd", "local",
+			"Root", c.root, "Host", c.host, "Description", c.desc))
+		if max > 0 && len(results) == max {
+			break
+		}
+	}
+	return results, nil
+}
+
+// runJobs lists jobs, filtered by -e with expressions of the form
+// Field=value separated by spaces, which must all match
+func (s *Server) runJobs(args []string) ([]map[interface{}]interface{}, error) {
+	opts, _ := flags(args, "em")
+	max, _ := strconv.Atoi(opts["m"])
+	results := []map[interface{}]interface{}{}
+	for _, name := range sortedKeys(s.jobs) {
+		j := s.jobs[name]
+		r := stat("Job", j.name, "Status", j.status, "User", j.user, "Date", specDate(j.date), "Description", j.desc)
+		match := true
+		for _, term := range strings.Fields(opts["e"]) {
+			kv := strings.SplitN(term, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			found := false
+			for k, v := range r {
+				if strings.EqualFold(k.(string), kv[0]) && strings.EqualFold(v.(string), kv[1]) {
+					found = true
+				}
+			}
+			match = match && found
+		}
+		if !match {
+			continue
+		}
+		results = append(results, r)
+		if max > 0 && len(results) == max {
+			break
+		}
+	}
+	return results, nil
+}
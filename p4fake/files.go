@@ -0,0 +1,541 @@
+package p4fake
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// fileSpec is a file argument such as //depot/...@5 or //depot/a.txt#2
+type fileSpec struct {
+	arg       string
+	path      *regexp.Regexp // nil for all files, e.g. @5
+	rev       int            // latest revision wanted for #n, -1 for #none
+	minChange int            // range of changes for @n or @n,@m
+	maxChange int
+}
+
+// parseFileSpec parses a file argument with a revision specifier of #n,
+// #head, #have, #none, @n, @=n or @n,@m. Wildcards ... and * are allowed.
+func parseFileSpec(arg string) (fileSpec, error) {
+	fs := fileSpec{arg: arg}
+	path, spec := arg, ""
+	if i := strings.IndexAny(arg, "#@"); i >= 0 {
+		path, spec = arg[:i], arg[i:]
+	}
+	if path != "" {
+		pattern := regexp.QuoteMeta(path)
+		pattern = strings.ReplaceAll(pattern, `\.\.\.`, ".*")
+		pattern = strings.ReplaceAll(pattern, `\*`, "[^/]*")
+		fs.path = regexp.MustCompile("^" + pattern + "$")
+	}
+	switch {
+	case spec == "" || spec == "#head" || spec == "#have" || spec == "@now":
+	case spec == "#none":
+		fs.rev = -1
+	case spec[0] == '#':
+		n, err := strconv.Atoi(spec[1:])
+		if err != nil {
+			return fs, fmt.Errorf("Invalid revision number '%s'.", spec)
+		}
+		fs.rev = n
+		if n == 0 {
+			fs.rev = -1
+		}
+	case strings.HasPrefix(spec, "@="):
+		n, err := strconv.Atoi(spec[2:])
+		if err != nil {
+			return fs, fmt.Errorf("Invalid changelist number '%s'.", spec)
+		}
+		fs.minChange, fs.maxChange = n, n
+	default:
+		parts := strings.SplitN(spec[1:], ",", 2)
+		for i, p := range parts {
+			n, err := strconv.Atoi(strings.TrimPrefix(p, "@"))
+			if err != nil {
+				return fs, fmt.Errorf("Invalid changelist number '%s'.", spec)
+			}
+			if len(parts) == 2 && i == 0 {
+				fs.minChange = n
+			} else {
+				fs.maxChange = n
+			}
+		}
+	}
+	return fs, nil
+}
+
+func (fs fileSpec) matchesPath(depotFile string) bool {
+	return fs.path == nil || fs.path.MatchString(depotFile)
+}
+
+func (fs fileSpec) matchesRev(r *revision) bool {
+	if fs.rev < 0 || (fs.rev > 0 && r.rev > fs.rev) {
+		return false
+	}
+	if fs.minChange > 0 && r.change < fs.minChange {
+		return false
+	}
+	return fs.maxChange == 0 || r.change <= fs.maxChange
+}
+
+// revisions returns the revisions of a file matching the spec, oldest first
+func (fs fileSpec) revisions(revs []*revision) []*revision {
+	res := []*revision{}
+	for _, r := range revs {
+		if fs.matchesRev(r) {
+			res = append(res, r)
+		}
+	}
+	return res
+}
+
+// parseFileSpecs parses file arguments, returning an error result for the first which is invalid
+func parseFileSpecs(args []string) ([]fileSpec, map[interface{}]interface{}) {
+	specs := []fileSpec{}
+	for _, a := range args {
+		fs, err := parseFileSpec(a)
+		if err != nil {
+			return nil, errorResult(severityFailed, genericUsage, "%s", err.Error())
+		}
+		specs = append(specs, fs)
+	}
+	return specs, nil
+}
+
+func deleted(action string) bool {
+	return action == "delete" || action == "move/delete"
+}
+
+func openKey(clientName string, depotFile string) string {
+	return clientName + "\x00" + depotFile
+}
+
+// mapFile maps a depot file through a client's view, returning the file in
+// client syntax and its local path. Later view lines override earlier
+// ones, and lines starting with - exclude files.
+func (s *Server) mapFile(clientName string, depotFile string) (string, string, bool) {
+	view := []string{fmt.Sprintf("//depot/... //%s/...", clientName)}
+	root := ""
+	if c, ok := s.clients[clientName]; ok {
+		view, root = c.view, c.root
+	}
+	clientFile := ""
+	for _, line := range view {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		lhs, rhs := fields[0], fields[1]
+		exclude := strings.HasPrefix(lhs, "-")
+		lhs = strings.TrimLeft(lhs, "-+")
+		var mapped string
+		if strings.HasSuffix(lhs, "...") && strings.HasPrefix(depotFile, strings.TrimSuffix(lhs, "...")) {
+			mapped = strings.TrimSuffix(rhs, "...") + strings.TrimPrefix(depotFile, strings.TrimSuffix(lhs, "..."))
+		} else if lhs == depotFile {
+			mapped = rhs
+		} else {
+			continue
+		}
+		clientFile = mapped
+		if exclude {
+			clientFile = ""
+		}
+	}
+	if clientFile == "" {
+		return "", "", false
+	}
+	local := strings.TrimPrefix(clientFile, "//"+clientName)
+	if root != "" {
+		local = strings.TrimSuffix(root, "/") + local
+	}
+	return clientFile, local, true
+}
+
+// matchingFiles returns the depot files, including those opened for add in
+// the current client, which match a spec
+func (s *Server) matchingFiles(fs fileSpec) []string {
+	names := map[string]bool{}
+	for name := range s.files {
+		if fs.matchesPath(name) {
+			names[name] = true
+		}
+	}
+	for _, of := range s.opened {
+		if of.client == s.client() && fs.matchesPath(of.depotFile) {
+			names[of.depotFile] = true
+		}
+	}
+	return sortedKeys(names)
+}
+
+func (s *Server) runFiles(args []string) ([]map[interface{}]interface{}, error) {
+	opts, args := flags(args, "m")
+	if len(args) == 0 {
+		return []map[interface{}]interface{}{errorResult(severityFailed, genericUsage, "Missing/wrong number of arguments.")}, nil
+	}
+	specs, errRes := parseFileSpecs(args)
+	if errRes != nil {
+		return []map[interface{}]interface{}{errRes}, nil
+	}
+	_, all := opts["a"]
+	_, excludeDeleted := opts["e"]
+	max, _ := strconv.Atoi(opts["m"])
+	results := []map[interface{}]interface{}{}
+	for _, fs := range specs {
+		found := false
+		for _, name := range s.matchingFiles(fs) {
+			revs := fs.revisions(s.files[name])
+			if len(revs) == 0 {
+				continue
+			}
+			if !all {
+				revs = revs[len(revs)-1:]
+			}
+			for i := len(revs) - 1; i >= 0; i-- {
+				r := revs[i]
+				if excludeDeleted && deleted(r.action) {
+					continue
+				}
+				found = true
+				results = append(results, stat("depotFile", r.depotFile, "rev", strconv.Itoa(r.rev),
+					"change", strconv.Itoa(r.change), "action", r.action, "type", r.fileType, "time", epoch(r.time)))
+			}
+		}
+		if !found {
+			results = append(results, errorResult(severityWarn, genericEmpty, "%s - no such file(s).", fs.arg))
+		}
+	}
+	if max > 0 && len(results) > max {
+		results = results[:max]
+	}
+	return results, nil
+}
+
+func (s *Server) runFstat(args []string) ([]map[interface{}]interface{}, error) {
+	opts, args := flags(args, "Tme")
+	if len(args) == 0 {
+		return []map[interface{}]interface{}{errorResult(severityFailed, genericUsage, "Missing/wrong number of arguments.")}, nil
+	}
+	specs, errRes := parseFileSpecs(args)
+	if errRes != nil {
+		return []map[interface{}]interface{}{errRes}, nil
+	}
+	_, sizes := opts["Ol"]
+	inChange, _ := strconv.Atoi(opts["e"])
+	max, _ := strconv.Atoi(opts["m"])
+	var fields map[string]bool
+	if t, ok := opts["T"]; ok {
+		fields = map[string]bool{}
+		for _, f := range strings.FieldsFunc(t, func(r rune) bool { return r == ',' || r == ' ' }) {
+			fields[f] = true
+		}
+	}
+	results := []map[interface{}]interface{}{}
+	for _, fs := range specs {
+		found := false
+		for _, name := range s.matchingFiles(fs) {
+			r := s.fstat(name, fs, sizes, inChange)
+			if r == nil {
+				continue
+			}
+			found = true
+			if fields != nil {
+				for k := range r {
+					if k != "code" && !fields[k.(string)] {
+						delete(r, k)
+					}
+				}
+			}
+			results = append(results, r)
+		}
+		if !found {
+			results = append(results, errorResult(severityWarn, genericEmpty, "%s - no such file(s).", fs.arg))
+		}
+	}
+	if max > 0 && len(results) > max {
+		results = results[:max]
+	}
+	return results, nil
+}
+
+// fstat returns the fstat result for a file, or nil if it doesn't match
+func (s *Server) fstat(name string, fs fileSpec, sizes bool, inChange int) map[interface{}]interface{} {
+	revs := fs.revisions(s.files[name])
+	of := s.opened[openKey(s.client(), name)]
+	if len(revs) == 0 && of == nil {
+		return nil
+	}
+	if inChange > 0 {
+		match := of != nil && of.change == inChange
+		for _, r := range revs {
+			match = match || r.change == inChange
+		}
+		if !match {
+			return nil
+		}
+	}
+	r := stat("depotFile", name)
+	if _, local, ok := s.mapFile(s.client(), name); ok {
+		r["clientFile"] = local
+		r["isMapped"] = ""
+	}
+	if head := headRevision(revs); head != nil {
+		r["headAction"] = head.action
+		r["headType"] = head.fileType
+		r["headTime"] = epoch(head.time)
+		r["headRev"] = strconv.Itoa(head.rev)
+		r["headChange"] = strconv.Itoa(head.change)
+		r["headModTime"] = epoch(head.time)
+		if sizes && !deleted(head.action) {
+			r["fileSize"] = strconv.Itoa(len(head.content))
+			r["digest"] = digest(head.content)
+		}
+	}
+	if of != nil {
+		r["action"] = of.action
+		r["change"] = changeName(of.change)
+		r["type"] = of.fileType
+		r["actionOwner"] = of.user
+		r["workRev"] = strconv.Itoa(of.rev + 1)
+	}
+	others := []*openFile{}
+	for _, o := range s.opened {
+		if o.depotFile == name && o.client != s.client() {
+			others = append(others, o)
+		}
+	}
+	sort.Slice(others, func(i, j int) bool { return others[i].client < others[j].client })
+	for i, o := range others {
+		r["otherOpen"+strconv.Itoa(i)] = o.user + "@" + o.client
+		r["otherAction"+strconv.Itoa(i)] = o.action
+	}
+	if len(others) > 0 {
+		r["otherOpen"] = strconv.Itoa(len(others))
+	}
+	return r
+}
+
+func changeName(n int) string {
+	if n == 0 {
+		return "default"
+	}
+	return strconv.Itoa(n)
+}
+
+func (s *Server) runOpened(args []string) ([]map[interface{}]interface{}, error) {
+	opts, args := flags(args, "cCum")
+	specs, errRes := parseFileSpecs(args)
+	if errRes != nil {
+		return []map[interface{}]interface{}{errRes}, nil
+	}
+	_, all := opts["a"]
+	clientName := s.client()
+	if c, ok := opts["C"]; ok {
+		clientName = c
+	}
+	max, _ := strconv.Atoi(opts["m"])
+	keys := make([]string, 0, len(s.opened))
+	for k := range s.opened {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	results := []map[interface{}]interface{}{}
+	for _, k := range keys {
+		of := s.opened[k]
+		if !all && of.client != clientName {
+			continue
+		}
+		if c, ok := opts["c"]; ok && c != changeName(of.change) {
+			continue
+		}
+		if u, ok := opts["u"]; ok && u != of.user {
+			continue
+		}
+		match := len(specs) == 0
+		for _, fs := range specs {
+			match = match || fs.matchesPath(of.depotFile)
+		}
+		if !match {
+			continue
+		}
+		clientFile, _, _ := s.mapFile(of.client, of.depotFile)
+		rev, haveRev := strconv.Itoa(of.rev), strconv.Itoa(of.rev)
+		if of.action == "add" {
+			rev, haveRev = "1", "none"
+		}
+		results = append(results, stat("depotFile", of.depotFile, "clientFile", clientFile, "rev", rev,
+			"haveRev", haveRev, "action", of.action, "change", changeName(of.change), "type", of.fileType,
+			"user", of.user, "client", of.client))
+		if max > 0 && len(results) == max {
+			break
+		}
+	}
+	if len(results) == 0 {
+		if len(args) == 0 {
+			return []map[interface{}]interface{}{errorResult(severityWarn, genericEmpty, "File(s) not opened on this client.")}, nil
+		}
+		for _, a := range args {
+			results = append(results, errorResult(severityWarn, genericEmpty, "%s - file(s) not opened on this client.", a))
+		}
+	}
+	return results, nil
+}
+
+// runOpen opens files for add, edit or delete in the current client
+func (s *Server) runOpen(action string, args []string) ([]map[interface{}]interface{}, error) {
+	opts, args := flags(args, "ct")
+	if len(args) == 0 {
+		return []map[interface{}]interface{}{errorResult(severityFailed, genericUsage, "Missing/wrong number of arguments.")}, nil
+	}
+	changeNum := 0
+	if c, ok := opts["c"]; ok && c != "default" {
+		n, err := strconv.Atoi(c)
+		ch, exists := s.changes[n]
+		if err != nil || !exists || ch.status != "pending" || ch.client != s.client() {
+			return []map[interface{}]interface{}{errorResult(severityFailed, genericUnknown, "Change %s unknown.", c)}, nil
+		}
+		changeNum = n
+	}
+	specs, errRes := parseFileSpecs(args)
+	if errRes != nil {
+		return []map[interface{}]interface{}{errRes}, nil
+	}
+	results := []map[interface{}]interface{}{}
+	for _, fs := range specs {
+		names := []string{}
+		if action == "add" {
+			name := fs.arg
+			if i := strings.IndexAny(name, "#@"); i >= 0 {
+				name = name[:i]
+			}
+			if strings.Contains(name, "...") || strings.Contains(name, "*") {
+				results = append(results, errorResult(severityFailed, genericUsage, "%s - can't add wildcards", fs.arg))
+				continue
+			}
+			names = append(names, name)
+		} else {
+			for _, name := range s.matchingFiles(fs) {
+				if head := headRevision(s.files[name]); head != nil && !deleted(head.action) {
+					names = append(names, name)
+				}
+			}
+			if len(names) == 0 {
+				results = append(results, errorResult(severityWarn, genericEmpty, "%s - no such file(s).", fs.arg))
+			}
+		}
+		for _, name := range names {
+			results = append(results, s.open(action, name, changeNum, opts["t"]))
+		}
+	}
+	return results, nil
+}
+
+func (s *Server) open(action string, name string, changeNum int, fileType string) map[interface{}]interface{} {
+	_, local, ok := s.mapFile(s.client(), name)
+	if !ok {
+		return errorResult(severityWarn, genericEmpty, "%s - file(s) not in client view.", name)
+	}
+	if of, ok := s.opened[openKey(s.client(), name)]; ok {
+		return errorResult(severityWarn, genericNotYet, "%s - can't %s (already opened for %s)", name, action, of.action)
+	}
+	head := headRevision(s.files[name])
+	rev := 0
+	if action == "add" {
+		if head != nil && !deleted(head.action) {
+			return errorResult(severityWarn, genericNotYet, "%s - can't add existing file", name)
+		}
+		if head != nil {
+			rev = head.rev
+		}
+	} else {
+		rev = head.rev
+		if fileType == "" {
+			fileType = head.fileType
+		}
+	}
+	if fileType == "" {
+		fileType = "text"
+	}
+	s.opened[openKey(s.client(), name)] = &openFile{depotFile: name, client: s.client(), user: s.user(),
+		change: changeNum, action: action, fileType: fileType, rev: rev}
+	return stat("depotFile", name, "clientFile", local, "workRev", strconv.Itoa(rev+1), "action", action, "type", fileType)
+}
+
+func (s *Server) runSubmit(args []string) ([]map[interface{}]interface{}, error) {
+	opts, _ := flags(args, "dc")
+	var c *change
+	if n, ok := opts["c"]; ok {
+		num, err := strconv.Atoi(n)
+		c = s.changes[num]
+		if err != nil || c == nil || c.client != s.client() {
+			return []map[interface{}]interface{}{errorResult(severityFailed, genericUnknown, "Change %s unknown.", n)}, nil
+		}
+		if c.status != "pending" {
+			return []map[interface{}]interface{}{errorResult(severityFailed, genericNotYet, "Change %s is already committed.", n)}, nil
+		}
+		if d, ok := opts["d"]; ok {
+			c.desc = d
+		}
+	} else if _, ok := opts["d"]; !ok {
+		return []map[interface{}]interface{}{errorResult(severityFailed, genericUsage, "Submit requires a description with -d or -c change.")}, nil
+	}
+	changeNum := 0
+	if c != nil {
+		changeNum = c.number
+	}
+	files := []*openFile{}
+	for _, of := range s.opened {
+		if of.client == s.client() && of.change == changeNum {
+			files = append(files, of)
+		}
+	}
+	if len(files) == 0 {
+		if c == nil {
+			return []map[interface{}]interface{}{errorResult(severityFailed, genericEmpty, "No files to submit from the default changelist.")}, nil
+		}
+		return []map[interface{}]interface{}{errorResult(severityFailed, genericEmpty, "No files to submit.")}, nil
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].depotFile < files[j].depotFile })
+
+	if c == nil {
+		c = s.newChange(opts["d"])
+	} else if c.number != s.lastChange {
+		// Changes are renumbered if later ones have been created
+		s.lastChange++
+		delete(s.changes, c.number)
+		for _, f := range s.fixes {
+			if f.change == c.number {
+				f.change = s.lastChange
+			}
+		}
+		c.oldChange, c.number = c.number, s.lastChange
+		s.changes[c.number] = c
+	}
+	results := []map[interface{}]interface{}{stat("change", changeName(changeNum), "openFiles", strconv.Itoa(len(files)), "locked", strconv.Itoa(len(files)))}
+	c.status = "submitted"
+	c.time = s.now()
+	for _, of := range files {
+		revs := s.files[of.depotFile]
+		content := ""
+		if head := headRevision(revs); head != nil && of.action != "add" {
+			content = head.content
+		}
+		r := &revision{depotFile: of.depotFile, rev: len(revs) + 1, change: c.number, action: of.action,
+			fileType: of.fileType, time: c.time, content: content}
+		s.files[of.depotFile] = append(revs, r)
+		delete(s.opened, openKey(of.client, of.depotFile))
+		results = append(results, stat("depotFile", of.depotFile, "rev", strconv.Itoa(r.rev), "action", r.action))
+	}
+	for _, f := range s.fixes {
+		if f.change == c.number {
+			if j, ok := s.jobs[f.job]; ok {
+				j.status = f.status
+			}
+		}
+	}
+	results = append(results, stat("submittedChange", strconv.Itoa(c.number)))
+	return results, nil
+}
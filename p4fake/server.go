@@ -0,0 +1,377 @@
+// Package p4fake is an in-memory fake Perforce server for tests. A Server
+// implements the Runner and SpecRunner interfaces of go-libp4, answering a
+// subset of commands from a small modelled depot with results shaped like
+// those of p4 -G, so code can be tested without a p4d binary.
+//
+// Files are named with depot syntax, e.g. //depot/main/a.txt. Supported
+// commands are changes, describe, fixes, files, fstat, opened, add, edit,
+// delete, submit, users, clients and jobs, and -o and -i of the change,
+// client, job and user specs.
+package p4fake
+
+import (
+	"crypto/md5"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server is an in-memory Perforce server. Its exported fields may be set
+// before it is used.
+type Server struct {
+	User   string           // user running commands, default "fake"
+	Client string           // client running commands, default "fake_ws"
+	Now    func() time.Time // clock for changes and specs, default time.Now
+
+	mutex      sync.Mutex
+	files      map[string][]*revision // depot file -> revisions, oldest first
+	opened     map[string]*openFile   // client + depot file -> open file
+	changes    map[int]*change
+	lastChange int
+	clients    map[string]*client
+	jobs       map[string]*job
+	lastJob    int
+	fixes      []*fix
+	users      map[string]*user
+}
+
+type revision struct {
+	depotFile string
+	rev       int
+	change    int
+	action    string
+	fileType  string
+	time      time.Time
+	content   string
+}
+
+type openFile struct {
+	depotFile string
+	client    string
+	user      string
+	change    int // 0 for the default change
+	action    string
+	fileType  string
+	rev       int // revision opened, 0 for add
+}
+
+type change struct {
+	number    int
+	oldChange int
+	user      string
+	client    string
+	status    string // pending or submitted
+	desc      string
+	time      time.Time
+}
+
+type client struct {
+	name    string
+	owner   string
+	host    string
+	root    string
+	desc    string
+	options string
+	view    []string
+	update  time.Time
+	access  time.Time
+}
+
+type job struct {
+	name   string
+	status string
+	user   string
+	desc   string
+	date   time.Time
+}
+
+type fix struct {
+	job    string
+	change int
+	client string
+	user   string
+	status string
+	date   time.Time
+}
+
+type user struct {
+	name     string
+	email    string
+	fullName string
+	userType string
+	update   time.Time
+	access   time.Time
+}
+
+// FileChange is a change to a file made by Submit
+type FileChange struct {
+	DepotFile string
+	Action    string // add, edit or delete; default add for a new file, otherwise edit
+	Type      string // file type, default text or that of the previous revision
+	Content   string
+}
+
+// New returns an empty Server
+func New() *Server {
+	return &Server{
+		files:   map[string][]*revision{},
+		opened:  map[string]*openFile{},
+		changes: map[int]*change{},
+		clients: map[string]*client{},
+		jobs:    map[string]*job{},
+		users:   map[string]*user{},
+	}
+}
+
+// AddUser adds or replaces a user
+func (s *Server) AddUser(name string, email string, fullName string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := s.now()
+	s.users[name] = &user{name: name, email: email, fullName: fullName, userType: "standard", update: now, access: now}
+}
+
+// AddClient adds or replaces a client owned by the current user. Without a
+// view it maps //depot/... to //name/...
+func (s *Server) AddClient(name string, root string, view ...string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(view) == 0 {
+		view = []string{fmt.Sprintf("//depot/... //%s/...", name)}
+	}
+	now := s.now()
+	s.clients[name] = &client{name: name, owner: s.user(), root: root, options: "noallwrite noclobber nocompress unlocked nomodtime normdir",
+		view: view, update: now, access: now, desc: fmt.Sprintf("Created by %s.\n", s.user())}
+}
+
+// AddJob adds or replaces a job
+func (s *Server) AddJob(name string, status string, desc string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.jobs[name] = &job{name: name, status: status, user: s.user(), desc: desc, date: s.now()}
+}
+
+// Fix records that a change fixes a job. The job is closed if the change
+// is submitted.
+func (s *Server) Fix(jobName string, changeNum int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	c, ok := s.changes[changeNum]
+	if !ok {
+		return fmt.Errorf("Change %d unknown.", changeNum)
+	}
+	return s.addFix(jobName, c)
+}
+
+// Submit submits a change of files as the current user and client,
+// returning the change number. It is a quick way to populate the depot;
+// files opened with add and edit are submitted with the submit command.
+func (s *Server) Submit(desc string, files ...FileChange) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	c := s.newChange(desc)
+	for _, f := range files {
+		revs := s.files[f.DepotFile]
+		head := headRevision(revs)
+		action, fileType := f.Action, f.Type
+		if action == "" {
+			action = "add"
+			if head != nil && head.action != "delete" {
+				action = "edit"
+			}
+		}
+		if fileType == "" {
+			fileType = "text"
+			if head != nil {
+				fileType = head.fileType
+			}
+		}
+		s.files[f.DepotFile] = append(revs, &revision{depotFile: f.DepotFile, rev: len(revs) + 1, change: c.number,
+			action: action, fileType: fileType, time: c.time, content: f.Content})
+	}
+	c.status = "submitted"
+	return c.number, nil
+}
+
+// Run runs a p4 command, returning results as p4 -G would. Failures
+// reported by Perforce are returned as results with code error, while
+// commands the fake doesn't support return an error.
+func (s *Server) Run(args []string) ([]map[interface{}]interface{}, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("No command to run")
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "changes":
+		return s.runChanges(args)
+	case "describe":
+		return s.runDescribe(args)
+	case "fixes":
+		return s.runFixes(args)
+	case "files":
+		return s.runFiles(args)
+	case "fstat":
+		return s.runFstat(args)
+	case "opened":
+		return s.runOpened(args)
+	case "add", "edit", "delete":
+		return s.runOpen(cmd, args)
+	case "submit":
+		return s.runSubmit(args)
+	case "users":
+		return s.runUsers(args)
+	case "clients":
+		return s.runClients(args)
+	case "jobs":
+		return s.runJobs(args)
+	case "change", "client", "job", "user":
+		return s.runSpec(cmd, args)
+	}
+	return nil, fmt.Errorf("p4fake doesn't support p4 %s", append([]string{cmd}, args...))
+}
+
+func (s *Server) user() string {
+	if s.User == "" {
+		return "fake"
+	}
+	return s.User
+}
+
+func (s *Server) client() string {
+	if s.Client == "" {
+		return "fake_ws"
+	}
+	return s.Client
+}
+
+func (s *Server) now() time.Time {
+	if s.Now == nil {
+		return time.Now()
+	}
+	return s.Now()
+}
+
+// newChange creates a pending change for the current user and client
+func (s *Server) newChange(desc string) *change {
+	s.lastChange++
+	c := &change{number: s.lastChange, user: s.user(), client: s.client(), status: "pending", desc: desc, time: s.now()}
+	s.changes[c.number] = c
+	return c
+}
+
+func (s *Server) addFix(jobName string, c *change) error {
+	j, ok := s.jobs[jobName]
+	if !ok {
+		return fmt.Errorf("Job '%s' doesn't exist.", jobName)
+	}
+	for _, f := range s.fixes {
+		if f.job == jobName && f.change == c.number {
+			return nil
+		}
+	}
+	s.fixes = append(s.fixes, &fix{job: jobName, change: c.number, client: c.client, user: c.user, status: "closed", date: s.now()})
+	if c.status == "submitted" {
+		j.status = "closed"
+	}
+	return nil
+}
+
+// stat returns a result with code stat and the given fields
+func stat(fields ...interface{}) map[interface{}]interface{} {
+	r := map[interface{}]interface{}{"code": "stat"}
+	for i := 0; i+1 < len(fields); i += 2 {
+		r[fields[i]] = fields[i+1]
+	}
+	return r
+}
+
+// info returns a result with code info, as p4 -G reports messages such as
+// a spec being deleted
+func info(format string, args ...interface{}) map[interface{}]interface{} {
+	return map[interface{}]interface{}{
+		"code":  "info",
+		"data":  fmt.Sprintf(format, args...),
+		"level": int32(0),
+	}
+}
+
+// errorResult returns a result with code error, as p4 -G reports failures,
+// with severity and generic as int32 as they are decoded
+func errorResult(severity int, generic int, format string, args ...interface{}) map[interface{}]interface{} {
+	return map[interface{}]interface{}{
+		"code":     "error",
+		"data":     fmt.Sprintf(format, args...) + "\n",
+		"severity": int32(severity),
+		"generic":  int32(generic),
+	}
+}
+
+// Severities and generic codes of error results
+const (
+	severityWarn   = 2
+	severityFailed = 3
+	genericUsage   = 1
+	genericUnknown = 2
+	genericNotYet  = 5
+	genericEmpty   = 17
+)
+
+func epoch(t time.Time) string {
+	return strconv.FormatInt(t.Unix(), 10)
+}
+
+func specDate(t time.Time) string {
+	return t.Format("2006/01/02 15:04:05")
+}
+
+func digest(content string) string {
+	return strings.ToUpper(fmt.Sprintf("%x", md5.Sum([]byte(content))))
+}
+
+func headRevision(revs []*revision) *revision {
+	if len(revs) == 0 {
+		return nil
+	}
+	return revs[len(revs)-1]
+}
+
+// flags splits args into options and their values, and other arguments.
+// valueFlags are the options which take a value.
+func flags(args []string, valueFlags string) (map[string]string, []string) {
+	opts := map[string]string{}
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if a == "--" {
+			return opts, args[i+1:]
+		}
+		if len(a) < 2 || a[0] != '-' {
+			return opts, args[i:]
+		}
+		name := a[1:2]
+		if strings.Contains(valueFlags, name) {
+			if len(a) > 2 {
+				opts[name] = a[2:]
+			} else if i+1 < len(args) {
+				i++
+				opts[name] = args[i]
+			}
+			continue
+		}
+		opts[a[1:]] = ""
+	}
+	return opts, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
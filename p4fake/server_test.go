@@ -0,0 +1,174 @@
+package p4fake
+
+import (
+	"testing"
+	"time"
+
+	p4 "github.com/rcowham/go-libp4"
+	"github.com/stretchr/testify/assert"
+)
+
+var _ p4.SpecRunner = (*Server)(nil)
+
+func newTestServer(t *testing.T) *Server {
+	s := New()
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	s.Now = func() time.Time {
+		now = now.Add(time.Minute)
+		return now
+	}
+	s.User = "bob"
+	s.Client = "bob_ws"
+	s.AddUser("bob", "bob@example.com", "Bob")
+	s.AddClient("bob_ws", "/home/bob/ws")
+	s.AddJob("job000001", "open", "Fix the thing")
+	n, err := s.Submit("Initial import", FileChange{DepotFile: "//depot/main/a.txt", Content: "hello"},
+		FileChange{DepotFile: "//depot/main/b.bin", Type: "binary"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	return s
+}
+
+func TestEditAndSubmit(t *testing.T) {
+	s := newTestServer(t)
+
+	res, err := s.Run([]string{"edit", "//depot/main/a.txt"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[interface{}]interface{}{"code": "stat", "depotFile": "//depot/main/a.txt",
+		"clientFile": "/home/bob/ws/main/a.txt", "workRev": "2", "action": "edit", "type": "text"}, res[0])
+	res, _ = s.Run([]string{"add", "//depot/main/a.txt"})
+	assert.Equal(t, "error", res[0]["code"])
+	_, err = s.Run([]string{"add", "-t", "binary", "//depot/main/c.bin"})
+	assert.NoError(t, err)
+
+	res, err = s.Run([]string{"opened"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(res))
+	assert.Equal(t, "//bob_ws/main/a.txt", res[0]["clientFile"])
+	assert.Equal(t, "none", res[1]["haveRev"])
+
+	msg, err := s.SaveTxt("change", map[string]string{"Change": "new", "Description": "Fix the thing",
+		"Files": "//depot/main/a.txt\t# edit\n//depot/main/c.bin\t# add", "Jobs": "job000001"})
+	assert.NoError(t, err)
+	assert.Equal(t, "Change 2 created with 2 open file(s).\n", msg)
+
+	res, err = s.Run([]string{"submit", "-c", "2"})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(res))
+	assert.Equal(t, "2", res[3]["submittedChange"])
+
+	d, err := p4.RunDescribe(s, []string{"-s", "2"})
+	assert.NoError(t, err)
+	assert.Equal(t, "submitted", d.Status)
+	assert.Equal(t, "//depot/main/...", d.Path)
+	assert.Equal(t, []p4.JobDescription{{Job: "job000001", Status: "closed"}}, d.Jobs)
+	assert.Equal(t, []p4.Revision{
+		{Action: "edit", Rev: "2", DepotFile: "//depot/main/a.txt", Type: "text", Digest: "5D41402ABC4B2A76B9719D911017C592", FileSize: "5"},
+		{Action: "add", Rev: "1", DepotFile: "//depot/main/c.bin", Type: "binary", Digest: "D41D8CD98F00B204E9800998ECF8427E", FileSize: "0"},
+	}, d.Revisions)
+
+	fixes, err := p4.RunFixes(s, []string{"-j", "job000001"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(fixes))
+	assert.Equal(t, "2", fixes[0].Change)
+	assert.Equal(t, "closed", fixes[0].Status)
+	assert.Equal(t, "bob", fixes[0].User)
+
+	res, _ = s.Run([]string{"opened"})
+	assert.Equal(t, "File(s) not opened on this client.\n", res[0]["data"])
+}
+
+func TestFilesAndChanges(t *testing.T) {
+	s := newTestServer(t)
+	_, err := s.Submit("Second", FileChange{DepotFile: "//depot/main/a.txt", Content: "bye"},
+		FileChange{DepotFile: "//depot/main/b.bin", Action: "delete"})
+	assert.NoError(t, err)
+
+	tests := []struct {
+		args  []string
+		field string
+		want  []string
+	}{
+		{[]string{"files", "//depot/..."}, "rev", []string{"2", "2"}},
+		{[]string{"files", "-e", "//depot/..."}, "depotFile", []string{"//depot/main/a.txt"}},
+		{[]string{"files", "//depot/...@1"}, "action", []string{"add", "add"}},
+		{[]string{"files", "-a", "//depot/main/a.txt"}, "rev", []string{"2", "1"}},
+		{[]string{"files", "//depot/main/*.txt#1"}, "change", []string{"1"}},
+		{[]string{"changes"}, "change", []string{"2", "1"}},
+		{[]string{"changes", "-m1", "//depot/main/b.bin@1"}, "desc", []string{"Initial import"}},
+		{[]string{"changes", "@2,@2"}, "change", []string{"2"}},
+		{[]string{"fstat", "-T", "headRev,headAction", "//depot/main/b.bin"}, "headAction", []string{"delete"}},
+		{[]string{"fstat", "-Ol", "//depot/main/a.txt"}, "fileSize", []string{"3"}},
+	}
+	for _, tt := range tests {
+		res, err := s.Run(tt.args)
+		assert.NoError(t, err)
+		got := []string{}
+		for _, r := range res {
+			got = append(got, r[tt.field].(string))
+		}
+		assert.Equal(t, tt.want, got, "%v", tt.args)
+	}
+
+	res, _ := s.Run([]string{"files", "//depot/other/..."})
+	assert.Equal(t, "//depot/other/... - no such file(s).\n", res[0]["data"])
+	_, err = p4.RunDescribe(s, []string{"9"})
+	assert.Error(t, err)
+	_, err = s.Run([]string{"integrate", "//depot/main/...", "//depot/rel/..."})
+	assert.Error(t, err)
+}
+
+func TestSubmitRenumbers(t *testing.T) {
+	s := newTestServer(t)
+	s.Run([]string{"edit", "//depot/main/a.txt"})
+	msg, err := s.SaveTxt("change", map[string]string{"Change": "new", "Description": "Pending",
+		"Files": "//depot/main/a.txt"})
+	assert.NoError(t, err)
+	assert.Equal(t, "Change 2 created with 1 open file(s).\n", msg)
+	s.Submit("Someone else", FileChange{DepotFile: "//depot/main/z.txt"})
+
+	res, _ := s.Run([]string{"submit", "-c", "2"})
+	assert.Equal(t, "4", res[len(res)-1]["submittedChange"])
+	d, err := p4.RunDescribe(s, []string{"4"})
+	assert.NoError(t, err)
+	assert.Equal(t, "2", d.OldChange)
+}
+
+func TestSpecs(t *testing.T) {
+	s := newTestServer(t)
+
+	u, err := p4.FetchUser(s, "alice")
+	assert.NoError(t, err)
+	u.Email = "alice@example.com"
+	u.FullName = "Alice"
+	msg, err := p4.SaveUser(s, u)
+	assert.NoError(t, err)
+	assert.Equal(t, "User alice saved.\n", msg)
+	users, err := p4.RunUsers(s, []string{})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(users))
+	assert.Equal(t, "alice@example.com", users[0].Email)
+
+	res, _ := s.Run([]string{"client", "-o"})
+	assert.Equal(t, "//depot/... //bob_ws/...", res[0]["View0"])
+	msg, err = s.SaveTxt("client", map[string]string{"Client": "bob_ws", "Root": "/tmp/ws",
+		"View": "//depot/main/... //bob_ws/...\n-//depot/main/b.bin //bob_ws/b.bin"})
+	assert.NoError(t, err)
+	assert.Equal(t, "Client bob_ws saved.\n", msg)
+	res, _ = s.Run([]string{"edit", "//depot/main/..."})
+	assert.Equal(t, "/tmp/ws/a.txt", res[0]["clientFile"])
+	assert.Equal(t, "//depot/main/b.bin - file(s) not in client view.\n", res[1]["data"])
+
+	msg, err = s.SaveTxt("job", map[string]string{"Job": "new", "Description": "Another"})
+	assert.NoError(t, err)
+	assert.Equal(t, "Job job000002 saved.\n", msg)
+	res, _ = s.Run([]string{"jobs", "-e", "status=open"})
+	assert.Equal(t, 2, len(res))
+
+	res, _ = s.Run([]string{"change", "-o"})
+	assert.Equal(t, "//depot/main/a.txt\t# edit", res[0]["Files0"])
+	res, _ = s.Run([]string{"job", "-d", "job000002"})
+	assert.Equal(t, []map[interface{}]interface{}{{"code": "info", "data": "Job job000002 deleted.", "level": int32(0)}}, res)
+	assert.NoError(t, p4.DeleteUser(s, "alice"))
+	assert.Error(t, p4.DeleteUser(s, "alice"))
+}
@@ -0,0 +1,279 @@
+package p4fake
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// runSpec runs change, client, job or user with -o to output a spec, or -d
+// to delete one. Specs are input with SaveTxt.
+func (s *Server) runSpec(specName string, args []string) ([]map[interface{}]interface{}, error) {
+	opts, args := flags(args, "")
+	name := ""
+	if len(args) > 0 {
+		name = args[0]
+	}
+	if _, ok := opts["o"]; ok {
+		return []map[interface{}]interface{}{s.outputSpec(specName, name)}, nil
+	}
+	if _, ok := opts["d"]; ok && name != "" {
+		return []map[interface{}]interface{}{s.deleteSpec(specName, name)}, nil
+	}
+	if _, ok := opts["i"]; ok {
+		return nil, fmt.Errorf("p4fake reads %s specs with SaveTxt", specName)
+	}
+	return nil, fmt.Errorf("p4fake doesn't support p4 %s", append([]string{specName}, args...))
+}
+
+func (s *Server) outputSpec(specName string, name string) map[interface{}]interface{} {
+	switch specName {
+	case "change":
+		return s.outputChange(name)
+	case "client":
+		if name == "" {
+			name = s.client()
+		}
+		c, ok := s.clients[name]
+		if !ok {
+			c = &client{name: name, owner: s.user(), options: "noallwrite noclobber nocompress unlocked nomodtime normdir",
+				desc: "Created by " + s.user() + ".\n", view: []string{fmt.Sprintf("//depot/... //%s/...", name)}}
+		}
+		r := stat("Client", c.name, "Owner", c.owner, "Host", c.host, "Description", c.desc, "Root", c.root,
+			"Options", c.options, "SubmitOptions", "submitunchanged", "LineEnd", "local")
+		if ok {
+			r["Update"] = specDate(c.update)
+			r["Access"] = specDate(c.access)
+		}
+		for i, v := range c.view {
+			r["View"+strconv.Itoa(i)] = v
+		}
+		return r
+	case "job":
+		j, ok := s.jobs[name]
+		if !ok {
+			j = &job{name: "new", status: "open", user: s.user(), date: s.now(), desc: "<enter description here>\n"}
+		}
+		return stat("Job", j.name, "Status", j.status, "User", j.user, "Date", specDate(j.date), "Description", j.desc)
+	default:
+		if name == "" {
+			name = s.user()
+		}
+		u, ok := s.users[name]
+		if !ok {
+			u = &user{name: name, email: name + "@localhost", fullName: name, userType: "standard"}
+		}
+		r := stat("User", u.name, "Email", u.email, "FullName", u.fullName, "Type", u.userType)
+		if ok {
+			r["Update"] = specDate(u.update)
+			r["Access"] = specDate(u.access)
+		}
+		return r
+	}
+}
+
+func (s *Server) outputChange(name string) map[interface{}]interface{} {
+	var c *change
+	if name != "" {
+		n, _ := strconv.Atoi(name)
+		if c = s.changes[n]; c == nil {
+			return errorResult(severityFailed, genericUnknown, "Change %s unknown.", name)
+		}
+	}
+	if c == nil {
+		r := stat("Change", "new", "Client", s.client(), "User", s.user(), "Status", "new",
+			"Description", "<enter description here>\n")
+		files := []string{}
+		for _, of := range s.opened {
+			if of.client == s.client() && of.change == 0 {
+				files = append(files, of.depotFile+"\t# "+of.action)
+			}
+		}
+		sort.Strings(files)
+		for i, f := range files {
+			r["Files"+strconv.Itoa(i)] = f
+		}
+		return r
+	}
+	r := stat("Change", strconv.Itoa(c.number), "Date", specDate(c.time), "Client", c.client, "User", c.user,
+		"Status", c.status, "Type", "public", "Description", c.desc)
+	for i, f := range s.changeFixes(c.number) {
+		r["Jobs"+strconv.Itoa(i)] = f.job
+	}
+	for i, f := range s.changeFiles(c) {
+		file := f.depotFile
+		if c.status == "pending" {
+			file += "\t# " + f.action
+		}
+		r["Files"+strconv.Itoa(i)] = file
+	}
+	return r
+}
+
+func (s *Server) deleteSpec(specName string, name string) map[interface{}]interface{} {
+	switch specName {
+	case "change":
+		n, _ := strconv.Atoi(name)
+		c, ok := s.changes[n]
+		if !ok {
+			return errorResult(severityFailed, genericUnknown, "Change %s unknown.", name)
+		}
+		if c.status == "submitted" || len(s.changeFiles(c)) > 0 {
+			return errorResult(severityFailed, genericNotYet, "Change %s has %d open file(s) associated with it and can't be deleted.", name, len(s.changeFiles(c)))
+		}
+		delete(s.changes, n)
+		return info("Change %s deleted.", name)
+	case "client":
+		if _, ok := s.clients[name]; !ok {
+			return errorResult(severityFailed, genericUnknown, "Client '%s' doesn't exist.", name)
+		}
+		delete(s.clients, name)
+		return info("Client %s deleted.", name)
+	case "job":
+		if _, ok := s.jobs[name]; !ok {
+			return errorResult(severityFailed, genericUnknown, "Job '%s' doesn't exist.", name)
+		}
+		delete(s.jobs, name)
+		return info("Job %s deleted.", name)
+	default:
+		if _, ok := s.users[name]; !ok {
+			return errorResult(severityFailed, genericUnknown, "User %s doesn't exist.", name)
+		}
+		delete(s.users, name)
+		return info("User %s deleted.", name)
+	}
+}
+
+// specLines splits a multi-line spec field into its non empty lines
+func specLines(v string) []string {
+	lines := []string{}
+	for _, l := range strings.Split(v, "\n") {
+		if l = strings.TrimSpace(l); l != "" {
+			lines = append(lines, l)
+		}
+	}
+	return lines
+}
+
+// SaveTxt saves a change, client, job or user spec as p4 <specName> -i
+// would, returning the message p4 prints. Failures are returned as errors.
+func (s *Server) SaveTxt(specName string, specContents map[string]string, args ...string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := s.now()
+	switch specName {
+	case "change":
+		return s.saveChange(specContents, args)
+	case "client":
+		name := specContents["Client"]
+		if name == "" {
+			return "", errors.New("Error in client specification.\nMissing required field 'Client'.\n")
+		}
+		c, ok := s.clients[name]
+		if !ok {
+			c = &client{name: name, owner: s.user()}
+		}
+		if owner := specContents["Owner"]; owner != "" {
+			c.owner = owner
+		}
+		c.host = specContents["Host"]
+		c.root = specContents["Root"]
+		c.desc = specContents["Description"]
+		c.options = specContents["Options"]
+		c.view = specLines(specContents["View"])
+		c.update, c.access = now, now
+		s.clients[name] = c
+		return fmt.Sprintf("Client %s saved.\n", name), nil
+	case "job":
+		name := specContents["Job"]
+		if name == "" || name == "new" {
+			for name == "" || name == "new" || s.jobs[name] != nil {
+				s.lastJob++
+				name = fmt.Sprintf("job%06d", s.lastJob)
+			}
+		}
+		status := specContents["Status"]
+		if status == "" {
+			status = "open"
+		}
+		user := specContents["User"]
+		if user == "" {
+			user = s.user()
+		}
+		s.jobs[name] = &job{name: name, status: status, user: user, desc: specContents["Description"], date: now}
+		return fmt.Sprintf("Job %s saved.\n", name), nil
+	case "user":
+		name := specContents["User"]
+		if name == "" {
+			return "", errors.New("Error in user specification.\nMissing required field 'User'.\n")
+		}
+		u, ok := s.users[name]
+		if !ok {
+			u = &user{name: name, userType: "standard", access: now}
+		}
+		u.email = specContents["Email"]
+		u.fullName = specContents["FullName"]
+		if t := specContents["Type"]; t != "" {
+			u.userType = t
+		}
+		u.update = now
+		s.users[name] = u
+		return fmt.Sprintf("User %s saved.\n", name), nil
+	}
+	return "", fmt.Errorf("p4fake doesn't support p4 %s -i", specName)
+}
+
+// saveChange creates or updates a pending change, moving the open files
+// listed in it from the default change and fixing any jobs
+func (s *Server) saveChange(spec map[string]string, args []string) (string, error) {
+	var c *change
+	created := false
+	if spec["Change"] == "" || spec["Change"] == "new" {
+		c = s.newChange(spec["Description"])
+		created = true
+	} else {
+		n, _ := strconv.Atoi(spec["Change"])
+		c = s.changes[n]
+		if c == nil {
+			return "", fmt.Errorf("Change %s unknown.\n", spec["Change"])
+		}
+		force := false
+		for _, a := range args {
+			force = force || a == "-f" || a == "-u"
+		}
+		if c.status == "submitted" && !force {
+			return "", fmt.Errorf("Change %d has been submitted and can only be updated with -f or -u.\n", n)
+		}
+		c.desc = spec["Description"]
+	}
+	for _, job := range specLines(spec["Jobs"]) {
+		if err := s.addFix(strings.Fields(job)[0], c); err != nil {
+			return "", fmt.Errorf("%v\n", err)
+		}
+	}
+	if c.status == "pending" {
+		files := map[string]bool{}
+		for _, f := range specLines(spec["Files"]) {
+			files[strings.Fields(f)[0]] = true
+		}
+		for _, of := range s.opened {
+			if of.client != c.client {
+				continue
+			}
+			if files[of.depotFile] {
+				of.change = c.number
+			} else if of.change == c.number {
+				of.change = 0
+			}
+		}
+	}
+	if !created {
+		return fmt.Sprintf("Change %d updated.\n", c.number), nil
+	}
+	if n := len(s.changeFiles(c)); n > 0 {
+		return fmt.Sprintf("Change %d created with %d open file(s).\n", c.number, n), nil
+	}
+	return fmt.Sprintf("Change %d created.\n", c.number), nil
+}
@@ -0,0 +1,213 @@
+// Package p4test starts throwaway Perforce servers for tests. Each server
+// has its own root in a temporary directory and is reached with an rsh
+// port, so no daemon is left running and tests can run in parallel.
+package p4test
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	p4 "github.com/rcowham/go-libp4"
+)
+
+// Depot is a depot created by NewServer
+type Depot struct {
+	Name        string
+	Type        string // local or stream, default local
+	StreamDepth int    // for stream depots, default 1
+}
+
+// Options for NewServer. The zero value gives an ASCII, case sensitive
+// server with the default depot and a client of it.
+type Options struct {
+	P4D             string   // p4d binary, default p4d from the PATH
+	Unicode         bool     // run the server in unicode mode (p4d -xi)
+	CaseInsensitive bool     // run the server case insensitive (p4d -C1)
+	User            string   // user the P4 runs as, who is the super user, default testuser
+	Client          string   // client created for the P4, default test_ws
+	Users           []string // other users to create
+	Depots          []Depot  // depots to create
+	Streams         []string // mainline streams to create, e.g. //stream/main. The client uses the first.
+
+	// P4Options are added to those NewServer gives the P4
+	P4Options []p4.Option
+}
+
+// Server is a test server, with the P4 configured to use it
+type Server struct {
+	*p4.P4
+	Root       string // server root
+	ClientRoot string // root of the client, which the P4 runs commands in
+	Port       string
+	User       string
+	Client     string
+}
+
+// NewServer creates a server for a test, skipping the test if p4d or p4
+// can't be found. Its files are removed when the test finishes.
+func NewServer(t testing.TB, opts Options) *Server {
+	t.Helper()
+	p4d := opts.P4D
+	if p4d == "" {
+		p4d = "p4d"
+	}
+	p4d, err := exec.LookPath(p4d)
+	if err != nil {
+		t.Skipf("p4d not found: %v", err)
+	}
+	if _, err := exec.LookPath("p4"); err != nil {
+		t.Skipf("p4 not found: %v", err)
+	}
+	if opts.User == "" {
+		opts.User = "testuser"
+	}
+	if opts.Client == "" {
+		opts.Client = "test_ws"
+	}
+
+	dir := t.TempDir()
+	s := &Server{
+		Root:       filepath.Join(dir, "server"),
+		ClientRoot: filepath.Join(dir, "client"),
+		User:       opts.User,
+		Client:     opts.Client,
+	}
+	for _, d := range []string{s.Root, s.ClientRoot} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", d, err)
+		}
+	}
+	p4dFlags := []string{"-r", s.Root}
+	if opts.CaseInsensitive {
+		p4dFlags = append(p4dFlags, "-C1")
+	}
+	initFlags := []string{"-xu"}
+	if opts.Unicode {
+		initFlags = append(initFlags, "-xi")
+	}
+	for _, f := range initFlags {
+		cmd := exec.Command(p4d, append(p4dFlags, f)...)
+		var out bytes.Buffer
+		cmd.Stdout = &out
+		cmd.Stderr = &out
+		if err := cmd.Run(); err != nil {
+			t.Fatalf("Failed to run %s %s: %v\n%s", p4d, f, err, out.String())
+		}
+	}
+	s.Port = fmt.Sprintf(`rsh:%s -r "%s" -L log -vserver=3 -i`, p4d, s.Root)
+	if opts.CaseInsensitive {
+		s.Port += " -C1"
+	}
+
+	p4opts := []p4.Option{
+		p4.WithDir(s.ClientRoot),
+		// Keep settings and tickets from the environment out of the test
+		p4.WithEnv("P4CONFIG=", "P4ENVIRO="+filepath.Join(dir, ".p4enviro"),
+			"P4TICKETS="+filepath.Join(dir, ".p4tickets"), "P4TRUST="+filepath.Join(dir, ".p4trust")),
+	}
+	if opts.Unicode {
+		p4opts = append(p4opts, p4.WithCharset("utf8"))
+	}
+	s.P4 = p4.NewP4Params(s.Port, s.User, s.Client, append(p4opts, opts.P4Options...)...)
+	if err := s.seed(opts); err != nil {
+		t.Fatalf("Failed to set up server in %s: %v", dir, err)
+	}
+	return s
+}
+
+// seed creates the users, depots, streams and client
+func (s *Server) seed(opts Options) error {
+	for _, name := range append([]string{s.User}, opts.Users...) {
+		u := p4.User{User: name, Email: name + "@example.com", FullName: name}
+		if _, err := p4.SaveUser(s.P4, u, "-f"); err != nil {
+			return err
+		}
+	}
+	depots := append([]Depot{}, opts.Depots...)
+	for _, stream := range opts.Streams {
+		name := streamDepot(stream)
+		found := false
+		for _, d := range depots {
+			found = found || d.Name == name
+		}
+		if !found {
+			depots = append(depots, Depot{Name: name, Type: "stream"})
+		}
+	}
+	for _, d := range depots {
+		spec := map[string]string{
+			"Depot":       d.Name,
+			"Type":        d.Type,
+			"Map":         d.Name + "/...",
+			"Description": "Created by p4test.",
+		}
+		if d.Type == "" {
+			spec["Type"] = "local"
+		}
+		if d.Type == "stream" {
+			depth := d.StreamDepth
+			if depth <= 0 {
+				depth = 1
+			}
+			spec["StreamDepth"] = "//" + d.Name + strings.Repeat("/1", depth)
+		}
+		if _, err := s.P4.SaveTxt("depot", spec); err != nil {
+			return err
+		}
+	}
+	for _, stream := range opts.Streams {
+		spec := map[string]string{
+			"Stream":      stream,
+			"Owner":       s.User,
+			"Name":        path.Base(stream),
+			"Parent":      "none",
+			"Type":        "mainline",
+			"Options":     "allsubmit unlocked notoparent nofromparent mergedown",
+			"Description": "Created by p4test.",
+			"Paths":       "share ...",
+		}
+		if _, err := s.P4.SaveTxt("stream", spec); err != nil {
+			return err
+		}
+	}
+	client := map[string]string{
+		"Client":      s.Client,
+		"Owner":       s.User,
+		"Root":        s.ClientRoot,
+		"Options":     "allwrite noclobber nocompress unlocked nomodtime normdir",
+		"LineEnd":     "local",
+		"Description": "Created by p4test.",
+	}
+	if len(opts.Streams) > 0 {
+		client["Stream"] = opts.Streams[0]
+	} else {
+		client["View"] = fmt.Sprintf("//depot/... //%s/...\n", s.Client)
+	}
+	_, err := s.P4.SaveTxt("client", client)
+	return err
+}
+
+// streamDepot returns the depot of a stream, e.g. stream for //stream/main
+func streamDepot(stream string) string {
+	return strings.SplitN(strings.TrimPrefix(stream, "//"), "/", 2)[0]
+}
+
+// WriteFile writes a file in the client, creating any directories needed,
+// and returns its local path
+func (s *Server) WriteFile(t testing.TB, name string, contents string) string {
+	t.Helper()
+	fname := filepath.Join(s.ClientRoot, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(fname), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fname, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return fname
+}
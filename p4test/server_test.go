@@ -0,0 +1,56 @@
+package p4test
+
+import (
+	"path/filepath"
+	"testing"
+
+	p4 "github.com/rcowham/go-libp4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInfo(t *testing.T) {
+	t.Parallel()
+	s := NewServer(t, Options{})
+	info, err := s.Info()
+	assert.NoError(t, err)
+	assert.Equal(t, "unknown", info.ServerAddress)
+	assert.Equal(t, "test_ws", info.ClientName)
+	assert.False(t, info.CaseInsensitive())
+	assert.NotEmpty(t, info.ServerRoot)
+	assert.Equal(t, "P4D", info.ServerVersion.Product)
+}
+
+func TestAdd(t *testing.T) {
+	t.Parallel()
+	s := NewServer(t, Options{Streams: []string{"//stream/main"}})
+	fname := s.WriteFile(t, "file1", "Some text")
+
+	result, err := s.Run([]string{"add", "file1"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(result))
+	assert.Equal(t, "//stream/main/file1", result[0]["depotFile"])
+
+	result, err = s.Run([]string{"opened", "file1"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(result))
+	assert.Equal(t, "//stream/main/file1", result[0]["depotFile"])
+	assert.Equal(t, "//test_ws/file1", result[0]["clientFile"])
+	assert.Equal(t, "default", result[0]["change"])
+
+	result, err = s.Run([]string{"where", "file1"})
+	assert.NoError(t, err)
+	assert.Equal(t, fname, filepath.Clean(result[0]["path"].(string)))
+}
+
+func TestServerModes(t *testing.T) {
+	t.Parallel()
+	s := NewServer(t, Options{Unicode: true, CaseInsensitive: true, Users: []string{"alice", "bob"}})
+	info, err := p4.RunInfo(s, nil)
+	assert.NoError(t, err)
+	assert.True(t, info.CaseInsensitive())
+	assert.True(t, info.Unicode)
+
+	result, err := s.Run([]string{"users"})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(result))
+}
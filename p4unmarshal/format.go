@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	p4 "github.com/rcowham/go-libp4"
+	"gopkg.in/yaml.v3"
+)
+
+// writeRecords writes records in a format, with only the given fields if any
+func writeRecords(w io.Writer, format string, records []p4.Record, fields []string) error {
+	values := make([]map[string]interface{}, 0, len(records))
+	for _, r := range records {
+		values = append(values, selectFields(r, fields))
+	}
+	switch format {
+	case "json":
+		b, err := json.MarshalIndent(values, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", b)
+		return err
+	case "jsonl":
+		enc := json.NewEncoder(w)
+		for _, v := range values {
+			if err := enc.Encode(v); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "yaml":
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(values); err != nil {
+			return err
+		}
+		return enc.Close()
+	case "ztag":
+		return writeZtag(w, values)
+	case "table":
+		return writeTable(w, values, fields)
+	}
+	return fmt.Errorf("Unknown format '%s', use json, jsonl, yaml, ztag or table", format)
+}
+
+// selectFields returns the fields of a record, converting values which
+// can't be written as JSON or YAML, such as complex numbers, to strings
+func selectFields(r p4.Record, fields []string) map[string]interface{} {
+	m := map[string]interface{}{}
+	if len(fields) == 0 {
+		for k, v := range r {
+			m[k] = plainValue(v)
+		}
+		return m
+	}
+	for _, f := range fields {
+		if v, ok := r[f]; ok {
+			m[f] = plainValue(v)
+		}
+	}
+	return m
+}
+
+func plainValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case complex128:
+		return fmt.Sprint(v)
+	case []byte:
+		return string(v)
+	case []interface{}:
+		list := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			list = append(list, plainValue(item))
+		}
+		return list
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			m[k] = plainValue(item)
+		}
+		return m
+	}
+	return v
+}
+
+// sortedKeys returns the keys of a record in order, with numbered fields
+// such as View2 before View10
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keyLess(keys[i], keys[j]) })
+	return keys
+}
+
+func keyLess(a string, b string) bool {
+	pa, na := splitIndex(a)
+	pb, nb := splitIndex(b)
+	if pa != pb || na < 0 || nb < 0 {
+		return a < b
+	}
+	return na < nb
+}
+
+// splitIndex splits a key such as View10 into View and 10, or -1 if it isn't numbered
+func splitIndex(key string) (string, int) {
+	prefix := strings.TrimRight(key, "0123456789")
+	if prefix == key {
+		return key, -1
+	}
+	n, err := strconv.Atoi(key[len(prefix):])
+	if err != nil {
+		return key, -1
+	}
+	return prefix, n
+}
+
+// writeZtag writes records as p4 -ztag does, with a blank line after each
+func writeZtag(w io.Writer, values []map[string]interface{}) error {
+	for _, m := range values {
+		for _, k := range sortedKeys(m) {
+			v := fmt.Sprint(m[k])
+			if _, err := fmt.Fprintf(w, "... %s %s\n", k, strings.TrimSuffix(v, "\n")); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintln(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeTable writes records as aligned columns, one row per record. The
+// columns are the fields given, or every field found in the records.
+func writeTable(w io.Writer, values []map[string]interface{}, fields []string) error {
+	if len(fields) == 0 {
+		all := map[string]interface{}{}
+		for _, m := range values {
+			for k := range m {
+				all[k] = nil
+			}
+		}
+		fields = sortedKeys(all)
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(fields, "\t"))
+	for _, m := range values {
+		row := make([]string, 0, len(fields))
+		for _, f := range fields {
+			s := ""
+			if v, ok := m[f]; ok {
+				s = fmt.Sprint(v)
+			}
+			// Keep each record on one line
+			row = append(row, strings.ReplaceAll(strings.TrimSuffix(s, "\n"), "\n", `\n`))
+		}
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
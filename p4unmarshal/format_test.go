@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	p4 "github.com/rcowham/go-libp4"
+	"github.com/stretchr/testify/assert"
+)
+
+func readRecords(t *testing.T, fname string) []p4.Record {
+	data, err := os.ReadFile(fname)
+	assert.NoError(t, err)
+	records, err := p4.DecodeRecords(data)
+	assert.NoError(t, err)
+	return records
+}
+
+func TestWriteRecords(t *testing.T) {
+	records := readRecords(t, "job_failed.bin")
+	tests := []struct {
+		format string
+		fields []string
+		want   string
+	}{
+		{"jsonl", nil, `{"code":"error","data":"Invalid marshalled data supplied as input.\n","generic":34,"severity":3}
+{"code":"error","data":"Error in job specification.\nMissing required field 'Job'.\n","generic":4,"severity":3}
+`},
+		{"json", []string{"severity", "code"}, `[
+  {
+    "code": "error",
+    "severity": 3
+  },
+  {
+    "code": "error",
+    "severity": 3
+  }
+]
+`},
+		{"yaml", []string{"code", "generic"}, `- code: error
+  generic: 34
+- code: error
+  generic: 4
+`},
+		{"ztag", []string{"generic", "severity"}, `... generic 34
+... severity 3
+
+... generic 4
+... severity 3
+
+`},
+		{"table", []string{"severity", "data"}, `severity  data
+3         Invalid marshalled data supplied as input.
+3         Error in job specification.\nMissing required field 'Job'.
+`},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		assert.NoError(t, writeRecords(&buf, tt.format, records, tt.fields), tt.format)
+		assert.Equal(t, tt.want, buf.String(), tt.format)
+	}
+	assert.Error(t, writeRecords(&bytes.Buffer{}, "xml", records, nil))
+}
+
+func TestSortedKeys(t *testing.T) {
+	m := map[string]interface{}{"View10": 1, "View2": 1, "Client": 1, "View": 1, "Access": 1}
+	assert.Equal(t, []string{"Access", "Client", "View", "View2", "View10"}, sortedKeys(m))
+}
+
+func TestConvert(t *testing.T) {
+	var text bytes.Buffer
+	assert.NoError(t, convert(&text, "decode", []string{"job_failed.bin"}))
+	assert.Contains(t, text.String(), `"generic":34`)
+
+	f := filepath.Join(t.TempDir(), "job_failed.jsonl")
+	assert.NoError(t, os.WriteFile(f, text.Bytes(), 0644))
+	var bin bytes.Buffer
+	assert.NoError(t, convert(&bin, "encode", []string{f}))
+	records, err := p4.DecodeRecords(bin.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, readRecords(t, "job_failed.bin"), records)
+}
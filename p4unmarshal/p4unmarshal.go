@@ -1,23 +1,96 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"io"
 	"os"
+	"strings"
 
 	p4 "github.com/rcowham/go-libp4"
 )
 
-func main() {
-	p4 := p4.NewP4()
-	result, err := p4.Run(os.Args[1:])
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [p4 args...]\n", os.Args[0])
+	fmt.Fprintf(flag.CommandLine.Output(), "       %s encode|decode [file]\n\n", os.Args[0])
+	fmt.Fprintf(flag.CommandLine.Output(), "Runs p4 -G with the args, or reads saved -G output, and prints the results.\n")
+	fmt.Fprintf(flag.CommandLine.Output(), "decode converts -G output to typed JSONL, and encode converts it back.\n\n")
+	flag.PrintDefaults()
+}
+
+// convert reads -G output or typed JSONL from a file, or stdin if none is
+// given, and writes it to w in the other form
+func convert(w io.Writer, cmd string, args []string) error {
+	in := io.Reader(os.Stdin)
+	if len(args) > 0 && args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	if cmd == "encode" {
+		records, err := p4.DecodeJSONL(in)
+		if err != nil {
+			return err
+		}
+		return p4.EncodeRecords(w, records)
+	}
+	data, err := io.ReadAll(in)
 	if err != nil {
-		fmt.Printf("Error: %v %v\n", err, result)
+		return err
 	}
-	for _, r := range result {
-		for k, v := range r {
-			fmt.Printf("%v: %v\n", k, v)
+	records, err := p4.DecodeRecords(data)
+	if err != nil {
+		return err
+	}
+	return p4.EncodeJSONL(w, records)
+}
+
+func main() {
+	if len(os.Args) > 1 && (os.Args[1] == "encode" || os.Args[1] == "decode") {
+		if err := convert(os.Stdout, os.Args[1], os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
 		}
-		fmt.Printf("\n")
+		return
+	}
+	format := flag.String("format", "ztag", "output format: json, jsonl, yaml, ztag or table")
+	input := flag.String("input", "", "read -G output from this file, or - for stdin, instead of running p4")
+	fields := flag.String("fields", "", "comma separated fields to output, default all")
+	flag.Usage = usage
+	flag.Parse()
+
+	var data []byte
+	var err error
+	switch {
+	case *input == "-":
+		data, err = io.ReadAll(os.Stdin)
+	case *input != "":
+		data, err = os.ReadFile(*input)
+	case flag.NArg() == 0:
+		usage()
+		os.Exit(2)
+	default:
+		_, data, err = p4.NewP4().RunMarshalled(flag.Args())
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	}
+	records, derr := p4.DecodeRecords(data)
+	if derr != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", derr)
+	}
+	var fieldList []string
+	if *fields != "" {
+		fieldList = strings.Split(*fields, ",")
+	}
+	if ferr := writeRecords(os.Stdout, *format, records, fieldList); ferr != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", ferr)
+		os.Exit(2)
+	}
+	if err != nil || derr != nil {
+		os.Exit(1)
 	}
-	fmt.Printf("\nResult: %v\n", result)
 }
@@ -0,0 +1,188 @@
+package p4
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Pool is a Runner which limits how many commands run at once through
+// another Runner, usually a *P4 shared by many goroutines. As well as an
+// overall limit, each class of command (by default the command name, e.g.
+// "sync") can have its own limit. Commands which can't run yet wait in a
+// queue and are started in the order they arrived as soon as there is room.
+type Pool struct {
+	runner      Runner
+	limit       int
+	classLimits map[string]int
+	classify    func(args []string) string
+
+	mutex   sync.Mutex
+	running int
+	queue   []*poolWaiter
+	stats   map[string]*PoolClassStats
+}
+
+type poolWaiter struct {
+	class string
+	ready chan struct{}
+}
+
+// PoolClassStats are the counts and queue wait times for a class of command
+type PoolClassStats struct {
+	Running   int
+	Waiting   int
+	Started   int64         // commands started since the pool was created
+	Queued    int64         // commands which had to wait
+	TotalWait time.Duration // total time commands spent waiting
+	MaxWait   time.Duration // longest time a command waited
+}
+
+// PoolStats is a snapshot of a Pool's activity
+type PoolStats struct {
+	PoolClassStats
+	Classes map[string]PoolClassStats
+}
+
+// NewPool returns a Pool running at most limit commands at once through
+// runner. A limit of zero or less means no overall limit.
+func NewPool(runner Runner, limit int) *Pool {
+	return &Pool{
+		runner:      runner,
+		limit:       limit,
+		classLimits: map[string]int{},
+		classify:    commandName,
+		stats:       map[string]*PoolClassStats{},
+	}
+}
+
+// SetClassLimit sets how many commands of a class may run at once
+func (p *Pool) SetClassLimit(class string, limit int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.classLimits[class] = limit
+	p.admit()
+}
+
+// SetClassifier sets the function which returns the class of a command
+// from its args. The default is the command name.
+func (p *Pool) SetClassifier(classify func(args []string) string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.classify = classify
+}
+
+// Run runs a command once the limits allow
+func (p *Pool) Run(args []string) ([]map[interface{}]interface{}, error) {
+	release := p.acquire(args)
+	defer release()
+	return p.runner.Run(args)
+}
+
+// SaveTxt saves a spec once the limits allow, if the pool's Runner can save specs
+func (p *Pool) SaveTxt(specName string, specContents map[string]string, args ...string) (string, error) {
+	sr, ok := p.runner.(SpecRunner)
+	if !ok {
+		return "", fmt.Errorf("Runner %T can't save specs", p.runner)
+	}
+	release := p.acquire(append([]string{specName, "-i"}, args...))
+	defer release()
+	return sr.SaveTxt(specName, specContents, args...)
+}
+
+// Stats returns the current activity and queue wait times of the pool
+func (p *Pool) Stats() PoolStats {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	ps := PoolStats{Classes: map[string]PoolClassStats{}}
+	for class, s := range p.stats {
+		ps.Classes[class] = *s
+		ps.Running += s.Running
+		ps.Waiting += s.Waiting
+		ps.Started += s.Started
+		ps.Queued += s.Queued
+		ps.TotalWait += s.TotalWait
+		if s.MaxWait > ps.MaxWait {
+			ps.MaxWait = s.MaxWait
+		}
+	}
+	return ps
+}
+
+// acquire waits until a command may run, returning a function to call once it is done
+func (p *Pool) acquire(args []string) func() {
+	start := time.Now()
+	p.mutex.Lock()
+	class := p.classify(args)
+	s := p.classStats(class)
+	w := &poolWaiter{class: class, ready: make(chan struct{})}
+	p.queue = append(p.queue, w)
+	s.Waiting++
+	p.admit()
+	select {
+	case <-w.ready:
+		p.mutex.Unlock()
+	default:
+		s.Queued++
+		p.mutex.Unlock()
+		<-w.ready
+		wait := time.Since(start)
+		p.mutex.Lock()
+		s.TotalWait += wait
+		if wait > s.MaxWait {
+			s.MaxWait = wait
+		}
+		p.mutex.Unlock()
+	}
+	return func() {
+		p.mutex.Lock()
+		defer p.mutex.Unlock()
+		p.running--
+		s.Running--
+		p.admit()
+	}
+}
+
+func (p *Pool) classStats(class string) *PoolClassStats {
+	s, ok := p.stats[class]
+	if !ok {
+		s = &PoolClassStats{}
+		p.stats[class] = s
+	}
+	return s
+}
+
+// hasRoom reports whether a command of class may start now
+func (p *Pool) hasRoom(class string) bool {
+	if p.limit > 0 && p.running >= p.limit {
+		return false
+	}
+	limit, ok := p.classLimits[class]
+	return !ok || limit <= 0 || p.classStats(class).Running < limit
+}
+
+func (p *Pool) start(class string) {
+	s := p.classStats(class)
+	p.running++
+	s.Running++
+	s.Started++
+}
+
+// admit starts waiting commands, in the order they arrived, while there is
+// room. A command whose class is at its limit doesn't hold up other classes.
+func (p *Pool) admit() {
+	queue := p.queue[:0]
+	for _, w := range p.queue {
+		if p.hasRoom(w.class) {
+			p.start(w.class)
+			p.classStats(w.class).Waiting--
+			close(w.ready)
+		} else {
+			queue = append(queue, w)
+		}
+	}
+	for i := len(queue); i < len(p.queue); i++ {
+		p.queue[i] = nil
+	}
+	p.queue = queue
+}
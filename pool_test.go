@@ -0,0 +1,144 @@
+package p4
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// blockingRunner records the commands it is running, each of which waits
+// until released
+type blockingRunner struct {
+	mutex   sync.Mutex
+	started []string
+	release map[string]chan struct{}
+}
+
+func newBlockingRunner() *blockingRunner {
+	return &blockingRunner{release: map[string]chan struct{}{}}
+}
+
+func (br *blockingRunner) releaseChan(name string) chan struct{} {
+	br.mutex.Lock()
+	defer br.mutex.Unlock()
+	if _, ok := br.release[name]; !ok {
+		br.release[name] = make(chan struct{})
+	}
+	return br.release[name]
+}
+
+func (br *blockingRunner) Run(args []string) ([]map[interface{}]interface{}, error) {
+	name := args[len(args)-1]
+	br.mutex.Lock()
+	br.started = append(br.started, name)
+	br.mutex.Unlock()
+	<-br.releaseChan(name)
+	return []map[interface{}]interface{}{{"code": "stat", "name": name}}, nil
+}
+
+func (br *blockingRunner) startedCommands() []string {
+	br.mutex.Lock()
+	defer br.mutex.Unlock()
+	return append([]string{}, br.started...)
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	assert.Eventually(t, cond, 5*time.Second, time.Millisecond)
+}
+
+func TestPoolLimits(t *testing.T) {
+	br := newBlockingRunner()
+	pool := NewPool(br, 3)
+	pool.SetClassLimit("sync", 1)
+	var wg sync.WaitGroup
+	run := func(args ...string) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := pool.Run(args)
+			assert.Nil(t, err)
+			assert.Equal(t, args[len(args)-1], res[0]["name"])
+		}()
+	}
+
+	run("sync", "sync1")
+	waitFor(t, func() bool { return len(br.startedCommands()) == 1 })
+	run("sync", "sync2")
+	waitFor(t, func() bool { return pool.Stats().Waiting == 1 })
+	// sync2 is waiting for sync1 but doesn't hold up other commands
+	run("fstat", "fstat1")
+	waitFor(t, func() bool { return len(br.startedCommands()) == 2 })
+	run("-p", "perforce:1666", "fstat", "fstat2")
+	waitFor(t, func() bool { return len(br.startedCommands()) == 3 })
+	run("fstat", "fstat3")
+	waitFor(t, func() bool { return pool.Stats().Waiting == 2 })
+
+	stats := pool.Stats()
+	assert.Equal(t, 3, stats.Running)
+	assert.Equal(t, PoolClassStats{Running: 1, Waiting: 1, Started: 1, Queued: 1}, stats.Classes["sync"])
+	assert.Equal(t, PoolClassStats{Running: 2, Waiting: 1, Started: 2, Queued: 1}, stats.Classes["fstat"])
+
+	// sync2 is next in the queue, but there is only room for fstat3
+	close(br.releaseChan("fstat1"))
+	waitFor(t, func() bool { return len(br.startedCommands()) == 4 })
+	assert.Equal(t, []string{"sync1", "fstat1", "fstat2", "fstat3"}, br.startedCommands())
+	close(br.releaseChan("sync1"))
+	waitFor(t, func() bool { return len(br.startedCommands()) == 5 })
+	close(br.releaseChan("sync2"))
+	close(br.releaseChan("fstat2"))
+	close(br.releaseChan("fstat3"))
+	wg.Wait()
+
+	stats = pool.Stats()
+	assert.Equal(t, 0, stats.Running)
+	assert.Equal(t, 0, stats.Waiting)
+	assert.Equal(t, int64(5), stats.Started)
+	assert.Equal(t, int64(2), stats.Queued)
+	assert.True(t, stats.MaxWait > 0)
+	assert.True(t, stats.TotalWait >= stats.MaxWait)
+}
+
+func TestPoolOrder(t *testing.T) {
+	br := newBlockingRunner()
+	pool := NewPool(br, 1)
+	pool.SetClassifier(func(args []string) string { return "all" })
+	var wg sync.WaitGroup
+	for i, name := range []string{"first", "second", "third", "fourth"} {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			_, err := pool.Run([]string{"files", name})
+			assert.Nil(t, err)
+		}(name)
+		waitFor(t, func() bool { return pool.Stats().Waiting == i })
+	}
+	for _, name := range []string{"first", "second", "third", "fourth"} {
+		close(br.releaseChan(name))
+	}
+	wg.Wait()
+	assert.Equal(t, []string{"first", "second", "third", "fourth"}, br.startedCommands())
+	assert.Equal(t, []string{"all"}, keys(pool.Stats().Classes))
+}
+
+func keys(m map[string]PoolClassStats) []string {
+	ks := []string{}
+	for k := range m {
+		ks = append(ks, k)
+	}
+	return ks
+}
+
+func TestPoolSaveTxt(t *testing.T) {
+	fp4 := FakeP4Runner{}
+	fp4.On("SaveTxt", "job", map[string]string{"Job": "new"}, []string(nil)).Return("Job job000001 saved.\n", nil)
+	pool := NewPool(&fp4, 1)
+	res, err := pool.SaveTxt("job", map[string]string{"Job": "new"})
+	assert.Nil(t, err)
+	assert.Equal(t, "Job job000001 saved.\n", res)
+	assert.Equal(t, int64(1), pool.Stats().Classes["job"].Started)
+
+	_, err = NewPool(newBlockingRunner(), 1).SaveTxt("job", map[string]string{})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,171 @@
+package p4
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// RecordingIndex is the file in a recording directory listing the commands saved
+const RecordingIndex = "recordings.json"
+
+// ErrNoRecording is returned by a ReplayRunner for commands it has no recording of
+var ErrNoRecording = errors.New("No recording of command")
+
+// Recording is a command saved by a RecordingRunner
+type Recording struct {
+	Args  []string `json:"args"`
+	File  string   `json:"file"`            // raw -G output, or typed JSONL if it ends in .jsonl, relative to the recording directory
+	Error string   `json:"error,omitempty"` // error returned by the command, if any
+}
+
+// RecordingRunner is a Runner which runs commands with a P4 and saves each
+// command's args and raw -G output in a directory, so a session against a
+// real server can be served back by a ReplayRunner. Specs saved with SaveTxt
+// are passed through without being recorded.
+type RecordingRunner struct {
+	p4  *P4
+	dir string
+
+	mutex      sync.Mutex
+	recordings []Recording
+}
+
+// NewRecordingRunner returns a RecordingRunner saving commands run with p4
+// in dir, which is created if necessary
+func NewRecordingRunner(p4 *P4, dir string) (*RecordingRunner, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &RecordingRunner{p4: p4, dir: dir}, nil
+}
+
+// Run runs a command and records it. An error saving the recording is
+// returned with the command's results.
+func (rr *RecordingRunner) Run(args []string) ([]map[interface{}]interface{}, error) {
+	results, data, err := rr.p4.RunMarshalled(args)
+	if serr := rr.save(args, data, err); serr != nil && err == nil {
+		err = serr
+	}
+	return results, err
+}
+
+// SaveTxt saves a spec with the P4, without recording it
+func (rr *RecordingRunner) SaveTxt(specName string, specContents map[string]string, args ...string) (string, error) {
+	return rr.p4.SaveTxt(specName, specContents, args...)
+}
+
+// Recordings returns the commands recorded so far
+func (rr *RecordingRunner) Recordings() []Recording {
+	rr.mutex.Lock()
+	defer rr.mutex.Unlock()
+	return append([]Recording{}, rr.recordings...)
+}
+
+// save writes a command's output to a numbered file, e.g. 001-changes.bin,
+// and rewrites the index so it is complete after every command
+func (rr *RecordingRunner) save(args []string, data []byte, err error) error {
+	rr.mutex.Lock()
+	defer rr.mutex.Unlock()
+	name := commandName(args)
+	if name == "" {
+		name = "p4"
+	}
+	rec := Recording{
+		Args: append([]string{}, args...),
+		File: fmt.Sprintf("%03d-%s.bin", len(rr.recordings)+1, name),
+	}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	if werr := os.WriteFile(filepath.Join(rr.dir, rec.File), data, 0644); werr != nil {
+		return fmt.Errorf("Failed to save recording of p4 %s\n%v", args, werr)
+	}
+	rr.recordings = append(rr.recordings, rec)
+	index, jerr := json.MarshalIndent(rr.recordings, "", "  ")
+	if jerr != nil {
+		return jerr
+	}
+	if werr := os.WriteFile(filepath.Join(rr.dir, RecordingIndex), index, 0644); werr != nil {
+		return fmt.Errorf("Failed to save recording index\n%v", werr)
+	}
+	return nil
+}
+
+// ReplayRunner is a Runner which serves commands from recordings saved by a
+// RecordingRunner, matching their args exactly. A command recorded several
+// times is served its recordings in order, with the last one repeated.
+type ReplayRunner struct {
+	dir        string
+	recordings map[string][]Recording
+
+	mutex sync.Mutex
+	next  map[string]int
+}
+
+// NewReplayRunner returns a ReplayRunner serving the recordings in dir
+func NewReplayRunner(dir string) (*ReplayRunner, error) {
+	index, err := os.ReadFile(filepath.Join(dir, RecordingIndex))
+	if err != nil {
+		return nil, err
+	}
+	var recordings []Recording
+	if err := json.Unmarshal(index, &recordings); err != nil {
+		return nil, fmt.Errorf("Failed to read recording index in %s\n%v", dir, err)
+	}
+	rr := &ReplayRunner{dir: dir, recordings: map[string][]Recording{}, next: map[string]int{}}
+	for _, rec := range recordings {
+		key := replayKey(rec.Args)
+		rr.recordings[key] = append(rr.recordings[key], rec)
+	}
+	return rr, nil
+}
+
+// Run returns the results of the next recording of a command, or an error
+// wrapping ErrNoRecording if it wasn't recorded
+func (rr *ReplayRunner) Run(args []string) ([]map[interface{}]interface{}, error) {
+	key := replayKey(args)
+	rr.mutex.Lock()
+	recs := rr.recordings[key]
+	if len(recs) == 0 {
+		rr.mutex.Unlock()
+		return nil, fmt.Errorf("%w: p4 %s", ErrNoRecording, args)
+	}
+	i := rr.next[key]
+	if i < len(recs)-1 {
+		rr.next[key]++
+	}
+	rr.mutex.Unlock()
+
+	rec := recs[i]
+	data, err := os.ReadFile(filepath.Join(rr.dir, rec.File))
+	if err != nil {
+		return nil, err
+	}
+	var results []map[interface{}]interface{}
+	if strings.HasSuffix(rec.File, ".jsonl") {
+		records, jerr := DecodeJSONL(bytes.NewReader(data))
+		for _, r := range records {
+			results = append(results, r.Map())
+		}
+		err = jerr
+	} else {
+		results, err = DecodeResults(data)
+	}
+	if err != nil {
+		return results, err
+	}
+	if rec.Error != "" {
+		return results, errors.New(rec.Error)
+	}
+	return results, nil
+}
+
+func replayKey(args []string) string {
+	return strings.Join(args, "\x00")
+}
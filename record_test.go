@@ -0,0 +1,81 @@
+package p4
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordReplay(t *testing.T) {
+	changes := filepath.Join(testRoot, "..", "testdata", "changes.bin")
+	exe := fakeP4Executable(t, fmt.Sprintf("case \"$*\" in\n*changes*) cat %s ;;\n*) echo 'Perforce client error' >&2 ;;\nesac\n", changes))
+	dir := filepath.Join(t.TempDir(), "session")
+
+	rec, err := NewRecordingRunner(NewP4(WithExecutable(exe)), dir)
+	assert.NoError(t, err)
+	live, err := rec.Run([]string{"changes", "-m3"})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(live))
+	_, err = rec.Run([]string{"fstat", "//depot/..."})
+	assert.Error(t, err)
+
+	recordings := rec.Recordings()
+	assert.Equal(t, []Recording{
+		{Args: []string{"changes", "-m3"}, File: "001-changes.bin"},
+		{Args: []string{"fstat", "//depot/..."}, File: "002-fstat.bin", Error: "Perforce client error\n"},
+	}, recordings)
+	raw, _ := os.ReadFile(changes)
+	saved, _ := os.ReadFile(filepath.Join(dir, "001-changes.bin"))
+	assert.Equal(t, raw, saved)
+
+	replay, err := NewReplayRunner(dir)
+	assert.NoError(t, err)
+	results, err := replay.Run([]string{"changes", "-m3"})
+	assert.NoError(t, err)
+	assert.Equal(t, live, results)
+	_, err = replay.Run([]string{"fstat", "//depot/..."})
+	assert.EqualError(t, err, "Perforce client error\n")
+	_, err = replay.Run([]string{"changes", "-m4"})
+	assert.True(t, errors.Is(err, ErrNoRecording))
+}
+
+func TestReplaySequence(t *testing.T) {
+	dir := t.TempDir()
+	changes, _ := os.ReadFile(filepath.Join(testRoot, "..", "testdata", "changes.bin"))
+	info, _ := os.ReadFile(filepath.Join(testRoot, "..", "testdata", "info.bin"))
+	writeToFile(filepath.Join(dir, "changes.bin"), string(changes))
+	writeToFile(filepath.Join(dir, "info.bin"), string(info))
+	writeToFile(filepath.Join(dir, RecordingIndex), `[
+  {"args": ["changes"], "file": "changes.bin"},
+  {"args": ["changes"], "file": "info.bin"}
+]`)
+
+	replay, err := NewReplayRunner(dir)
+	assert.NoError(t, err)
+	for _, want := range []string{"change", "serverVersion", "serverVersion"} {
+		results, err := replay.Run([]string{"changes"})
+		assert.NoError(t, err)
+		assert.Contains(t, results[0], want)
+	}
+}
+
+func TestReplayJSONL(t *testing.T) {
+	dir := t.TempDir()
+	writeToFile(filepath.Join(dir, "counter.jsonl"), `{"code":"stat","counter":"change","value":"42"}
+{"code":"error","data":"bad\n","generic":17,"severity":3}
+`)
+	writeToFile(filepath.Join(dir, RecordingIndex), `[{"args": ["counter", "change"], "file": "counter.jsonl"}]`)
+
+	replay, err := NewReplayRunner(dir)
+	assert.NoError(t, err)
+	results, err := replay.Run([]string{"counter", "change"})
+	assert.NoError(t, err)
+	assert.Equal(t, []map[interface{}]interface{}{
+		{"code": "stat", "counter": "change", "value": "42"},
+		{"code": "error", "data": "bad\n", "generic": int32(17), "severity": int32(3)},
+	}, results)
+}
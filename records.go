@@ -0,0 +1,192 @@
+package p4
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Record is a result from p4 -G with string keys, with accessors which
+// convert values rather than panicking on a missing key or unexpected type
+type Record map[string]interface{}
+
+// RecordRunner is a Runner which can return Records rather than maps
+type RecordRunner interface {
+	RunRecords(args []string) ([]Record, error)
+}
+
+// NewRecord converts a result as returned by Run to a Record
+func NewRecord(r map[interface{}]interface{}) Record {
+	rec := make(Record, len(r))
+	for k, v := range r {
+		if s, ok := k.(string); ok {
+			rec[s] = v
+		} else {
+			rec[fmt.Sprint(k)] = v
+		}
+	}
+	return rec
+}
+
+// Map converts a record back to a result as returned by Run, with byte
+// strings as strings
+func (r Record) Map() map[interface{}]interface{} {
+	m := make(map[interface{}]interface{}, len(r))
+	for k, v := range r {
+		if b, ok := v.([]byte); ok {
+			v = string(b)
+		}
+		m[k] = v
+	}
+	return m
+}
+
+// Has reports whether the record has a key
+func (r Record) Has(key string) bool {
+	_, ok := r[key]
+	return ok
+}
+
+// Get returns a value as a string, or "" if the key is missing. Numbers
+// are formatted in decimal.
+func (r Record) Get(key string) string {
+	switch v := r[key].(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// GetBytes returns a value as bytes, such as a chunk of a file from p4 print
+func (r Record) GetBytes(key string) []byte {
+	if b, ok := r[key].([]byte); ok {
+		return b
+	}
+	if !r.Has(key) {
+		return nil
+	}
+	return []byte(r.Get(key))
+}
+
+// GetInt returns a value as an integer, whether p4 gave it as a number or a string
+func (r Record) GetInt(key string) (int64, error) {
+	switch v := r[key].(type) {
+	case nil:
+		return 0, fmt.Errorf("No field %s in p4 result", key)
+	case int32:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case int:
+		return int64(v), nil
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(r.Get(key)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to parse %s as an integer\n%v", key, err)
+	}
+	return n, nil
+}
+
+// GetTime returns a time given in seconds since the epoch, as in p4
+// changes, or in the YYYY/MM/DD HH:MM:SS format of specs in local time
+func (r Record) GetTime(key string) (time.Time, error) {
+	s := strings.TrimSpace(r.Get(key))
+	if s == "" {
+		return time.Time{}, fmt.Errorf("No field %s in p4 result", key)
+	}
+	if n, err := r.GetInt(key); err == nil {
+		return time.Unix(n, 0), nil
+	}
+	for _, layout := range []string{"2006/01/02 15:04:05", "2006/01/02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("Failed to parse %s '%s' as a time", key, s)
+}
+
+// Indexed returns the values of numbered fields such as View0, View1...
+func (r Record) Indexed(prefix string) []string {
+	vals := []string{}
+	for i := 0; ; i++ {
+		k := prefix + strconv.Itoa(i)
+		if !r.Has(k) {
+			return vals
+		}
+		vals = append(vals, r.Get(k))
+	}
+}
+
+// Code returns the code of the record: stat, info, text, binary or error
+func (r Record) Code() string {
+	return r.Get("code")
+}
+
+// Err returns the error reported by an error record, or nil
+func (r Record) Err() error {
+	if r.Code() != "error" {
+		return nil
+	}
+	return parseError(map[interface{}]interface{}{"data": r.Get("data")})
+}
+
+// DecodeRecords decodes the raw -G output of a command into Records.
+// Values are kept as []byte if WithBytes is given.
+func DecodeRecords(data []byte, opts ...DecodeOption) ([]Record, error) {
+	d := NewDecoder(data, append([]DecodeOption{WithStringKeys()}, opts...)...)
+	records := []Record{}
+	for {
+		v, err := d.Decode()
+		if err != nil || v == nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return records, err
+		}
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return records, fmt.Errorf("Failed to decode p4 output, expected dict but got %T", v)
+		}
+		records = append(records, Record(m))
+	}
+}
+
+// RunRecords runs p4 command and returns Records. Values are given as
+// bytes, as DecodeRecords with WithBytes does, so that binary output of
+// p4 print is intact. Go strings hold any bytes, so the results of Run
+// are converted rather than decoded again.
+func (p4 *P4) RunRecords(args []string) ([]Record, error) {
+	results, err := p4.Run(args)
+	records := make([]Record, 0, len(results))
+	for _, r := range results {
+		rec := NewRecord(r)
+		for k, v := range rec {
+			if s, ok := v.(string); ok {
+				rec[k] = []byte(s)
+			}
+		}
+		records = append(records, rec)
+	}
+	return records, err
+}
+
+// RunRecords runs p4 args... with p4r and returns Records, using
+// RunRecords if p4r is a RecordRunner
+func RunRecords(p4r Runner, args []string) ([]Record, error) {
+	if rr, ok := p4r.(RecordRunner); ok {
+		return rr.RunRecords(args)
+	}
+	results, err := p4r.Run(args)
+	records := make([]Record, 0, len(results))
+	for _, r := range results {
+		records = append(records, NewRecord(r))
+	}
+	return records, err
+}
@@ -0,0 +1,113 @@
+package p4
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordAccessors(t *testing.T) {
+	r := NewRecord(map[interface{}]interface{}{
+		"code":     "stat",
+		"change":   "123",
+		"time":     "1612369118",
+		"Date":     "2021/02/03 16:18:38",
+		"severity": int32(3),
+		"fileSize": int64(1 << 40),
+		"data":     []byte{0, 1, 2},
+		"View0":    "//depot/... //ws/...",
+		"View1":    "-//depot/tmp/... //ws/tmp/...",
+		int32(1):   "odd key",
+	})
+	assert.True(t, r.Has("change"))
+	assert.False(t, r.Has("client"))
+	assert.Equal(t, "stat", r.Code())
+	assert.Equal(t, "", r.Get("client"))
+	assert.Equal(t, "3", r.Get("severity"))
+	assert.Equal(t, "odd key", r.Get("1"))
+	assert.Equal(t, []byte{0, 1, 2}, r.GetBytes("data"))
+	assert.Equal(t, []byte("123"), r.GetBytes("change"))
+	assert.Nil(t, r.GetBytes("client"))
+	assert.Equal(t, []string{"//depot/... //ws/...", "-//depot/tmp/... //ws/tmp/..."}, r.Indexed("View"))
+	assert.Equal(t, []string{}, r.Indexed("Jobs"))
+
+	for key, want := range map[string]int64{"change": 123, "severity": 3, "fileSize": 1 << 40} {
+		n, err := r.GetInt(key)
+		assert.NoError(t, err, key)
+		assert.Equal(t, want, n, key)
+	}
+	_, err := r.GetInt("client")
+	assert.Error(t, err)
+	_, err = r.GetInt("code")
+	assert.Error(t, err)
+
+	tm, err := r.GetTime("time")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1612369118), tm.Unix())
+	tm, err = r.GetTime("Date")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2021, 2, 3, 16, 18, 38, 0, time.Local), tm)
+	_, err = r.GetTime("code")
+	assert.Error(t, err)
+
+	assert.Nil(t, r.Err())
+	assert.EqualError(t, Record{"code": "error", "data": []byte("bad")}.Err(), "P4Error -> bad")
+}
+
+func TestDecodeRecords(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "changes.bin"))
+	assert.NoError(t, err)
+	records, err := DecodeRecords(data)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(records))
+	assert.Equal(t, "3", records[0]["change"])
+	assert.Equal(t, "rcowham", records[1].Get("user"))
+
+	records, err = DecodeRecords(data, WithBytes())
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("3"), records[0]["change"])
+	assert.Equal(t, "3", records[0].Get("change"))
+
+	// Nested dicts have string keys too
+	v, err := NewDecoder([]byte("{s\x01\x00\x00\x00k{i\x01\x00\x00\x00s\x01\x00\x00\x00v00"), WithStringKeys()).Decode()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"k": map[string]interface{}{"1": "v"}}, v)
+}
+
+type recordRunner struct {
+	Runner
+}
+
+func (recordRunner) RunRecords(args []string) ([]Record, error) {
+	return []Record{{"code": "stat", "args": fmt.Sprint(args)}}, nil
+}
+
+func TestRunRecords(t *testing.T) {
+	fp4 := FakeP4Runner{}
+	fp4.On("Run", []string{"changes"}).Return([]map[interface{}]interface{}{{"code": "stat", "change": "1"}}, errors.New("warning"))
+	records, err := RunRecords(&fp4, []string{"changes"})
+	assert.EqualError(t, err, "warning")
+	assert.Equal(t, []Record{{"code": "stat", "change": "1"}}, records)
+
+	records, err = RunRecords(recordRunner{}, []string{"info"})
+	assert.NoError(t, err)
+	assert.Equal(t, "[info]", records[0].Get("args"))
+
+	changes := filepath.Join(testRoot, "..", "testdata", "changes.bin")
+	exe := fakeP4Executable(t, fmt.Sprintf("cat %s\n", changes))
+	records, err = NewP4(WithExecutable(exe)).RunRecords([]string{"changes"})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(records))
+	assert.Equal(t, []byte("1"), records[2]["change"])
+	// The same as decoding the output directly
+	data, err := os.ReadFile(changes)
+	assert.NoError(t, err)
+	want, err := DecodeRecords(data, WithBytes())
+	assert.NoError(t, err)
+	assert.Equal(t, want, records)
+}
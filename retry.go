@@ -0,0 +1,224 @@
+package p4
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Severity of an error result, from its severity field
+const (
+	SeverityEmpty  = 0 // no error
+	SeverityInfo   = 1 // informational message
+	SeverityWarn   = 2 // warning, the command worked
+	SeverityFailed = 3 // the command failed
+	SeverityFatal  = 4 // the command or server connection failed
+)
+
+// Generic codes of error results, from their generic field
+const (
+	GenericNone    = 0  // misc
+	GenericUsage   = 1  // request not consistent with dox
+	GenericUnknown = 2  // using unknown entity
+	GenericContext = 3  // using entity in wrong context
+	GenericIllegal = 4  // trying to do something you can't
+	GenericNotYet  = 5  // something must be corrected first
+	GenericProtect = 6  // protections prevented operation
+	GenericEmpty   = 17 // action returned empty results
+	GenericFault   = 33 // inexplicable program fault
+	GenericClient  = 34 // client side program errors
+	GenericAdmin   = 35 // server administrative action required
+	GenericConfig  = 36 // client configuration inadequate
+	GenericUpgrade = 37 // client or server too old to interact
+	GenericComm    = 38 // communications error
+	GenericTooBig  = 39 // too big to handle
+)
+
+// Messages for errors which are likely to go away if the command is run again
+var reTransient = regexp.MustCompile(`Connect to server failed|TCP (connect|receive|send) .*failed|Too many commands|exceeds MaxCommands|Operation took too long|[Ll]ock wait timeout|Connection reset by peer|Broken pipe|RpcTransport: partial message read|Server is shutting down|WSAECONN`)
+
+// Commands which don't change anything, or give the same result however many
+// times they are run, so are safe to retry
+var idempotentCommands = map[string]bool{
+	"annotate": true, "branches": true, "changes": true, "clients": true, "counters": true,
+	"cstat": true, "depots": true, "describe": true, "diff2": true, "dirs": true,
+	"filelog": true, "files": true, "fixes": true, "fstat": true, "grep": true,
+	"groups": true, "have": true, "info": true, "interchanges": true, "istat": true,
+	"jobs": true, "keys": true, "labels": true, "opened": true, "print": true,
+	"protects": true, "reviews": true, "sizes": true, "streams": true, "sync": true,
+	"users": true, "where": true,
+}
+
+// Spec commands which are read only with -o
+var specCommands = map[string]bool{
+	"branch": true, "change": true, "client": true, "depot": true, "group": true,
+	"job": true, "label": true, "protect": true, "stream": true, "triggers": true,
+	"typemap": true, "user": true, "workspace": true,
+}
+
+// IsIdempotent reports whether a command is read only or safe to run more
+// than once, e.g. fstat, sync or client -o, but not submit
+func IsIdempotent(args []string) bool {
+	cmd := commandName(args)
+	if idempotentCommands[cmd] {
+		return true
+	}
+	if cmd == "login" || specCommands[cmd] {
+		for _, a := range args {
+			if (cmd == "login" && a == "-s") || (specCommands[cmd] && a == "-o") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// getInt returns a numeric field such as generic or severity, which p4 -G
+// gives as an int32, but allow for a string
+func getInt(r map[interface{}]interface{}, key string) (int, bool) {
+	switch v := r[key].(type) {
+	case int32:
+		return int(v), true
+	case int64:
+		return int(v), true
+	case int:
+		return v, true
+	case string:
+		n, err := strconv.Atoi(v)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// IsTransient reports whether a command failed in a way which may work if
+// it is run again, such as a network failure or a server which is too busy
+func IsTransient(results []map[interface{}]interface{}, err error) bool {
+	if err != nil && reTransient.MatchString(err.Error()) {
+		return true
+	}
+	for _, r := range results {
+		if getString(r, "code") != "error" {
+			continue
+		}
+		if generic, ok := getInt(r, "generic"); ok && generic == GenericComm {
+			return true
+		}
+		if reTransient.MatchString(getString(r, "data")) {
+			return true
+		}
+	}
+	return false
+}
+
+// RetryPolicy controls when and how often a RetryRunner retries commands.
+// Zero values are replaced by the defaults.
+type RetryPolicy struct {
+	MaxAttempts int           // attempts including the first, default 5
+	BaseDelay   time.Duration // delay before the first retry, default 500ms
+	MaxDelay    time.Duration // maximum delay between attempts, default 30s
+	Budget      time.Duration // total time after which no more retries start, default 2m
+
+	// Retryable reports whether a failed command should be retried, default IsTransient
+	Retryable func(results []map[interface{}]interface{}, err error) bool
+	// Idempotent reports whether a command may be retried at all, default IsIdempotent
+	Idempotent func(args []string) bool
+}
+
+// DefaultRetryPolicy is used for any unset fields of a RetryPolicy
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    30 * time.Second,
+	Budget:      2 * time.Minute,
+	Retryable:   IsTransient,
+	Idempotent:  IsIdempotent,
+}
+
+// RetryRunner is a Runner which retries commands through another Runner
+// when they fail with transient errors. Only idempotent commands are
+// retried, with exponential backoff and jitter between attempts.
+type RetryRunner struct {
+	runner Runner
+	policy RetryPolicy
+
+	mutex sync.Mutex
+	rand  *rand.Rand
+	sleep func(time.Duration)
+	now   func() time.Time
+}
+
+// NewRetryRunner returns a RetryRunner which runs commands with runner
+func NewRetryRunner(runner Runner, policy RetryPolicy) *RetryRunner {
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = DefaultRetryPolicy.MaxAttempts
+	}
+	if policy.BaseDelay <= 0 {
+		policy.BaseDelay = DefaultRetryPolicy.BaseDelay
+	}
+	if policy.MaxDelay <= 0 {
+		policy.MaxDelay = DefaultRetryPolicy.MaxDelay
+	}
+	if policy.Budget <= 0 {
+		policy.Budget = DefaultRetryPolicy.Budget
+	}
+	if policy.Retryable == nil {
+		policy.Retryable = DefaultRetryPolicy.Retryable
+	}
+	if policy.Idempotent == nil {
+		policy.Idempotent = DefaultRetryPolicy.Idempotent
+	}
+	return &RetryRunner{
+		runner: runner,
+		policy: policy,
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+		sleep:  time.Sleep,
+		now:    time.Now,
+	}
+}
+
+// Run runs a command, retrying it if allowed by the policy. The results and
+// error of the last attempt are returned.
+func (rr *RetryRunner) Run(args []string) ([]map[interface{}]interface{}, error) {
+	start := rr.now()
+	results, err := rr.runner.Run(args)
+	if !rr.policy.Idempotent(args) {
+		return results, err
+	}
+	for attempt := 1; attempt < rr.policy.MaxAttempts && rr.policy.Retryable(results, err); attempt++ {
+		delay := rr.delay(attempt)
+		if rr.now().Sub(start)+delay > rr.policy.Budget {
+			break
+		}
+		rr.sleep(delay)
+		results, err = rr.runner.Run(args)
+	}
+	return results, err
+}
+
+// SaveTxt saves a spec without retrying, if the RetryRunner's Runner can save specs
+func (rr *RetryRunner) SaveTxt(specName string, specContents map[string]string, args ...string) (string, error) {
+	sr, ok := rr.runner.(SpecRunner)
+	if !ok {
+		return "", fmt.Errorf("Runner %T can't save specs", rr.runner)
+	}
+	return sr.SaveTxt(specName, specContents, args...)
+}
+
+// delay returns a random delay before a retry of up to BaseDelay doubled
+// for each previous retry, capped at MaxDelay
+func (rr *RetryRunner) delay(attempt int) time.Duration {
+	max := rr.policy.BaseDelay
+	for i := 1; i < attempt && max < rr.policy.MaxDelay; i++ {
+		max *= 2
+	}
+	if max > rr.policy.MaxDelay {
+		max = rr.policy.MaxDelay
+	}
+	rr.mutex.Lock()
+	defer rr.mutex.Unlock()
+	// Half fixed and half random, so there is always some backoff
+	return max/2 + time.Duration(rr.rand.Int63n(int64(max/2)+1))
+}
@@ -0,0 +1,155 @@
+package p4
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var commError = map[interface{}]interface{}{
+	"code":     "error",
+	"data":     "TCP receive failed.\nread: socket: Connection reset by peer\n",
+	"generic":  "38",
+	"severity": "4",
+}
+
+var changesResult = []map[interface{}]interface{}{{"code": "stat", "change": "1"}}
+
+// newTestRetryRunner returns a RetryRunner with a fake clock, recording its sleeps
+func newTestRetryRunner(runner Runner, policy RetryPolicy, sleeps *[]time.Duration) *RetryRunner {
+	rr := NewRetryRunner(runner, policy)
+	now := time.Unix(1612369118, 0)
+	rr.now = func() time.Time { return now }
+	rr.sleep = func(d time.Duration) {
+		*sleeps = append(*sleeps, d)
+		now = now.Add(d)
+	}
+	return rr
+}
+
+func TestIsTransient(t *testing.T) {
+	assert.False(t, IsTransient(nil, nil))
+	assert.True(t, IsTransient(nil, errors.New("Perforce client error:\n\tConnect to server failed; check $P4PORT.\n\tTCP connect to perforce:1666 failed.\n")))
+	assert.True(t, IsTransient([]map[interface{}]interface{}{commError}, nil))
+	assert.True(t, IsTransient([]map[interface{}]interface{}{{
+		"code":     "error",
+		"data":     "Request too large (over 500000); see 'p4 help maxresults'.\nToo many commands are running on the server.\n",
+		"generic":  "35",
+		"severity": "3",
+	}}, nil))
+	// As decoded from p4 -G
+	assert.True(t, IsTransient([]map[interface{}]interface{}{{
+		"code":     "error",
+		"data":     "Partner exited unexpectedly.\n",
+		"generic":  int32(38),
+		"severity": int32(4),
+	}}, nil))
+	// Fatal errors such as a licence or charset problem are not transient
+	assert.False(t, IsTransient([]map[interface{}]interface{}{{
+		"code":     "error",
+		"data":     "Unicode server permits only unicode enabled clients.\n",
+		"generic":  int32(35),
+		"severity": int32(4),
+	}}, nil))
+	assert.False(t, IsTransient([]map[interface{}]interface{}{{
+		"code":     "error",
+		"data":     "//fake/depot/... - must refer to client 'HOSTNAME'.",
+		"generic":  "2",
+		"severity": "3",
+	}}, nil))
+}
+
+func TestIsIdempotent(t *testing.T) {
+	for _, args := range [][]string{{"fstat", "//..."}, {"-p", "perforce:1666", "sync"}, {"client", "-o", "ws"}, {"login", "-s"}} {
+		assert.True(t, IsIdempotent(args), args)
+	}
+	for _, args := range [][]string{{"submit", "-d", "desc"}, {"client", "-d", "ws"}, {"login"}, {"edit", "file"}, {}} {
+		assert.False(t, IsIdempotent(args), args)
+	}
+}
+
+func TestRetryRunner(t *testing.T) {
+	fp4 := FakeP4Runner{}
+	args := []string{"changes", "-m1"}
+	fp4.On("Run", args).Return([]map[interface{}]interface{}{commError}, nil).Twice()
+	fp4.On("Run", args).Return(changesResult, nil).Once()
+	sleeps := []time.Duration{}
+	rr := newTestRetryRunner(&fp4, RetryPolicy{BaseDelay: time.Second, MaxDelay: 10 * time.Second}, &sleeps)
+	res, err := rr.Run(args)
+	assert.Nil(t, err)
+	assert.Equal(t, changesResult, res)
+	fp4.AssertNumberOfCalls(t, "Run", 3)
+	assert.Equal(t, 2, len(sleeps))
+	assert.True(t, sleeps[0] >= 500*time.Millisecond && sleeps[0] <= time.Second, sleeps[0])
+	assert.True(t, sleeps[1] >= time.Second && sleeps[1] <= 2*time.Second, sleeps[1])
+}
+
+func TestRetryRunnerGivesUp(t *testing.T) {
+	fp4 := FakeP4Runner{}
+	args := []string{"fstat", "//..."}
+	fp4.On("Run", args).Return([]map[interface{}]interface{}{commError}, nil)
+	sleeps := []time.Duration{}
+	rr := newTestRetryRunner(&fp4, RetryPolicy{MaxAttempts: 3}, &sleeps)
+	res, err := rr.Run(args)
+	assert.Nil(t, err)
+	assert.Equal(t, []map[interface{}]interface{}{commError}, res)
+	fp4.AssertNumberOfCalls(t, "Run", 3)
+
+	// Delays double up to the maximum, until the time budget runs out
+	fp4 = FakeP4Runner{}
+	fp4.On("Run", args).Return([]map[interface{}]interface{}{commError}, nil)
+	sleeps = []time.Duration{}
+	rr = newTestRetryRunner(&fp4, RetryPolicy{MaxAttempts: 100, BaseDelay: time.Second, MaxDelay: 4 * time.Second, Budget: 20 * time.Second}, &sleeps)
+	_, _ = rr.Run(args)
+	total := time.Duration(0)
+	for _, d := range sleeps {
+		assert.True(t, d <= 4*time.Second, d)
+		total += d
+	}
+	assert.True(t, total <= 20*time.Second, total)
+	assert.True(t, len(sleeps) >= 5, sleeps)
+	fp4.AssertNumberOfCalls(t, "Run", len(sleeps)+1)
+}
+
+func TestRetryRunnerFatalError(t *testing.T) {
+	fp4 := FakeP4Runner{}
+	args := []string{"fstat", "//..."}
+	licence := []map[interface{}]interface{}{{
+		"code":     "error",
+		"data":     "License count exceeded.\n",
+		"generic":  int32(35),
+		"severity": int32(4),
+	}}
+	fp4.On("Run", args).Return(licence, nil)
+	sleeps := []time.Duration{}
+	rr := newTestRetryRunner(&fp4, RetryPolicy{}, &sleeps)
+	res, err := rr.Run(args)
+	assert.Nil(t, err)
+	assert.Equal(t, licence, res)
+	fp4.AssertNumberOfCalls(t, "Run", 1)
+	assert.Equal(t, 0, len(sleeps))
+}
+
+func TestRetryRunnerWriteCommands(t *testing.T) {
+	fp4 := FakeP4Runner{}
+	args := []string{"submit", "-d", "a change"}
+	fp4.On("Run", args).Return([]map[interface{}]interface{}{commError}, nil)
+	sleeps := []time.Duration{}
+	rr := newTestRetryRunner(&fp4, RetryPolicy{}, &sleeps)
+	res, err := rr.Run(args)
+	assert.Nil(t, err)
+	assert.Equal(t, []map[interface{}]interface{}{commError}, res)
+	fp4.AssertNumberOfCalls(t, "Run", 1)
+	assert.Equal(t, 0, len(sleeps))
+
+	// Unless the policy says otherwise
+	fp4 = FakeP4Runner{}
+	fp4.On("Run", args).Return([]map[interface{}]interface{}{}, errors.New("Too many commands running")).Once()
+	fp4.On("Run", args).Return([]map[interface{}]interface{}{{"code": "stat", "submittedChange": "2"}}, nil).Once()
+	rr = newTestRetryRunner(&fp4, RetryPolicy{Idempotent: func(args []string) bool { return true }}, &sleeps)
+	res, err = rr.Run(args)
+	assert.Nil(t, err)
+	assert.Equal(t, "2", res[0]["submittedChange"])
+}
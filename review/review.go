@@ -0,0 +1,200 @@
+// Package review is a framework for review daemons, which tell users about
+// changes submitted to the paths they review, as listed in the Reviews
+// field of their user specs.
+//
+// A Daemon polls p4 review -t counter for changes submitted since the
+// counter was last set, finds their reviewers with p4 reviews -c and
+// passes each change to a Notifier. The counter is only set to a change
+// once it has been delivered, so if delivery fails or the daemon stops,
+// the change is delivered again next time: delivery is at least once, and
+// Notifiers should allow for repeats.
+package review
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	p4 "github.com/rcowham/go-libp4"
+)
+
+// DefaultInterval is how often Run polls for new changes unless Interval is set
+const DefaultInterval = time.Minute
+
+// Reviewer is a user to tell about a change
+type Reviewer struct {
+	User     string
+	Email    string
+	FullName string
+}
+
+// Event is a submitted change with its reviewers
+type Event struct {
+	Change    int
+	Author    Reviewer    // the user who submitted the change
+	Describe  p4.Describe // from p4 describe -s
+	Reviewers []Reviewer
+}
+
+// Notifier delivers events, e.g. by mail or by posting to a chat channel.
+// An error stops the Daemon and the event is delivered again on the next poll.
+type Notifier interface {
+	Notify(ctx context.Context, ev Event) error
+}
+
+// NotifierFunc lets a function be used as a Notifier
+type NotifierFunc func(ctx context.Context, ev Event) error
+
+// Notify calls f(ctx, ev)
+func (f NotifierFunc) Notify(ctx context.Context, ev Event) error {
+	return f(ctx, ev)
+}
+
+// Daemon polls for submitted changes and notifies their reviewers
+type Daemon struct {
+	Interval    time.Duration // time between polls in Run, DefaultInterval if 0
+	Log         p4.Logger     // logs each change and any errors, if set
+	NotifyEmpty bool          // notify changes with no reviewers, otherwise they are skipped
+
+	runner   p4.Runner
+	counter  string
+	notifier Notifier
+}
+
+// NewDaemon returns a Daemon which runs commands through runner and keeps
+// its place in counter, usually "review"
+func NewDaemon(runner p4.Runner, counter string, notifier Notifier) *Daemon {
+	return &Daemon{runner: runner, counter: counter, notifier: notifier}
+}
+
+func (d *Daemon) debugf(format string, args ...interface{}) {
+	if d.Log != nil {
+		d.Log.Debugf(format, args...)
+	}
+}
+
+// pending runs p4 review -t counter, returning the changes submitted since
+// the counter was set, oldest first, with their authors
+func (d *Daemon) pending() ([]int, map[int]Reviewer, error) {
+	args := []string{"review", "-t", d.counter}
+	res, err := d.runner.Run(args)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to run p4 %s\n%v", args, err)
+	}
+	changes := []int{}
+	authors := map[int]Reviewer{}
+	for _, r := range res {
+		rec := p4.NewRecord(r)
+		if err := rec.Err(); err != nil {
+			return nil, nil, err
+		}
+		n, err := strconv.Atoi(rec.Get("change"))
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to parse change '%s' from p4 %s", rec.Get("change"), args)
+		}
+		if _, ok := authors[n]; !ok {
+			changes = append(changes, n)
+		}
+		authors[n] = Reviewer{User: rec.Get("user"), Email: rec.Get("email"), FullName: rec.Get("name")}
+	}
+	sort.Ints(changes)
+	return changes, authors, nil
+}
+
+// reviewers runs p4 reviews -c change
+func (d *Daemon) reviewers(change int) ([]Reviewer, error) {
+	args := []string{"reviews", "-c", strconv.Itoa(change)}
+	res, err := d.runner.Run(args)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to run p4 %s\n%v", args, err)
+	}
+	reviewers := []Reviewer{}
+	for _, r := range res {
+		rec := p4.NewRecord(r)
+		if err := rec.Err(); err != nil {
+			return nil, err
+		}
+		reviewers = append(reviewers, Reviewer{User: rec.Get("user"), Email: rec.Get("email"), FullName: rec.Get("name")})
+	}
+	return reviewers, nil
+}
+
+// Poll delivers the changes submitted since the counter was set, setting
+// the counter after each one, and returns how many were delivered. It
+// stops at the first error, leaving the counter at the last change delivered.
+// The counter is never moved back if it is already past a change.
+func (d *Daemon) Poll(ctx context.Context) (int, error) {
+	changes, authors, err := d.pending()
+	if err != nil {
+		return 0, err
+	}
+	delivered := 0
+	for _, n := range changes {
+		if err := ctx.Err(); err != nil {
+			return delivered, err
+		}
+		reviewers, err := d.reviewers(n)
+		if err != nil {
+			return delivered, err
+		}
+		if len(reviewers) > 0 || d.NotifyEmpty {
+			desc, err := p4.RunDescribe(d.runner, []string{"-s", strconv.Itoa(n)})
+			if err != nil {
+				return delivered, err
+			}
+			ev := Event{Change: n, Author: authors[n], Describe: desc, Reviewers: reviewers}
+			if err := d.notifier.Notify(ctx, ev); err != nil {
+				return delivered, fmt.Errorf("Failed to notify change %d\n%v", n, err)
+			}
+			d.debugf("Notified %d reviewers of change %d", len(reviewers), n)
+			delivered++
+		}
+		if err := d.advance(n); err != nil {
+			return delivered, err
+		}
+	}
+	return delivered, nil
+}
+
+// advance moves the counter up to change, unless another daemon sharing
+// the counter has already moved it past change. Counters are keys on the
+// server, so p4 key --from --to sets it atomically and the loop only
+// repeats if another daemon changed it in between.
+func (d *Daemon) advance(change int) error {
+	for {
+		current, err := p4.GetKey(d.runner, d.counter)
+		if err != nil {
+			return err
+		}
+		if current >= int64(change) {
+			return nil
+		}
+		set, err := p4.CompareAndSetKey(d.runner, d.counter, current, int64(change))
+		if err != nil || set {
+			return err
+		}
+	}
+}
+
+// Run polls until ctx is done, returning its error. Errors from polling are
+// logged and the poll is tried again after the interval.
+func (d *Daemon) Run(ctx context.Context) error {
+	interval := d.Interval
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := d.Poll(ctx); err != nil && ctx.Err() == nil && d.Log != nil {
+			d.Log.Errorf("Review poll failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
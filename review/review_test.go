@@ -0,0 +1,154 @@
+package review
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeRunner serves p4 review, reviews, describe and key from a list of
+// submitted changes and a review counter
+type fakeRunner struct {
+	changes   map[int][]string // change -> reviewers
+	counter   int
+	failAfter int    // fail p4 reviews for changes after this, if set
+	afterRead func() // called after each read of the counter, if set
+	commands  []string
+}
+
+func (f *fakeRunner) Run(args []string) ([]map[interface{}]interface{}, error) {
+	f.commands = append(f.commands, strings.Join(args, " "))
+	res := []map[interface{}]interface{}{}
+	switch args[0] {
+	case "review":
+		for n := range f.changes {
+			if n > f.counter {
+				res = append(res, map[interface{}]interface{}{"code": "stat", "change": strconv.Itoa(n),
+					"user": "author", "email": "author@example.com", "name": "The Author"})
+			}
+		}
+	case "reviews":
+		n, _ := strconv.Atoi(args[2])
+		if f.failAfter > 0 && n > f.failAfter {
+			return res, errors.New("connect failed")
+		}
+		for _, u := range f.changes[n] {
+			res = append(res, map[interface{}]interface{}{"code": "stat", "user": u, "email": u + "@example.com", "name": u})
+		}
+	case "describe":
+		res = append(res, map[interface{}]interface{}{"code": "stat", "change": args[2], "user": "author",
+			"desc": "Change " + args[2] + "\n", "status": "submitted"})
+	case "key":
+		if args[1] == "--from" {
+			if args[2] != strconv.Itoa(f.counter) {
+				return []map[interface{}]interface{}{{"code": "error", "data": "Key value mismatch.\n",
+					"severity": int32(3), "generic": int32(1)}}, nil
+			}
+			f.counter, _ = strconv.Atoi(args[4])
+			break
+		}
+		res = append(res, map[interface{}]interface{}{"code": "stat", "key": args[1], "value": strconv.Itoa(f.counter)})
+		if f.afterRead != nil {
+			f.afterRead()
+		}
+	}
+	return res, nil
+}
+
+func TestPoll(t *testing.T) {
+	f := &fakeRunner{changes: map[int][]string{3: {"alice", "bob"}, 4: {}, 5: {"bob"}}, counter: 2}
+	events := []Event{}
+	d := NewDaemon(f, "review", NotifierFunc(func(ctx context.Context, ev Event) error {
+		events = append(events, ev)
+		return nil
+	}))
+
+	n, err := d.Poll(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, 5, f.counter)
+	assert.Equal(t, 3, events[0].Change)
+	assert.Equal(t, Reviewer{User: "author", Email: "author@example.com", FullName: "The Author"}, events[0].Author)
+	assert.Equal(t, "Change 3\n", events[0].Describe.Desc)
+	assert.Equal(t, []Reviewer{{"alice", "alice@example.com", "alice"}, {"bob", "bob@example.com", "bob"}}, events[0].Reviewers)
+	assert.Equal(t, 5, events[1].Change)
+	assert.Contains(t, f.commands, "key --from 3 --to 4 review")
+	assert.NotContains(t, f.commands, "describe -s 4")
+
+	n, err = d.Poll(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	d.NotifyEmpty = true
+	f.changes[6] = nil
+	n, err = d.Poll(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+}
+
+func TestPollAtLeastOnce(t *testing.T) {
+	f := &fakeRunner{changes: map[int][]string{1: {"alice"}, 2: {"alice"}, 3: {"alice"}}}
+	delivered := []int{}
+	fail := true
+	d := NewDaemon(f, "review", NotifierFunc(func(ctx context.Context, ev Event) error {
+		if ev.Change == 2 && fail {
+			return errors.New("mail server down")
+		}
+		delivered = append(delivered, ev.Change)
+		return nil
+	}))
+
+	n, err := d.Poll(context.Background())
+	assert.EqualError(t, err, "Failed to notify change 2\nmail server down")
+	assert.Equal(t, 1, n)
+	assert.Equal(t, 1, f.counter)
+
+	fail = false
+	f.failAfter = 2
+	n, err = d.Poll(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, 2, f.counter)
+
+	f.failAfter = 0
+	n, err = d.Poll(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []int{1, 2, 3}, delivered)
+	assert.Equal(t, 3, f.counter)
+}
+
+func TestPollCounterNotMovedBack(t *testing.T) {
+	f := &fakeRunner{changes: map[int][]string{3: {"alice"}, 4: {"alice"}}, counter: 2}
+	d := NewDaemon(f, "review", NotifierFunc(func(ctx context.Context, ev Event) error { return nil }))
+	// Another daemon moves the counter on between this one reading and setting it
+	f.afterRead = func() {
+		f.counter = 10
+		f.afterRead = nil
+	}
+
+	n, err := d.Poll(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, 10, f.counter)
+	assert.Contains(t, f.commands, "key --from 2 --to 3 review")
+	assert.NotContains(t, f.commands, "key --from 10 --to 3 review")
+	assert.NotContains(t, f.commands, "key --from 10 --to 4 review")
+}
+
+func TestRun(t *testing.T) {
+	f := &fakeRunner{changes: map[int][]string{1: {"alice"}}}
+	ctx, cancel := context.WithCancel(context.Background())
+	d := NewDaemon(f, "review", NotifierFunc(func(ctx context.Context, ev Event) error {
+		cancel()
+		return nil
+	}))
+	d.Interval = time.Millisecond
+	assert.Equal(t, context.Canceled, d.Run(ctx))
+	assert.Equal(t, 1, f.counter)
+}
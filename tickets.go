@@ -0,0 +1,205 @@
+package p4
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Ticket is a line from a P4TICKETS file: host:port=user:ticket
+type Ticket struct {
+	Port   string
+	User   string
+	Ticket string
+}
+
+// Trust is a line from a P4TRUST file: host:port=fingerprint
+type Trust struct {
+	Port        string
+	Fingerprint string
+}
+
+// TicketsFile returns the P4TICKETS file p4 uses by default, as set in
+// the environment, a P4CONFIG file or the P4ENVIRO file
+func TicketsFile() string {
+	return NewP4().TicketsFile()
+}
+
+// TrustFile returns the P4TRUST file p4 uses by default, as set in the
+// environment, a P4CONFIG file or the P4ENVIRO file
+func TrustFile() string {
+	return NewP4().TrustFile()
+}
+
+// TicketsFile returns the P4TICKETS file used by commands run by this P4,
+// taking account of WithDir and WithEnv
+func (p4 *P4) TicketsFile() string {
+	if e, err := p4.Effective(); err == nil && e.Tickets.Value != "" {
+		return e.Tickets.Value
+	}
+	return homeFile(".p4tickets", "p4tickets.txt")
+}
+
+// TrustFile returns the P4TRUST file used by commands run by this P4,
+// taking account of WithDir and WithEnv
+func (p4 *P4) TrustFile() string {
+	if e, err := p4.Effective(); err == nil && e.Trust.Value != "" {
+		return e.Trust.Value
+	}
+	return homeFile(".p4trust", "p4trust.txt")
+}
+
+// homeFile returns the path of a file in the user's home directory, which
+// has a different name on Windows
+func homeFile(unixName string, windowsName string) string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("USERPROFILE"), windowsName)
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, unixName)
+}
+
+// splitLines calls f with the key and value of each non-blank key=value line
+func splitLines(r io.Reader, f func(key string, value string) error) error {
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("line %d: missing '=' in %q", lineNo, line)
+		}
+		if err := f(parts[0], parts[1]); err != nil {
+			return fmt.Errorf("line %d: %v", lineNo, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// ParseTickets reads tickets in P4TICKETS format
+func ParseTickets(r io.Reader) ([]Ticket, error) {
+	tickets := []Ticket{}
+	err := splitLines(r, func(port string, value string) error {
+		i := strings.LastIndex(value, ":")
+		if i < 0 {
+			return fmt.Errorf("missing ':' in %q", value)
+		}
+		tickets = append(tickets, Ticket{Port: port, User: value[:i], Ticket: value[i+1:]})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tickets, nil
+}
+
+// WriteTickets writes tickets in P4TICKETS format
+func WriteTickets(w io.Writer, tickets []Ticket) error {
+	for _, t := range tickets {
+		if _, err := fmt.Fprintf(w, "%s=%s:%s\n", t.Port, t.User, t.Ticket); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FindTicket returns the ticket for user on port
+func FindTicket(tickets []Ticket, port string, user string) (Ticket, bool) {
+	for _, t := range tickets {
+		if t.Port == port && t.User == user {
+			return t, true
+		}
+	}
+	return Ticket{}, false
+}
+
+// ReadTicketsFile reads a P4TICKETS file. A file which doesn't exist has no tickets.
+func ReadTicketsFile(fname string) ([]Ticket, error) {
+	f, err := os.Open(fname)
+	if errors.Is(err, os.ErrNotExist) {
+		return []Ticket{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseTickets(f)
+}
+
+// WriteTicketsFile replaces a P4TICKETS file, which is only readable by the user
+func WriteTicketsFile(fname string, tickets []Ticket) error {
+	return writeSecretFile(fname, func(w io.Writer) error { return WriteTickets(w, tickets) })
+}
+
+// ParseTrust reads fingerprints in P4TRUST format
+func ParseTrust(r io.Reader) ([]Trust, error) {
+	trusts := []Trust{}
+	err := splitLines(r, func(port string, fingerprint string) error {
+		trusts = append(trusts, Trust{Port: port, Fingerprint: fingerprint})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return trusts, nil
+}
+
+// WriteTrust writes fingerprints in P4TRUST format
+func WriteTrust(w io.Writer, trusts []Trust) error {
+	for _, t := range trusts {
+		if _, err := fmt.Fprintf(w, "%s=%s\n", t.Port, t.Fingerprint); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadTrustFile reads a P4TRUST file. A file which doesn't exist has no fingerprints.
+func ReadTrustFile(fname string) ([]Trust, error) {
+	f, err := os.Open(fname)
+	if errors.Is(err, os.ErrNotExist) {
+		return []Trust{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseTrust(f)
+}
+
+// WriteTrustFile replaces a P4TRUST file, which is only readable by the user
+func WriteTrustFile(fname string, trusts []Trust) error {
+	return writeSecretFile(fname, func(w io.Writer) error { return WriteTrust(w, trusts) })
+}
+
+// writeSecretFile writes a file with 0600 permissions via a temporary file
+// so that readers never see a partial file
+func writeSecretFile(fname string, write func(w io.Writer) error) error {
+	f, err := os.CreateTemp(filepath.Dir(fname), filepath.Base(fname)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	if err = f.Chmod(0600); err == nil {
+		err = write(f)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, fname)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
@@ -0,0 +1,90 @@
+package p4
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const ticketsFile = `localhost:1666=a.person:8F3A3C8D95F38C4E2C1A0B3B3CB1B2F1
+ssl:perforce.example.com:1666=builder:0123456789ABCDEF0123456789ABCDEF
+
+10.0.0.1:1666=user.with:colon:FEDCBA9876543210FEDCBA9876543210
+`
+
+func TestParseTickets(t *testing.T) {
+	tickets, err := ParseTickets(strings.NewReader(ticketsFile))
+	assert.Nil(t, err)
+	assert.Equal(t, []Ticket{
+		{Port: "localhost:1666", User: "a.person", Ticket: "8F3A3C8D95F38C4E2C1A0B3B3CB1B2F1"},
+		{Port: "ssl:perforce.example.com:1666", User: "builder", Ticket: "0123456789ABCDEF0123456789ABCDEF"},
+		{Port: "10.0.0.1:1666", User: "user.with:colon", Ticket: "FEDCBA9876543210FEDCBA9876543210"},
+	}, tickets)
+
+	tk, ok := FindTicket(tickets, "ssl:perforce.example.com:1666", "builder")
+	assert.True(t, ok)
+	assert.Equal(t, "0123456789ABCDEF0123456789ABCDEF", tk.Ticket)
+	_, ok = FindTicket(tickets, "localhost:1666", "builder")
+	assert.False(t, ok)
+
+	var buf bytes.Buffer
+	assert.Nil(t, WriteTickets(&buf, tickets))
+	assert.Equal(t, strings.Replace(ticketsFile, "\n\n", "\n", 1), buf.String())
+}
+
+func TestParseTicketsInvalid(t *testing.T) {
+	_, err := ParseTickets(strings.NewReader("localhost:1666=a.person:ABC\nrubbish\n"))
+	assert.EqualError(t, err, `line 2: missing '=' in "rubbish"`)
+	_, err = ParseTickets(strings.NewReader("localhost:1666=noticket\n"))
+	assert.EqualError(t, err, `line 1: missing ':' in "noticket"`)
+}
+
+func TestTicketsFile(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), ".p4tickets")
+	tickets, err := ReadTicketsFile(fname)
+	assert.Nil(t, err)
+	assert.Equal(t, []Ticket{}, tickets)
+
+	tickets = append(tickets, Ticket{Port: "localhost:1666", User: "a.person", Ticket: "ABC"})
+	assert.Nil(t, WriteTicketsFile(fname, tickets))
+	fi, err := os.Stat(fname)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	read, err := ReadTicketsFile(fname)
+	assert.Nil(t, err)
+	assert.Equal(t, tickets, read)
+
+	t.Setenv("P4CONFIG", "")
+	t.Setenv("P4TICKETS", fname)
+	assert.Equal(t, fname, TicketsFile())
+
+	// The same file p4 would use, from P4ENVIRO or WithEnv
+	enviro := filepath.Join(t.TempDir(), ".p4enviro")
+	assert.Nil(t, writeToFile(enviro, "P4TICKETS=/enviro/.p4tickets\nP4TRUST=/enviro/.p4trust\n"))
+	t.Setenv("P4ENVIRO", enviro)
+	t.Setenv("P4TICKETS", "")
+	t.Setenv("P4TRUST", "")
+	assert.Equal(t, "/enviro/.p4tickets", TicketsFile())
+	assert.Equal(t, "/enviro/.p4trust", TrustFile())
+	p4 := NewP4(WithEnv("P4TICKETS=/env/.p4tickets"))
+	assert.Equal(t, "/env/.p4tickets", p4.TicketsFile())
+	assert.Equal(t, "/enviro/.p4trust", p4.TrustFile())
+}
+
+func TestTrustFile(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), ".p4trust")
+	assert.Nil(t, os.WriteFile(fname, []byte("ssl:perforce.example.com:1666=AB:CD:EF:01:23\n"), 0600))
+	trusts, err := ReadTrustFile(fname)
+	assert.Nil(t, err)
+	assert.Equal(t, []Trust{{Port: "ssl:perforce.example.com:1666", Fingerprint: "AB:CD:EF:01:23"}}, trusts)
+
+	trusts = append(trusts, Trust{Port: "ssl:other:1666", Fingerprint: "01:02"})
+	assert.Nil(t, WriteTrustFile(fname, trusts))
+	buf, err := os.ReadFile(fname)
+	assert.Nil(t, err)
+	assert.Equal(t, "ssl:perforce.example.com:1666=AB:CD:EF:01:23\nssl:other:1666=01:02\n", string(buf))
+}
@@ -0,0 +1,512 @@
+package p4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"math/bits"
+	"strconv"
+)
+
+// Parsing constants
+const (
+	codeNone      = 'N' //None
+	codeTrue      = 'T' //True
+	codeFalse     = 'F' //False
+	codeInt       = 'i' //integer
+	codeInt2      = 'c' //integer2
+	codeInt64     = 'I' //64 bit integer
+	codeLong      = 'l' //arbitrary size integer
+	codeFloat     = 'g' //float
+	codeTextFloat = 'f' //float as text
+	codeComplex   = 'y' //complex
+	codeTextCmplx = 'x' //complex as text
+	codeString    = 's' //string
+	codeUnicode   = 'u' //unicode string
+	codeTString   = 't' //interned string
+	codeStringRef = 'R' //reference to an interned string
+	codeASCII     = 'a' //ascii string
+	codeASCIIIntn = 'A' //interned ascii string
+	codeShortASC  = 'z' //short ascii string
+	codeShortIntn = 'Z' //short interned ascii string
+	codeTuple     = '(' //tuple
+	codeSmallTup  = ')' //small tuple
+	codeList      = '[' //list
+	codeDict      = '{' //dict
+	codeSet       = '<' //set
+	codeFrozenSet = '>' //frozenset
+	codeRef       = 'r' //reference to a flagged object
+	codeStop      = '0'
+	codeEnd       = 0    //end of the object
+	flagRef       = 0x80 //set on the code of objects which may be referenced later
+	dictInitSize  = 64
+)
+
+// Python marshal format versions, for WithMarshalVersion
+const (
+	MarshalVersionAuto = -1 // accept codes from any version, the default
+	MarshalVersion0    = 0  // Python 2 before 2.4 and p4 -G
+	MarshalVersion1    = 1  // interned strings
+	MarshalVersion2    = 2  // binary floats
+	MarshalVersion3    = 3  // object references
+	MarshalVersion4    = 4  // short ascii strings and tuples
+)
+
+// minVersion is the marshal version which introduced each code added after version 0
+var minVersion = map[byte]int{
+	codeTString: 1, codeStringRef: 1,
+	codeFloat: 2, codeComplex: 2,
+	codeRef:   3,
+	codeASCII: 4, codeASCIIIntn: 4, codeShortASC: 4, codeShortIntn: 4, codeSmallTup: 4,
+}
+
+// Parse error
+var (
+	ErrParse       = errors.New("invalid data")
+	ErrUnknownCode = errors.New("unknown code")
+	ErrTooLarge    = errors.New("value too large")
+	ErrTooDeep     = errors.New("values nested too deeply")
+)
+
+// DefaultMaxAlloc is the largest string or list, in bytes or items, which
+// is decoded unless changed with WithMaxAlloc
+const DefaultMaxAlloc = 64 << 20
+
+// maxDepth limits how deeply lists and dicts may be nested
+const maxDepth = 100
+
+// DecodeError is an error decoding marshalled data, with the offset in the
+// data where it happened. Truncated data gives an Err of io.ErrUnexpectedEOF.
+type DecodeError struct {
+	Offset int
+	Err    error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("Failed to decode marshalled data at offset %d: %v", e.Offset, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// Decoder decodes python marshalled data, as output by p4 -G, checking
+// lengths against the data remaining so corrupt input can't cause huge
+// allocations or panics
+type Decoder struct {
+	data       []byte
+	offset     int
+	maxAlloc   int
+	version    int
+	stringKeys bool
+	keepBytes  bool
+
+	strings []string      // interned strings for codeStringRef
+	refs    []interface{} // flagged objects for codeRef
+}
+
+// DecodeOption sets options on a Decoder
+type DecodeOption func(*Decoder)
+
+// WithMaxAlloc sets the largest string or list a Decoder will decode
+func WithMaxAlloc(n int) DecodeOption {
+	return func(d *Decoder) {
+		d.maxAlloc = n
+	}
+}
+
+// WithMarshalVersion only accepts codes written by a version of the
+// marshal format, rather than those of any version
+func WithMarshalVersion(version int) DecodeOption {
+	return func(d *Decoder) {
+		d.version = version
+	}
+}
+
+// WithStringKeys decodes dicts as map[string]interface{} rather than
+// map[interface{}]interface{}. Keys which aren't strings are formatted
+// with fmt.Sprint.
+func WithStringKeys() DecodeOption {
+	return func(d *Decoder) {
+		d.stringKeys = true
+	}
+}
+
+// WithBytes keeps byte string values, which is how p4 -G writes all
+// values, as []byte rather than converting them to string. This keeps
+// binary file contents from p4 print intact. Dict keys are still strings.
+func WithBytes() DecodeOption {
+	return func(d *Decoder) {
+		d.keepBytes = true
+	}
+}
+
+// NewDecoder returns a Decoder reading data
+func NewDecoder(data []byte, opts ...DecodeOption) *Decoder {
+	d := &Decoder{data: data, maxAlloc: DefaultMaxAlloc, version: MarshalVersionAuto}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// Offset returns the offset in the data of the next value to decode
+func (d *Decoder) Offset() int {
+	return d.offset
+}
+
+// Decode decodes the next value, returning io.EOF if there is no more data.
+// A zero byte, which marks the end of p4 output, decodes as nil.
+//
+// Values are decoded as: None as nil, bools as bool, ints as int32 or
+// int64, longs as int64 or *big.Int if they don't fit, floats as float64,
+// complex numbers as complex128, strings as string, tuples, lists and sets
+// as []interface{} and dicts as map[interface{}]interface{}, unless
+// changed with WithStringKeys or WithBytes.
+func (d *Decoder) Decode() (interface{}, error) {
+	if d.offset >= len(d.data) {
+		return nil, io.EOF
+	}
+	// References are only to objects in the same value
+	d.strings, d.refs = nil, nil
+	return d.value(0)
+}
+
+func (d *Decoder) fail(offset int, err error) error {
+	return &DecodeError{Offset: offset, Err: err}
+}
+
+func (d *Decoder) readByte() (byte, error) {
+	if d.offset >= len(d.data) {
+		return 0, d.fail(d.offset, io.ErrUnexpectedEOF)
+	}
+	b := d.data[d.offset]
+	d.offset++
+	return b, nil
+}
+
+func (d *Decoder) read(n int) ([]byte, error) {
+	if n > len(d.data)-d.offset {
+		return nil, d.fail(d.offset, io.ErrUnexpectedEOF)
+	}
+	b := d.data[d.offset : d.offset+n]
+	d.offset += n
+	return b, nil
+}
+
+func (d *Decoder) readInt32() (int32, error) {
+	b, err := d.read(4)
+	if err != nil {
+		return 0, err
+	}
+	return int32(binary.LittleEndian.Uint32(b)), nil
+}
+
+// readLength reads the length of a string or list, each item of which
+// takes at least minSize bytes
+func (d *Decoder) readLength(minSize int) (int, error) {
+	start := d.offset
+	n, err := d.readInt32()
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return 0, d.fail(start, ErrParse)
+	}
+	if int(n) > d.maxAlloc {
+		return 0, d.fail(start, ErrTooLarge)
+	}
+	if int(n)*minSize > len(d.data)-d.offset {
+		return 0, d.fail(d.offset, io.ErrUnexpectedEOF)
+	}
+	return int(n), nil
+}
+
+func (d *Decoder) value(depth int) (interface{}, error) {
+	start := d.offset
+	code, err := d.readByte()
+	if err != nil {
+		return nil, err
+	}
+	flagged := code&flagRef != 0
+	if flagged {
+		if d.version >= 0 && d.version < 3 {
+			return nil, d.fail(start, ErrUnknownCode)
+		}
+		code &^= flagRef
+	}
+	if v, ok := minVersion[code]; ok && d.version >= 0 && d.version < v {
+		return nil, d.fail(start, ErrUnknownCode)
+	}
+	ref := len(d.refs)
+	if flagged {
+		// Reserved now, as Python does, so references are numbered in order
+		d.refs = append(d.refs, nil)
+	}
+	v, err := d.decode(code, start, depth)
+	if err != nil {
+		return nil, err
+	}
+	if flagged {
+		d.refs[ref] = v
+	}
+	return v, nil
+}
+
+func (d *Decoder) decode(code byte, start int, depth int) (interface{}, error) {
+	switch code {
+	case codeNone, codeEnd:
+		return nil, nil
+	case codeTrue:
+		return true, nil
+	case codeFalse:
+		return false, nil
+	case codeInt, codeInt2:
+		return d.readInt32()
+	case codeInt64:
+		b, err := d.read(8)
+		if err != nil {
+			return nil, err
+		}
+		return int64(binary.LittleEndian.Uint64(b)), nil
+	case codeLong:
+		return d.readLong(start)
+	case codeFloat:
+		return d.readFloat64()
+	case codeTextFloat:
+		return d.readTextFloat()
+	case codeComplex:
+		re, err := d.readFloat64()
+		if err != nil {
+			return nil, err
+		}
+		im, err := d.readFloat64()
+		if err != nil {
+			return nil, err
+		}
+		return complex(re, im), nil
+	case codeTextCmplx:
+		re, err := d.readTextFloat()
+		if err != nil {
+			return nil, err
+		}
+		im, err := d.readTextFloat()
+		if err != nil {
+			return nil, err
+		}
+		return complex(re, im), nil
+	case codeString:
+		s, err := d.readString(false)
+		if err != nil || !d.keepBytes {
+			return s, err
+		}
+		return []byte(s), nil
+	case codeUnicode, codeASCII, codeASCIIIntn:
+		return d.readString(false)
+	case codeShortASC, codeShortIntn:
+		return d.readString(true)
+	case codeTString:
+		s, err := d.readString(false)
+		if err != nil {
+			return nil, err
+		}
+		d.strings = append(d.strings, s)
+		return s, nil
+	case codeStringRef:
+		n, err := d.readInt32()
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 || int(n) >= len(d.strings) {
+			return nil, d.fail(start, ErrParse)
+		}
+		return d.strings[n], nil
+	case codeRef:
+		n, err := d.readInt32()
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 || int(n) >= len(d.refs) {
+			return nil, d.fail(start, ErrParse)
+		}
+		return d.refs[n], nil
+	case codeTuple, codeList, codeSet, codeFrozenSet, codeSmallTup:
+		if depth >= maxDepth {
+			return nil, d.fail(start, ErrTooDeep)
+		}
+		var n int
+		if code == codeSmallTup {
+			b, err := d.readByte()
+			if err != nil {
+				return nil, err
+			}
+			n = int(b)
+		} else {
+			var err error
+			if n, err = d.readLength(1); err != nil {
+				return nil, err
+			}
+		}
+		list := make([]interface{}, 0, n)
+		for i := 0; i < n; i++ {
+			v, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, nil
+	case codeDict:
+		if depth >= maxDepth {
+			return nil, d.fail(start, ErrTooDeep)
+		}
+		return d.readDict(depth)
+	}
+	return nil, d.fail(start, ErrUnknownCode)
+}
+
+func (d *Decoder) readFloat64() (float64, error) {
+	b, err := d.read(8)
+	if err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(b)), nil
+}
+
+// readTextFloat reads a float written as a string with a one byte length
+func (d *Decoder) readTextFloat() (float64, error) {
+	s, err := d.readString(true)
+	if err != nil {
+		return 0, err
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, d.fail(d.offset-len(s), ErrParse)
+	}
+	return f, nil
+}
+
+// readString reads a string with a four byte length, or one byte if short
+func (d *Decoder) readString(short bool) (string, error) {
+	var n int
+	if short {
+		b, err := d.readByte()
+		if err != nil {
+			return "", err
+		}
+		n = int(b)
+	} else {
+		var err error
+		if n, err = d.readLength(1); err != nil {
+			return "", err
+		}
+	}
+	b, err := d.read(n)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// readLong reads an integer stored as 15 bit digits, least significant
+// first, with the sign of the count of digits giving the sign of the number
+func (d *Decoder) readLong(start int) (interface{}, error) {
+	n, err := d.readInt32()
+	if err != nil {
+		return nil, err
+	}
+	count := int64(n)
+	if count < 0 {
+		count = -count
+	}
+	if count > int64(d.maxAlloc) {
+		return nil, d.fail(start+1, ErrTooLarge)
+	}
+	b, err := d.read(int(count) * 2)
+	if err != nil {
+		return nil, err
+	}
+	// Pack the digits straight into words, as shifting a big.Int for each
+	// digit takes quadratic time
+	words := make([]big.Word, (int(count)*15+bits.UintSize-1)/bits.UintSize)
+	for i := 0; i < int(count); i++ {
+		digit := binary.LittleEndian.Uint16(b[i*2:])
+		if digit >= 1<<15 {
+			return nil, d.fail(start, ErrParse)
+		}
+		w, shift := i*15/bits.UintSize, uint(i*15%bits.UintSize)
+		words[w] |= big.Word(digit) << shift
+		if shift+15 > bits.UintSize {
+			words[w+1] |= big.Word(digit) >> (bits.UintSize - shift)
+		}
+	}
+	v := new(big.Int).SetBits(words)
+	if n < 0 {
+		v.Neg(v)
+	}
+	if v.IsInt64() {
+		return v.Int64(), nil
+	}
+	return v, nil
+}
+
+func (d *Decoder) readDict(depth int) (interface{}, error) {
+	dict := make(map[interface{}]interface{})
+	var sdict map[string]interface{}
+	if d.stringKeys {
+		sdict = make(map[string]interface{})
+	}
+	for {
+		if d.offset < len(d.data) && d.data[d.offset] == codeStop {
+			d.offset++
+			if d.stringKeys {
+				return sdict, nil
+			}
+			return dict, nil
+		}
+		keyOffset := d.offset
+		key, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		switch k := key.(type) {
+		case nil, []interface{}, map[interface{}]interface{}, map[string]interface{}:
+			// Not usable as map keys
+			return nil, d.fail(keyOffset, ErrParse)
+		case []byte:
+			key = string(k)
+		}
+		val, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if d.stringKeys {
+			if s, ok := key.(string); ok {
+				sdict[s] = val
+			} else {
+				sdict[fmt.Sprint(key)] = val
+			}
+		} else {
+			dict[key] = val
+		}
+	}
+}
+
+// Unmarshal data serialized by python
+func Unmarshal(buffer *bytes.Buffer) (ret interface{}, retErr error) {
+	ret, _, retErr = Unmarshal2(buffer)
+	return
+}
+
+// Unmarshal2 data serialized by python, returning the unused portion.
+// The data decoded is consumed from buffer, even if there is an error.
+func Unmarshal2(buffer *bytes.Buffer) (ret interface{}, remainder []byte, retErr error) {
+	d := NewDecoder(buffer.Bytes())
+	ret, retErr = d.Decode()
+	buffer.Next(d.Offset())
+	return ret, buffer.Bytes(), retErr
+}
@@ -0,0 +1,185 @@
+package p4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type decodeTest struct {
+	name   string
+	input  string
+	want   interface{}
+	err    error
+	offset int
+}
+
+var decodeTests = []decodeTest{
+	{name: "int", input: "i\x2a\x00\x00\x00", want: int32(42)},
+	{name: "negative int", input: "i\xff\xff\xff\xff", want: int32(-1)},
+	{name: "string", input: "s\x03\x00\x00\x00abc", want: "abc"},
+	{name: "none", input: "N", want: nil},
+	{name: "list", input: "[\x02\x00\x00\x00i\x01\x00\x00\x00s\x01\x00\x00\x00x", want: []interface{}{int32(1), "x"}},
+	{name: "dict", input: "{s\x01\x00\x00\x00ks\x01\x00\x00\x00v0", want: map[interface{}]interface{}{"k": "v"}},
+	{name: "empty", input: "", err: io.EOF},
+	{name: "short int", input: "i\x01\x00", err: io.ErrUnexpectedEOF, offset: 1},
+	{name: "short string", input: "s\x05\x00\x00\x00abc", err: io.ErrUnexpectedEOF, offset: 5},
+	{name: "negative length", input: "s\xff\xff\xff\xff", err: ErrParse, offset: 1},
+	{name: "huge length", input: "s\xff\xff\xff\x7f", err: ErrTooLarge, offset: 1},
+	{name: "huge list", input: "[\x00\x00\x01\x00N", err: io.ErrUnexpectedEOF, offset: 5},
+	{name: "unterminated dict", input: "{s\x01\x00\x00\x00ks\x01\x00\x00\x00v", err: io.ErrUnexpectedEOF, offset: 13},
+	{name: "unknown code", input: "{s\x01\x00\x00\x00kQ", err: ErrUnknownCode, offset: 7},
+	{name: "list key", input: "{[\x00\x00\x00\x00N0", err: ErrParse, offset: 1},
+	{name: "too deep", input: string(bytes.Repeat([]byte("[\x01\x00\x00\x00"), maxDepth+1)) + "N", err: ErrTooDeep, offset: 5 * maxDepth},
+}
+
+func TestDecoder(t *testing.T) {
+	for _, tt := range decodeTests {
+		got, err := NewDecoder([]byte(tt.input)).Decode()
+		if tt.err == nil {
+			assert.NoError(t, err, tt.name)
+			assert.Equal(t, tt.want, got, tt.name)
+			continue
+		}
+		assert.True(t, errors.Is(err, tt.err), "%s: %v", tt.name, err)
+		var de *DecodeError
+		if errors.As(err, &de) {
+			assert.Equal(t, tt.offset, de.Offset, tt.name)
+		}
+	}
+}
+
+func TestMaxAlloc(t *testing.T) {
+	_, err := NewDecoder([]byte("s\x03\x00\x00\x00abc"), WithMaxAlloc(2)).Decode()
+	assert.True(t, errors.Is(err, ErrTooLarge))
+}
+
+func TestUnmarshal2Remainder(t *testing.T) {
+	buf := bytes.NewBufferString("i\x01\x00\x00\x00i\x02\x00\x00\x00")
+	v, rest, err := Unmarshal2(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), v)
+	assert.Equal(t, []byte("i\x02\x00\x00\x00"), rest)
+	_, _, err = Unmarshal2(bytes.NewBuffer(nil))
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestDecodeResultsNotDict(t *testing.T) {
+	_, err := DecodeResults([]byte("s\x01\x00\x00\x00x"))
+	assert.Error(t, err)
+}
+
+// FuzzDecodeResults checks that no input makes decoding panic
+func FuzzDecodeResults(f *testing.F) {
+	for _, pattern := range []string{"testdata/*.bin", "p4unmarshal/*.bin"} {
+		files, _ := filepath.Glob(pattern)
+		for _, fname := range files {
+			data, err := os.ReadFile(fname)
+			if err != nil {
+				f.Fatal(err)
+			}
+			f.Add(data)
+		}
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		results, err := DecodeResults(data)
+		if err == nil {
+			for _, r := range results {
+				assert.NotNil(t, r)
+			}
+		}
+		d := NewDecoder(data)
+		for {
+			offset := d.Offset()
+			if _, err := d.Decode(); err != nil {
+				break
+			}
+			if d.Offset() == offset {
+				t.Fatalf("Decoder made no progress at offset %d", offset)
+			}
+		}
+	})
+}
+
+func TestDecodeMarshalVersions(t *testing.T) {
+	big70, _ := new(big.Int).SetString("1180591620717411303424", 10)
+	want := []map[interface{}]interface{}{
+		{
+			"code": "stat", "depotFile": "//depot/a.txt", "isOpen": true, "isLocked": false,
+			"big": big70, "neg": int32(-5), "size": int64(1 << 40), "ratio": 1.5, "cplx": complex(1, 2),
+			"list": []interface{}{int32(1), "stat"}, "tuple": []interface{}{int32(1), "//depot/a.txt"},
+			"frozen": []interface{}{int32(7)},
+		},
+		{"code": "stat", "depotFile": "//depot/b.txt"},
+	}
+	// Written by Python's marshal.dumps with each version
+	for _, version := range []int{0, 2, 4} {
+		data, err := os.ReadFile(filepath.Join("testdata", fmt.Sprintf("marshal-v%d.bin", version)))
+		assert.NoError(t, err)
+		results, err := DecodeResults(data)
+		assert.NoError(t, err, "version %d", version)
+		assert.Equal(t, want, results, "version %d", version)
+
+		_, err = NewDecoder(data, WithMarshalVersion(version)).Decode()
+		assert.NoError(t, err, "version %d", version)
+		if version > 0 {
+			_, err = NewDecoder(data, WithMarshalVersion(version-1)).Decode()
+			assert.True(t, errors.Is(err, ErrUnknownCode), "version %d", version)
+		}
+	}
+}
+
+func TestDecodeHugeLong(t *testing.T) {
+	// 400,000 digits of 0x7fff, an 800 KB value, is 2^6000000 - 1
+	count := 400000
+	data := make([]byte, 5+count*2)
+	data[0] = 'l'
+	binary.LittleEndian.PutUint32(data[1:], uint32(count))
+	for i := 0; i < count; i++ {
+		binary.LittleEndian.PutUint16(data[5+i*2:], 0x7fff)
+	}
+	start := time.Now()
+	v, err := NewDecoder(data).Decode()
+	elapsed := time.Since(start)
+	assert.NoError(t, err)
+	assert.True(t, elapsed < time.Second, "took %v", elapsed)
+	want := new(big.Int).Lsh(big.NewInt(1), uint(count*15))
+	want.Sub(want, big.NewInt(1))
+	assert.Equal(t, 0, want.Cmp(v.(*big.Int)))
+}
+
+func TestDecodeCodes(t *testing.T) {
+	tests := []decodeTest{
+		{name: "int64", input: "I\xfe\xff\xff\xff\xff\xff\xff\xff", want: int64(-2)},
+		{name: "long", input: "l\x02\x00\x00\x00\x01\x00\x01\x00", want: int64(1<<15 + 1)},
+		{name: "negative long", input: "l\xff\xff\xff\xff\x05\x00", want: int64(-5)},
+		{name: "bad long digit", input: "l\x01\x00\x00\x00\xff\xff", err: ErrParse},
+		{name: "text float", input: "f\x032.5", want: 2.5},
+		{name: "string ref", input: "(\x02\x00\x00\x00t\x01\x00\x00\x00aR\x00\x00\x00\x00", want: []interface{}{"a", "a"}},
+		{name: "bad string ref", input: "R\x00\x00\x00\x00", err: ErrParse},
+		{name: "ref", input: ")\x02\xfa\x01ar\x00\x00\x00\x00", want: []interface{}{"a", "a"}},
+		{name: "bad ref", input: ")\x01r\x01\x00\x00\x00", err: ErrParse},
+		{name: "short ascii", input: "z\x02ab", want: "ab"},
+		{name: "ascii", input: "a\x02\x00\x00\x00ab", want: "ab"},
+		{name: "set", input: "<\x01\x00\x00\x00N", want: []interface{}{nil}},
+		{name: "short truncated", input: "z\x05ab", err: io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		got, err := NewDecoder([]byte(tt.input)).Decode()
+		if tt.err != nil {
+			assert.True(t, errors.Is(err, tt.err), "%s: %v", tt.name, err)
+			continue
+		}
+		assert.NoError(t, err, tt.name)
+		assert.Equal(t, tt.want, got, tt.name)
+	}
+}
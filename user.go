@@ -0,0 +1,347 @@
+package p4
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// User is a user spec, from p4 user -o or a single result from p4 users
+type User struct {
+	User       string
+	Type       string // standard, operator or service
+	Email      string
+	FullName   string
+	AuthMethod string // perforce or ldap
+	Reviews    []string
+	JobView    string
+	Password   string // "******" from p4 user -o, "enabled" from p4 users
+	Update     string
+	Access     string
+}
+
+// HasPassword reports whether the server says the user has a password set
+func (u User) HasPassword() bool {
+	return u.Password != ""
+}
+
+func userFromResult(r map[interface{}]interface{}) User {
+	return User{
+		User:       getString(r, "User"),
+		Type:       getString(r, "Type"),
+		Email:      getString(r, "Email"),
+		FullName:   getString(r, "FullName"),
+		AuthMethod: getString(r, "AuthMethod"),
+		Reviews:    getIndexed(r, "Reviews"),
+		JobView:    getString(r, "JobView"),
+		Password:   getString(r, "Password"),
+		Update:     getString(r, "Update"),
+		Access:     getString(r, "Access"),
+	}
+}
+
+// spec returns the fields of the user suitable for p4 user -i.
+// Update and Access are set by the server so are not included.
+func (u User) spec() map[string]string {
+	spec := map[string]string{}
+	for k, v := range map[string]string{
+		"User":       u.User,
+		"Type":       u.Type,
+		"Email":      u.Email,
+		"FullName":   u.FullName,
+		"AuthMethod": u.AuthMethod,
+		"Reviews":    strings.Join(u.Reviews, "\n"),
+		"JobView":    u.JobView,
+	} {
+		if v != "" {
+			spec[k] = v
+		}
+	}
+	return spec
+}
+
+// RunUsers runs p4 users args...
+func RunUsers(p4r Runner, args []string) ([]User, error) {
+	args = append([]string{"users"}, args...)
+	res, err := p4r.Run(args)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to run p4 %s\n%v", args, err)
+	}
+	us := []User{}
+	for _, r := range res {
+		if getString(r, "code") == "error" {
+			return nil, parseError(r)
+		}
+		us = append(us, userFromResult(r))
+	}
+	return us, nil
+}
+
+// FetchUser runs p4 user -o name
+func FetchUser(p4r Runner, name string) (User, error) {
+	args := []string{"user", "-o", name}
+	res, err := p4r.Run(args)
+	if err != nil {
+		return User{}, fmt.Errorf("Failed to run p4 %s\n%v", args, err)
+	}
+	if len(res) == 0 {
+		return User{}, fmt.Errorf("No output from p4 %s", args)
+	}
+	if getString(res[0], "code") == "error" {
+		return User{}, parseError(res[0])
+	}
+	return userFromResult(res[0]), nil
+}
+
+// SaveUser runs p4 user -i args... with the user spec, e.g. args of "-f"
+// to create or update another user as a super user
+func SaveUser(p4r SpecRunner, u User, args ...string) (string, error) {
+	return p4r.SaveTxt("user", u.spec(), args...)
+}
+
+// DeleteUser runs p4 user -d args... name
+func DeleteUser(p4r Runner, name string, args ...string) error {
+	return runDelete(p4r, "user", name, args)
+}
+
+// Group is a group spec from p4 group -o.
+// Limits are kept as reported, a number or "unset" or "unlimited".
+type Group struct {
+	Group               string
+	MaxResults          string
+	MaxScanRows         string
+	MaxLockTime         string
+	MaxOpenFiles        string
+	MaxMemory           string
+	Timeout             string
+	PasswordTimeout     string
+	LdapConfig          string
+	LdapSearchQuery     string
+	LdapUserAttribute   string
+	LdapUserDNAttribute string
+	Subgroups           []string
+	Owners              []string
+	Users               []string
+}
+
+func groupFromResult(r map[interface{}]interface{}) Group {
+	return Group{
+		Group:               getString(r, "Group"),
+		MaxResults:          getString(r, "MaxResults"),
+		MaxScanRows:         getString(r, "MaxScanRows"),
+		MaxLockTime:         getString(r, "MaxLockTime"),
+		MaxOpenFiles:        getString(r, "MaxOpenFiles"),
+		MaxMemory:           getString(r, "MaxMemory"),
+		Timeout:             getString(r, "Timeout"),
+		PasswordTimeout:     getString(r, "PasswordTimeout"),
+		LdapConfig:          getString(r, "LdapConfig"),
+		LdapSearchQuery:     getString(r, "LdapSearchQuery"),
+		LdapUserAttribute:   getString(r, "LdapUserAttribute"),
+		LdapUserDNAttribute: getString(r, "LdapUserDNAttribute"),
+		Subgroups:           getIndexed(r, "Subgroups"),
+		Owners:              getIndexed(r, "Owners"),
+		Users:               getIndexed(r, "Users"),
+	}
+}
+
+// spec returns the fields of the group suitable for p4 group -i
+func (g Group) spec() map[string]string {
+	spec := map[string]string{}
+	for k, v := range map[string]string{
+		"Group":               g.Group,
+		"MaxResults":          g.MaxResults,
+		"MaxScanRows":         g.MaxScanRows,
+		"MaxLockTime":         g.MaxLockTime,
+		"MaxOpenFiles":        g.MaxOpenFiles,
+		"MaxMemory":           g.MaxMemory,
+		"Timeout":             g.Timeout,
+		"PasswordTimeout":     g.PasswordTimeout,
+		"LdapConfig":          g.LdapConfig,
+		"LdapSearchQuery":     g.LdapSearchQuery,
+		"LdapUserAttribute":   g.LdapUserAttribute,
+		"LdapUserDNAttribute": g.LdapUserDNAttribute,
+		"Subgroups":           strings.Join(g.Subgroups, "\n"),
+		"Owners":              strings.Join(g.Owners, "\n"),
+		"Users":               strings.Join(g.Users, "\n"),
+	} {
+		if v != "" {
+			spec[k] = v
+		}
+	}
+	return spec
+}
+
+// ListGroups runs p4 groups args... and returns the group names in the order
+// reported. Use NewGroupMembership for the users in each group.
+func ListGroups(p4r Runner, args []string) ([]string, error) {
+	res, err := runGroups(p4r, args)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	seen := map[string]bool{}
+	for _, r := range res {
+		g := getString(r, "group")
+		if !seen[g] {
+			seen[g] = true
+			names = append(names, g)
+		}
+	}
+	return names, nil
+}
+
+func runGroups(p4r Runner, args []string) ([]map[interface{}]interface{}, error) {
+	args = append([]string{"groups"}, args...)
+	res, err := p4r.Run(args)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to run p4 %s\n%v", args, err)
+	}
+	for _, r := range res {
+		if getString(r, "code") == "error" {
+			return nil, parseError(r)
+		}
+	}
+	return res, nil
+}
+
+// FetchGroup runs p4 group -o name
+func FetchGroup(p4r Runner, name string) (Group, error) {
+	args := []string{"group", "-o", name}
+	res, err := p4r.Run(args)
+	if err != nil {
+		return Group{}, fmt.Errorf("Failed to run p4 %s\n%v", args, err)
+	}
+	if len(res) == 0 {
+		return Group{}, fmt.Errorf("No output from p4 %s", args)
+	}
+	if getString(res[0], "code") == "error" {
+		return Group{}, parseError(res[0])
+	}
+	return groupFromResult(res[0]), nil
+}
+
+// SaveGroup runs p4 group -i args... with the group spec, e.g. args of "-a"
+// for an owner to update a group they are not a super user for
+func SaveGroup(p4r SpecRunner, g Group, args ...string) (string, error) {
+	return p4r.SaveTxt("group", g.spec(), args...)
+}
+
+// DeleteGroup runs p4 group -d args... name
+func DeleteGroup(p4r Runner, name string, args ...string) error {
+	return runDelete(p4r, "group", name, args)
+}
+
+// runDelete runs p4 <specName> -d args... name for specs which are deleted by name
+func runDelete(p4r Runner, specName string, name string, args []string) error {
+	args = append(append([]string{specName, "-d"}, args...), name)
+	res, err := p4r.Run(args)
+	if err != nil {
+		return fmt.Errorf("Failed to run p4 %s\n%v", args, err)
+	}
+	for _, r := range res {
+		if getString(r, "code") == "error" {
+			return parseError(r)
+		}
+	}
+	return nil
+}
+
+// GroupMembership resolves which users are in a group, including those who
+// are members through nested subgroups
+type GroupMembership struct {
+	users     map[string][]string // group -> direct user members
+	subgroups map[string][]string // group -> direct subgroups
+}
+
+// NewGroupMembership builds the membership of all groups from p4 groups
+func NewGroupMembership(p4r Runner) (*GroupMembership, error) {
+	res, err := runGroups(p4r, []string{})
+	if err != nil {
+		return nil, err
+	}
+	gm := &GroupMembership{
+		users:     map[string][]string{},
+		subgroups: map[string][]string{},
+	}
+	for _, r := range res {
+		group := getString(r, "group")
+		member := getString(r, "user")
+		if getString(r, "isSubGroup") == "1" {
+			gm.subgroups[group] = append(gm.subgroups[group], member)
+		}
+		if getString(r, "isUser") == "1" {
+			gm.users[group] = append(gm.users[group], member)
+		}
+	}
+	return gm, nil
+}
+
+// NewGroupMembershipFromGroups builds the membership from group specs,
+// e.g. as returned by FetchGroup
+func NewGroupMembershipFromGroups(groups []Group) *GroupMembership {
+	gm := &GroupMembership{
+		users:     map[string][]string{},
+		subgroups: map[string][]string{},
+	}
+	for _, g := range groups {
+		gm.users[g.Group] = append(gm.users[g.Group], g.Users...)
+		gm.subgroups[g.Group] = append(gm.subgroups[g.Group], g.Subgroups...)
+	}
+	return gm
+}
+
+// Users returns the sorted set of users in group, expanding subgroups.
+// Cycles between subgroups are allowed and only visited once.
+func (gm *GroupMembership) Users(group string) []string {
+	users := map[string]bool{}
+	visited := map[string]bool{}
+	pending := []string{group}
+	for len(pending) > 0 {
+		g := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if visited[g] {
+			continue
+		}
+		visited[g] = true
+		for _, u := range gm.users[g] {
+			users[u] = true
+		}
+		pending = append(pending, gm.subgroups[g]...)
+	}
+	result := make([]string, 0, len(users))
+	for u := range users {
+		result = append(result, u)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// IsMember reports whether user is in group directly or through a subgroup
+func (gm *GroupMembership) IsMember(user string, group string) bool {
+	for _, u := range gm.Users(group) {
+		if u == user {
+			return true
+		}
+	}
+	return false
+}
+
+// Groups returns the sorted set of groups that user is a member of, directly
+// or through a subgroup
+func (gm *GroupMembership) Groups(user string) []string {
+	names := map[string]bool{}
+	for g := range gm.users {
+		names[g] = true
+	}
+	for g := range gm.subgroups {
+		names[g] = true
+	}
+	result := []string{}
+	for g := range names {
+		if gm.IsMember(user, g) {
+			result = append(result, g)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,242 @@
+package p4
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type usersTest struct {
+	res  []map[interface{}]interface{}
+	want []User
+}
+
+var usersTests = []usersTest{
+	{
+		res:  []map[interface{}]interface{}{},
+		want: []User{},
+	},
+	{
+		res: []map[interface{}]interface{}{
+			{
+				"code":     "stat",
+				"User":     "a.person",
+				"Email":    "a.person@email.com",
+				"Update":   "1612369118",
+				"Access":   "1612369200",
+				"FullName": "A Person",
+				"Type":     "standard",
+				"Password": "enabled",
+			},
+			{
+				"code":     "stat",
+				"User":     "builder",
+				"Email":    "builder@email.com",
+				"Update":   "1612369118",
+				"Access":   "1612369118",
+				"FullName": "Build service",
+				"Type":     "service",
+			},
+		},
+		want: []User{
+			{
+				User:     "a.person",
+				Email:    "a.person@email.com",
+				Update:   "1612369118",
+				Access:   "1612369200",
+				FullName: "A Person",
+				Type:     "standard",
+				Password: "enabled",
+				Reviews:  []string{},
+			},
+			{
+				User:     "builder",
+				Email:    "builder@email.com",
+				Update:   "1612369118",
+				Access:   "1612369118",
+				FullName: "Build service",
+				Type:     "service",
+				Reviews:  []string{},
+			},
+		},
+	},
+}
+
+func TestUsers(t *testing.T) {
+	for _, tst := range usersTests {
+		fp4 := FakeP4Runner{}
+		fp4.On("Run", []string{"users", "-a"}).Return(tst.res, nil)
+		us, err := RunUsers(&fp4, []string{"-a"})
+		assert.Nil(t, err)
+		assert.Equal(t, tst.want, us)
+	}
+}
+
+func TestFetchUser(t *testing.T) {
+	fp4 := FakeP4Runner{}
+	fp4.On("Run", []string{"user", "-o", "a.person"}).Return([]map[interface{}]interface{}{{
+		"code":       "stat",
+		"User":       "a.person",
+		"Type":       "standard",
+		"Email":      "a.person@email.com",
+		"FullName":   "A Person",
+		"AuthMethod": "perforce",
+		"Reviews0":   "//depot/main/...",
+		"Reviews1":   "//depot/rel/...",
+		"JobView":    "status=open",
+		"Password":   "******",
+		"Update":     "2021/02/03 16:18:38",
+		"Access":     "2021/02/03 16:20:00",
+	}}, nil)
+	u, err := FetchUser(&fp4, "a.person")
+	assert.Nil(t, err)
+	assert.Equal(t, User{
+		User:       "a.person",
+		Type:       "standard",
+		Email:      "a.person@email.com",
+		FullName:   "A Person",
+		AuthMethod: "perforce",
+		Reviews:    []string{"//depot/main/...", "//depot/rel/..."},
+		JobView:    "status=open",
+		Password:   "******",
+		Update:     "2021/02/03 16:18:38",
+		Access:     "2021/02/03 16:20:00",
+	}, u)
+	assert.True(t, u.HasPassword())
+}
+
+func TestFetchUserError(t *testing.T) {
+	fp4 := FakeP4Runner{}
+	fp4.On("Run", []string{"user", "-o", "nobody"}).Return([]map[interface{}]interface{}{{
+		"code":     "error",
+		"data":     "Access for user 'nobody' has not been enabled by 'p4 protect'.",
+		"generic":  "6",
+		"severity": "3",
+	}}, nil)
+	_, err := FetchUser(&fp4, "nobody")
+	assert.Equal(t, errors.New("P4Error -> Access for user 'nobody' has not been enabled by 'p4 protect'."), err)
+}
+
+func TestSaveUser(t *testing.T) {
+	fp4 := FakeP4Runner{}
+	fp4.On("SaveTxt", "user", map[string]string{
+		"User":     "a.person",
+		"Email":    "a.person@email.com",
+		"FullName": "A Person",
+		"Reviews":  "//depot/main/...\n//depot/rel/...",
+	}, []string{"-f"}).Return("User a.person saved.\n", nil)
+	res, err := SaveUser(&fp4, User{
+		User:     "a.person",
+		Email:    "a.person@email.com",
+		FullName: "A Person",
+		Reviews:  []string{"//depot/main/...", "//depot/rel/..."},
+		Update:   "2021/02/03 16:18:38",
+	}, "-f")
+	assert.Nil(t, err)
+	assert.Equal(t, "User a.person saved.\n", res)
+}
+
+func TestDeleteUser(t *testing.T) {
+	fp4 := FakeP4Runner{}
+	fp4.On("Run", []string{"user", "-d", "-f", "a.person"}).Return([]map[interface{}]interface{}{{
+		"code":  "info",
+		"data":  "User a.person deleted.",
+		"level": "0",
+	}}, nil)
+	err := DeleteUser(&fp4, "a.person", "-f")
+	assert.Nil(t, err)
+}
+
+func TestFetchGroup(t *testing.T) {
+	fp4 := FakeP4Runner{}
+	fp4.On("Run", []string{"group", "-o", "devs"}).Return([]map[interface{}]interface{}{{
+		"code":            "stat",
+		"Group":           "devs",
+		"MaxResults":      "unset",
+		"MaxScanRows":     "unset",
+		"MaxLockTime":     "unset",
+		"MaxOpenFiles":    "unset",
+		"MaxMemory":       "unset",
+		"Timeout":         "43200",
+		"PasswordTimeout": "unset",
+		"Subgroups0":      "contractors",
+		"Owners0":         "lead",
+		"Users0":          "alice",
+		"Users1":          "bob",
+	}}, nil)
+	g, err := FetchGroup(&fp4, "devs")
+	assert.Nil(t, err)
+	assert.Equal(t, Group{
+		Group:           "devs",
+		MaxResults:      "unset",
+		MaxScanRows:     "unset",
+		MaxLockTime:     "unset",
+		MaxOpenFiles:    "unset",
+		MaxMemory:       "unset",
+		Timeout:         "43200",
+		PasswordTimeout: "unset",
+		Subgroups:       []string{"contractors"},
+		Owners:          []string{"lead"},
+		Users:           []string{"alice", "bob"},
+	}, g)
+	assert.Equal(t, map[string]string{
+		"Group":           "devs",
+		"MaxResults":      "unset",
+		"MaxScanRows":     "unset",
+		"MaxLockTime":     "unset",
+		"MaxOpenFiles":    "unset",
+		"MaxMemory":       "unset",
+		"Timeout":         "43200",
+		"PasswordTimeout": "unset",
+		"Subgroups":       "contractors",
+		"Owners":          "lead",
+		"Users":           "alice\nbob",
+	}, g.spec())
+}
+
+func groupsResult(group string, member string, isSubGroup string, isOwner string, isUser string) map[interface{}]interface{} {
+	return map[interface{}]interface{}{
+		"code":       "stat",
+		"group":      group,
+		"user":       member,
+		"isSubGroup": isSubGroup,
+		"isOwner":    isOwner,
+		"isUser":     isUser,
+	}
+}
+
+func TestGroupMembership(t *testing.T) {
+	fp4 := FakeP4Runner{}
+	fp4.On("Run", []string{"groups"}).Return([]map[interface{}]interface{}{
+		groupsResult("all", "devs", "1", "0", "0"),
+		groupsResult("all", "admin", "0", "1", "0"),
+		groupsResult("contractors", "carol", "0", "0", "1"),
+		groupsResult("contractors", "all", "1", "0", "0"), // cycle back to all
+		groupsResult("devs", "alice", "0", "0", "1"),
+		groupsResult("devs", "bob", "0", "1", "1"),
+		groupsResult("devs", "contractors", "1", "0", "0"),
+	}, nil)
+	gm, err := NewGroupMembership(&fp4)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"alice", "bob", "carol"}, gm.Users("all"))
+	assert.Equal(t, []string{"alice", "bob", "carol"}, gm.Users("devs"))
+	assert.Equal(t, []string{}, gm.Users("unknown"))
+	assert.True(t, gm.IsMember("carol", "devs"))
+	assert.False(t, gm.IsMember("admin", "all"))
+	assert.Equal(t, []string{"all", "contractors", "devs"}, gm.Groups("alice"))
+
+	names, err := ListGroups(&fp4, []string{})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"all", "contractors", "devs"}, names)
+}
+
+func TestGroupMembershipFromGroups(t *testing.T) {
+	gm := NewGroupMembershipFromGroups([]Group{
+		{Group: "devs", Users: []string{"bob", "alice"}, Subgroups: []string{"qa"}},
+		{Group: "qa", Users: []string{"quinn"}},
+	})
+	assert.Equal(t, []string{"alice", "bob", "quinn"}, gm.Users("devs"))
+	assert.Equal(t, []string{"quinn"}, gm.Users("qa"))
+	assert.Equal(t, []string{"devs", "qa"}, gm.Groups("quinn"))
+}
@@ -0,0 +1,206 @@
+package p4
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Version is a Perforce product version such as
+// P4D/LINUX26X86_64/2021.1/2156517 (2021/05/31)
+type Version struct {
+	Product  string // P4 or P4D
+	Platform string // e.g. LINUX26X86_64
+	Release  string // e.g. 2021.1
+	Change   string // change the product was built from
+	Date     string // e.g. 2021/05/31
+}
+
+func (v Version) String() string {
+	s := strings.Join([]string{v.Product, v.Platform, v.Release, v.Change}, "/")
+	if v.Date != "" {
+		s += " (" + v.Date + ")"
+	}
+	return s
+}
+
+// AtLeast reports whether the version's release is the same as or later
+// than release, e.g. "2019.1"
+func (v Version) AtLeast(release string) bool {
+	return compareReleases(v.Release, release) >= 0
+}
+
+// ParseVersion parses a version as reported by p4 -V or p4 info
+func ParseVersion(s string) (Version, error) {
+	s = strings.TrimSpace(s)
+	v := Version{}
+	if i := strings.Index(s, " ("); i >= 0 {
+		v.Date = strings.TrimSuffix(strings.TrimSuffix(s[i+2:], "."), ")")
+		s = s[:i]
+	}
+	parts := strings.Split(s, "/")
+	if len(parts) < 4 {
+		return Version{}, fmt.Errorf("Failed to parse version '%s'", s)
+	}
+	// Platforms such as NTX64 don't contain '/', but allow for any that do
+	v.Product = parts[0]
+	v.Platform = strings.Join(parts[1:len(parts)-2], "/")
+	v.Release = parts[len(parts)-2]
+	v.Change = parts[len(parts)-1]
+	return v, nil
+}
+
+// compareReleases compares releases such as 2019.1 numerically, returning
+// -1, 0 or 1. Any suffix such as .BETA is ignored.
+func compareReleases(a string, b string) int {
+	pa := strings.Split(a, ".")
+	pb := strings.Split(b, ".")
+	for i := 0; i < 2; i++ {
+		var na, nb int
+		if i < len(pa) {
+			na, _ = strconv.Atoi(pa[i])
+		}
+		if i < len(pb) {
+			nb, _ = strconv.Atoi(pb[i])
+		}
+		if na < nb {
+			return -1
+		}
+		if na > nb {
+			return 1
+		}
+	}
+	return 0
+}
+
+// parseClientVersion finds the version in the output of p4 -V, which is on
+// a line such as: Rev. P4/LINUX26X86_64/2021.1/2156517 (2021/05/31).
+func parseClientVersion(output []byte) (Version, error) {
+	for _, line := range bytes.Split(output, []byte("\n")) {
+		s := strings.TrimSpace(string(line))
+		if strings.HasPrefix(s, "Rev. ") {
+			return ParseVersion(strings.TrimSuffix(strings.TrimPrefix(s, "Rev. "), "."))
+		}
+	}
+	return Version{}, fmt.Errorf("No version found in p4 -V output: %s", output)
+}
+
+// lookupCall is a lookup which is running, for other callers to wait for
+type lookupCall[T any] struct {
+	done  chan struct{}
+	value T
+	err   error
+}
+
+// cachedLookup keeps the result of a command such as p4 -V. Only one
+// command runs at a time and callers which ask while it is running wait
+// for its result. Failures are not kept, so the next caller tries again.
+type cachedLookup[T any] struct {
+	mu    sync.Mutex
+	value *T
+	call  *lookupCall[T]
+}
+
+// get returns the cached value, or the result of run
+func (l *cachedLookup[T]) get(run func() (T, error)) (T, error) {
+	l.mu.Lock()
+	if l.value != nil {
+		v := *l.value
+		l.mu.Unlock()
+		return v, nil
+	}
+	if c := l.call; c != nil {
+		l.mu.Unlock()
+		<-c.done
+		return c.value, c.err
+	}
+	c := &lookupCall[T]{done: make(chan struct{})}
+	l.call = c
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		if c.err == nil {
+			l.value = &c.value
+		}
+		l.call = nil
+		l.mu.Unlock()
+		close(c.done)
+	}()
+	c.value, c.err = run()
+	return c.value, c.err
+}
+
+// ClientVersion returns the version of the p4 binary from p4 -V.
+// The version is cached, so p4 -V only runs once for each P4 unless it fails.
+func (p4 *P4) ClientVersion() (Version, error) {
+	return p4.clientVersion.get(func() (Version, error) {
+		args := []string{"-V"}
+		cmd := p4.command(args)
+		ev := p4.startEvent(args)
+		out, err := cmd.Output()
+		p4.finishEvent(ev, cmd, len(out), nil, err)
+		if err != nil {
+			return Version{}, fmt.Errorf("Failed to run %s -V\n%v", p4.Executable(), err)
+		}
+		return parseClientVersion(out)
+	})
+}
+
+// ServerVersion returns the version of the server from p4 info.
+// It is only run once for each P4.
+func (p4 *P4) ServerVersion() (Version, error) {
+	si, err := p4.Info()
+	if err != nil {
+		return Version{}, err
+	}
+	if si.ServerVersion == (Version{}) {
+		return ParseVersion(si.ServerVersionString)
+	}
+	return si.ServerVersion, nil
+}
+
+// ClientAtLeast reports whether the p4 binary is release or later, e.g. "2019.1"
+func (p4 *P4) ClientAtLeast(release string) (bool, error) {
+	v, err := p4.ClientVersion()
+	if err != nil {
+		return false, err
+	}
+	return v.AtLeast(release), nil
+}
+
+// ServerAtLeast reports whether the server is release or later, e.g. "2019.1"
+func (p4 *P4) ServerAtLeast(release string) (bool, error) {
+	v, err := p4.ServerVersion()
+	if err != nil {
+		return false, err
+	}
+	return v.AtLeast(release), nil
+}
+
+// supports reports whether both the p4 binary and server are release or later.
+// Any error finding the versions is treated as not supported.
+func (p4 *P4) supports(release string) bool {
+	client, err := p4.ClientAtLeast(release)
+	if err != nil || !client {
+		return false
+	}
+	server, err := p4.ServerAtLeast(release)
+	return err == nil && server
+}
+
+// SupportsParallelSync reports whether sync --parallel can be used (2014.1)
+func (p4 *P4) SupportsParallelSync() bool {
+	return p4.supports("2014.1")
+}
+
+// SupportsParallelSubmit reports whether submit --parallel can be used (2015.1)
+func (p4 *P4) SupportsParallelSubmit() bool {
+	return p4.supports("2015.1")
+}
+
+// SupportsGraphDepots reports whether graph depots (git repos) are available (2017.1)
+func (p4 *P4) SupportsGraphDepots() bool {
+	return p4.supports("2017.1")
+}
@@ -0,0 +1,149 @@
+package p4
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const clientVersionOutput = `Perforce - The Fast Software Configuration Management System.
+Copyright 1995-2021 Perforce Software.  All rights reserved.
+This product includes software developed by the OpenSSL Project
+for use in the OpenSSL Toolkit (http://www.openssl.org/)
+Version of OpenSSL Libraries: OpenSSL 1.1.1k  25 Mar 2021
+See 'p4 help [ -l ] legal' for additional license information on
+these licenses and others.
+Extensions/scripting support built-in.
+Parallel sync threading built-in.
+Rev. P4/LINUX26X86_64/2021.1/2156517 (2021/05/31).
+`
+
+func TestParseVersion(t *testing.T) {
+	v, err := ParseVersion("P4D/MACOSX1010X86_64/2019.1/1796703 (2019/04/30)")
+	assert.Nil(t, err)
+	assert.Equal(t, Version{Product: "P4D", Platform: "MACOSX1010X86_64", Release: "2019.1", Change: "1796703", Date: "2019/04/30"}, v)
+	assert.Equal(t, "P4D/MACOSX1010X86_64/2019.1/1796703 (2019/04/30)", v.String())
+	assert.True(t, v.AtLeast("2019.1"))
+	assert.True(t, v.AtLeast("2018.2"))
+	assert.True(t, v.AtLeast("2014"))
+	assert.False(t, v.AtLeast("2019.2"))
+	assert.False(t, v.AtLeast("2020.1"))
+
+	v, err = ParseVersion("P4/NTX64/2022.2.BETA/2351234")
+	assert.Nil(t, err)
+	assert.Equal(t, "2022.2.BETA", v.Release)
+	assert.True(t, v.AtLeast("2022.2"))
+
+	_, err = ParseVersion("rubbish")
+	assert.NotNil(t, err)
+}
+
+func TestParseClientVersion(t *testing.T) {
+	v, err := parseClientVersion([]byte(clientVersionOutput))
+	assert.Nil(t, err)
+	assert.Equal(t, Version{Product: "P4", Platform: "LINUX26X86_64", Release: "2021.1", Change: "2156517", Date: "2021/05/31"}, v)
+}
+
+func TestVersionDetection(t *testing.T) {
+	dir := t.TempDir()
+	versionFile := filepath.Join(dir, "version.txt")
+	assert.Nil(t, writeToFile(versionFile, clientVersionOutput))
+	info, err := filepath.Abs(filepath.Join(testRoot, "..", "testdata", "info.bin"))
+	assert.Nil(t, err)
+	counter := filepath.Join(dir, "count")
+	exe := fakeP4Executable(t, fmt.Sprintf(`echo run >> %s
+case "$*" in
+*-V*) cat %s ;;
+*info*) cat %s ;;
+esac
+`, counter, versionFile, info))
+
+	p4 := NewP4(WithExecutable(exe))
+	assert.Equal(t, exe, p4.Executable())
+	assert.Equal(t, "p4", NewP4().Executable())
+	for i := 0; i < 2; i++ {
+		cv, err := p4.ClientVersion()
+		assert.Nil(t, err)
+		assert.Equal(t, "2021.1", cv.Release)
+		sv, err := p4.ServerVersion()
+		assert.Nil(t, err)
+		assert.Equal(t, Version{Product: "P4D", Platform: "MACOSX1010X86_64", Release: "2019.1", Change: "1796703", Date: "2019/04/30"}, sv)
+	}
+	ok, err := p4.ServerAtLeast("2019.1")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = p4.ClientAtLeast("2021.2")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.True(t, p4.SupportsParallelSync())
+	assert.True(t, p4.SupportsGraphDepots())
+
+	// Each version is only looked up once
+	runs, err := os.ReadFile(counter)
+	assert.Nil(t, err)
+	assert.Equal(t, "run\nrun\n", string(runs))
+}
+
+func TestVersionDetectionConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	versionFile := filepath.Join(dir, "version.txt")
+	assert.Nil(t, writeToFile(versionFile, clientVersionOutput))
+	info, err := filepath.Abs(filepath.Join(testRoot, "..", "testdata", "info.bin"))
+	assert.Nil(t, err)
+	counter := filepath.Join(dir, "count")
+	exe := fakeP4Executable(t, fmt.Sprintf(`echo run >> %s
+sleep 0.2
+case "$*" in
+*-V*) cat %s ;;
+*info*) cat %s ;;
+esac
+`, counter, versionFile, info))
+
+	p4 := NewP4(WithExecutable(exe))
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			cv, err := p4.ClientVersion()
+			assert.Nil(t, err)
+			assert.Equal(t, "2021.1", cv.Release)
+		}()
+		go func() {
+			defer wg.Done()
+			si, err := p4.Info()
+			assert.Nil(t, err)
+			assert.Equal(t, "2019.1", si.ServerVersion.Release)
+		}()
+	}
+	wg.Wait()
+
+	// Callers which ask while a lookup is running wait for it
+	runs, err := os.ReadFile(counter)
+	assert.Nil(t, err)
+	assert.Equal(t, "run\nrun\n", string(runs))
+}
+
+func TestCachedLookupFailure(t *testing.T) {
+	var l cachedLookup[int]
+	calls := 0
+	run := func() (int, error) {
+		calls++
+		if calls == 1 {
+			return 0, fmt.Errorf("connect failed")
+		}
+		return calls, nil
+	}
+	_, err := l.get(run)
+	assert.NotNil(t, err)
+	v, err := l.get(run)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, v)
+	v, err = l.get(run)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, v)
+}